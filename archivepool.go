@@ -0,0 +1,72 @@
+package main
+
+import "sync"
+
+// ArchivePool builds chapters' archives on goroutines of its own, so that
+// compressing a finished chapter doesn't hold up downloading the next ones.
+// Once its archive is built, a chapter's end is passed on to Then.
+type ArchivePool struct {
+	Archiver Observer
+	Then     Observer
+
+	jobs chan Metadata
+	wg   sync.WaitGroup
+}
+
+func NewArchivePool(archiver, then Observer, workers int) *ArchivePool {
+	p := &ArchivePool{
+		Archiver: archiver,
+		Then:     then,
+		jobs:     make(chan Metadata, 64),
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *ArchivePool) work() {
+	for info := range p.jobs {
+		p.Archiver.OnChapterEnd(info)
+		if info["incomplete"] == true {
+			// nothing was saved, it's waiting for a later run
+			p.OnFailure(info, errIncomplete)
+		} else {
+			p.Then.OnChapterEnd(info)
+		}
+		p.wg.Done()
+	}
+}
+
+func (p *ArchivePool) OnPageEnd(info Metadata) {
+	p.Archiver.OnPageEnd(info)
+	p.Then.OnPageEnd(info)
+}
+
+func (p *ArchivePool) OnChapterEnd(info Metadata) {
+	p.wg.Add(1)
+	p.jobs <- info
+}
+
+func (p *ArchivePool) OnFailure(info Metadata, err error) {
+	if f, ok := p.Then.(FailureObserver); ok {
+		f.OnFailure(info, err)
+	}
+}
+
+func (p *ArchivePool) OnChapterQueued(info Metadata) {
+	if q, ok := p.Then.(QueueObserver); ok {
+		q.OnChapterQueued(info)
+	}
+}
+
+func (p *ArchivePool) OnPagesQueued(info Metadata, pages int) {
+	if q, ok := p.Then.(QueueObserver); ok {
+		q.OnPagesQueued(info, pages)
+	}
+}
+
+// Wait waits for every archive queued so far to be built.
+func (p *ArchivePool) Wait() {
+	p.wg.Wait()
+}
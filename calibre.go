@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// CalibreConfig is how the Calibre library downloads are added to is set up
+// in the config.
+type CalibreConfig struct {
+	// Library is the library's directory, or a content server's URL like
+	// http://localhost:8080/#library_id.
+	Library  string `json:"library"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Command is calibredb, if it's not in the PATH.
+	Command string `json:"command,omitempty"`
+}
+
+// CalibreObserver adds every finished chapter to a Calibre library with
+// calibredb, as a book in the series with the chapter's number as its index.
+// Calibre itself skips chapters it already has.
+type CalibreObserver struct {
+	CalibreConfig
+}
+
+func (o CalibreObserver) OnPageEnd(info Metadata) {}
+
+func (o CalibreObserver) OnChapterEnd(info Metadata) {
+	path, _ := info["path"].(string)
+	if path == "" || !isFile(path) {
+		// calibre wants books, not directories of pages
+		return
+	}
+
+	command := o.Command
+	if command == "" {
+		command = "calibredb"
+	}
+	cmd := exec.Command(command, o.args(info, path)...)
+	// the password goes in this way, where ps can't see it
+	cmd.Stdin = strings.NewReader(o.Password + "\n")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("%s: cannot add to calibre: %s", path, err)
+	}
+}
+
+func (o CalibreObserver) args(info Metadata, path string) []string {
+	args := []string{"add", "--with-library", o.Library}
+	if o.Username != "" {
+		args = append(args, "--username", o.Username, "--password", "<stdin>")
+	}
+
+	args = append(args, "--title", bookTitle(info), "--series", fmt.Sprint(info["manga"]))
+	if index := seriesIndex(info["chapter"]); index != "" {
+		args = append(args, "--series-index", index)
+	}
+
+	if author, _ := info["author"].(string); author != "" {
+		args = append(args, "--authors", author)
+	}
+	tags := []string{"manga"}
+	if genres, ok := info["genres"].([]string); ok {
+		tags = append(tags, genres...)
+	}
+	args = append(args, "--tags", strings.Join(tags, ","))
+	if language, _ := info["language"].(string); language != "" {
+		args = append(args, "--languages", language)
+	}
+	return append(args, "--", path)
+}
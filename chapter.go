@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ChapterNumber is a chapter's number the way sites write them: 10, 10.5 or
+// 22a.
+type ChapterNumber struct {
+	Major  int
+	Minor  string // the digits after the point, if any
+	Suffix string // the letters after that, if any
+}
+
+var chapterNumberRE = regexp.MustCompile(`^(?i:ch(?:apter)?\.?)?\s*(\d+)(?:\.(\d+))?\s*([a-zA-Z]*)$`)
+
+func ParseChapterNumber(s string) (ChapterNumber, bool) {
+	match := chapterNumberRE.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return ChapterNumber{}, false
+	}
+
+	major, err := strconv.Atoi(match[1])
+	if err != nil {
+		return ChapterNumber{}, false
+	}
+	return ChapterNumber{major, match[2], strings.ToLower(match[3])}, true
+}
+
+func (c ChapterNumber) String() string {
+	return c.Pad(0)
+}
+
+// Pad formats the number with the integer part zero-padded to width.
+func (c ChapterNumber) Pad(width int) string {
+	s := fmt.Sprintf("%0*d", width, c.Major)
+	if c.Minor != "" {
+		s += "." + c.Minor
+	}
+	return s + c.Suffix
+}
+
+// Less orders chapters the way they're read: 10 < 10.25 < 10.5 < 10.5a < 11.
+func (c ChapterNumber) Less(other ChapterNumber) bool {
+	if c.Major != other.Major {
+		return c.Major < other.Major
+	}
+	if c.Minor != other.Minor {
+		// compare them as the fractions they are
+		x, _ := strconv.ParseFloat("0."+c.Minor, 64)
+		y, _ := strconv.ParseFloat("0."+other.Minor, 64)
+		if x != y {
+			return x < y
+		}
+		return len(c.Minor) < len(other.Minor)
+	}
+	return c.Suffix < other.Suffix
+}
+
+// maxChapter returns the highest whole chapter number among chapters, which
+// is what decides how wide the numbers have to be padded to; a series can
+// list 300 chapters and still go up to 1045.
+func maxChapter(chapters []string) int {
+	highest := 0
+	for _, c := range chapters {
+		if n, ok := ParseChapterNumber(c); ok && n.Major > highest {
+			highest = n.Major
+		}
+	}
+	return highest
+}
+
+// chapterLess orders chapter numbers given as strings, falling back to plain
+// string comparison for those that aren't numbers at all.
+func chapterLess(a, b string) bool {
+	x, okA := ParseChapterNumber(a)
+	y, okB := ParseChapterNumber(b)
+	switch {
+	case okA && okB:
+		return x.Less(y)
+	case okA != okB:
+		// numbered chapters come first
+		return okA
+	}
+	return a < b
+}
+
+// chapterMetadata turns a scraped chapter number into a ChapterNumber if it
+// is one; otherwise it's kept as it is.
+func chapterMetadata(s string) interface{} {
+	if c, ok := ParseChapterNumber(s); ok {
+		return c
+	}
+	return strings.TrimSpace(s)
+}
+
+func (c ChapterNumber) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *ChapterNumber) UnmarshalText(text []byte) error {
+	parsed, ok := ParseChapterNumber(string(text))
+	if !ok {
+		return fmt.Errorf("invalid chapter number %q", text)
+	}
+	*c = parsed
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/xml"
+	"strconv"
 )
 
 type coMet Metadata
@@ -170,10 +171,9 @@ func (m coMet) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	if manga, ok := m["manga"]; ok {
 		info.Title = manga.(string)
 	}
-	if chapter, ok := m["chapter"]; ok {
-		if n, ok := chapter.(int); ok {
-			info.Issue = uint(n)
-		}
+	if chapter, ok := m["chapter"].(ChapterNumber); ok {
+		// CoMet only does whole numbers
+		info.Issue = uint(chapter.Major)
 	}
 	if author, ok := m["author"]; ok {
 		info.Creators = []string{author.(string)}
@@ -187,9 +187,21 @@ func (m coMet) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	if genres, ok := m["genres"]; ok {
 		info.Genres = genres.([]string)
 	}
+	if description, ok := m["description"].(string); ok {
+		info.Description = description
+	}
+	if year, ok := m["year"].(int); ok {
+		info.Date = strconv.Itoa(year)
+	}
 	if readingDirection, ok := m["readingDirection"]; ok {
 		info.ReadingDirection = readingDirection.(string)
 	}
+	if language, ok := m["language"].(string); ok {
+		info.Language = language
+	}
+	if adult, _ := m["adult"].(bool); adult {
+		info.Rating = "Adult"
+	}
 
 	e.Indent("", "  ")
 	return e.Encode(info)
@@ -2,11 +2,73 @@ package main
 
 import (
 	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type comicInfo Metadata
 
+// ComicPageInfo describes a page in ComicInfo.xml; readers use it to pick the
+// cover and to spot double-page spreads.
+type ComicPageInfo struct {
+	Image       int    `xml:",attr"`
+	Type        string `xml:",attr,omitempty"`
+	DoublePage  bool   `xml:",attr,omitempty"`
+	ImageSize   int64  `xml:",attr,omitempty"`
+	ImageWidth  int    `xml:",attr,omitempty"`
+	ImageHeight int    `xml:",attr,omitempty"`
+	// Key is where the page was downloaded from, to tell later whether the
+	// site has replaced it.
+	Key string `xml:",attr,omitempty"`
+}
+
+// comicPages describes the images in dir, in the order they'll be archived;
+// sources are where they came from, by page index.
+func comicPages(dir string, sources map[int]string) []ComicPageInfo {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return naturalLess(files[i].Name(), files[j].Name())
+	})
+
+	var pages []ComicPageInfo
+	for _, f := range files {
+		if f.IsDir() || !isPageEntry(f.Name()) || strings.HasSuffix(f.Name(), ".part") {
+			continue
+		}
+
+		page := ComicPageInfo{Image: len(pages)}
+		if page.Image == 0 {
+			page.Type = "FrontCover"
+		}
+		name := f.Name()
+		if n, err := strconv.Atoi(strings.TrimSuffix(name, filepath.Ext(name))); err == nil {
+			page.Key = sources[n]
+		}
+		if finfo, err := f.Info(); err == nil {
+			page.ImageSize = finfo.Size()
+		}
+		if file, err := os.Open(filepath.Join(dir, f.Name())); err == nil {
+			if config, _, err := image.DecodeConfig(file); err == nil {
+				page.ImageWidth, page.ImageHeight = config.Width, config.Height
+				page.DoublePage = config.Width > config.Height
+			}
+			file.Close()
+		}
+		pages = append(pages, page)
+	}
+	return pages
+}
+
 func (m comicInfo) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	var info struct {
 		XMLName         xml.Name `xml:"ComicInfo"`
@@ -39,15 +101,17 @@ func (m comicInfo) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 
 		BlackAndWhite string `xml:",omitempty"`
 		Manga         string `xml:",omitempty"`
+		AgeRating     string `xml:",omitempty"`
+
+		Pages []ComicPageInfo `xml:"Pages>Page,omitempty"`
 
-		// Pages       []PageInfo
 		// Fonts       []FontInfo
 		// ID          GUID
 		// Translation GUID
 		// Version     GUID
 
 		// TranslationTitle string
-		// Translator       string
+		Translator string `xml:",omitempty"`
 		// Tags             string
 		// Type             ComicType
 	}
@@ -59,12 +123,15 @@ func (m comicInfo) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	if manga, ok := m["manga"]; ok {
 		info.Title = manga.(string)
 	}
-	if chapter, ok := m["chapter"]; ok {
-		if n, ok := chapter.(int); ok {
-			info.Number = strconv.Itoa(n)
-		} else if s, ok := chapter.(string); ok {
-			info.Number = s
-		}
+	switch chapter := m["chapter"].(type) {
+	case ChapterNumber:
+		info.Number = chapter.String()
+	case string:
+		info.Number = chapter
+	}
+	if oneshot, _ := m["oneshot"].(bool); oneshot {
+		info.Number = ""
+		info.Format = "One-Shot"
 	}
 	if author, ok := m["author"]; ok {
 		info.Writer = author.(string)
@@ -75,7 +142,102 @@ func (m comicInfo) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	if pages, ok := m["pages"]; ok {
 		info.PageCount = pages.(int)
 	}
+	if description, ok := m["description"].(string); ok {
+		info.Summary = description
+	}
+	if genres, ok := m["genres"].([]string); ok {
+		info.Genre = strings.Join(genres, ", ")
+	}
+	if year, ok := m["year"].(int); ok {
+		info.Year = year
+	}
+	if u, ok := m["url"].(string); ok {
+		info.Web = u
+	}
+	if group, ok := m["group"].(string); ok && group != "" {
+		info.Translator = group
+	}
+	if language, ok := m["language"].(string); ok {
+		info.LanguageISO = language
+	}
+	if adult, _ := m["adult"].(bool); adult {
+		info.AgeRating = "Adults Only 18+"
+	}
+	var notes []string
+	if group, ok := m["group"].(string); ok && group != "" {
+		notes = append(notes, "Scanlated by "+group+".")
+	}
+	if uploaded, ok := m["uploaded"].(time.Time); ok {
+		notes = append(notes, "Uploaded on "+uploaded.Format("2006-01-02")+".")
+	}
+	if u, ok := m["url"].(string); ok {
+		notes = append(notes, "Downloaded from "+u+".")
+	}
+	if missing, ok := m["missingPages"].([]int); ok && len(missing) > 0 {
+		notes = append(notes, fmt.Sprintf("Pages %v failed to download.", missing))
+	}
+	info.Notes = strings.Join(notes, " ")
+	if pages, ok := m["pageInfo"].([]ComicPageInfo); ok {
+		info.Pages = pages
+		// some pages may have been left out
+		info.PageCount = len(pages)
+	}
 
 	e.Indent("", "  ")
 	return e.Encode(info)
 }
+
+// parseComicInfo reads back what comicInfo wrote, as far as it can.
+func parseComicInfo(r io.Reader) (Metadata, error) {
+	var info struct {
+		Title       string
+		Number      string
+		Summary     string
+		Year        int
+		Writer      string
+		Penciller   string
+		Translator  string
+		Genre       string
+		Web         string
+		LanguageISO string
+		AgeRating   string
+		Format      string
+		Pages       []ComicPageInfo `xml:"Pages>Page"`
+	}
+	if err := xml.NewDecoder(r).Decode(&info); err != nil {
+		return nil, err
+	}
+
+	m := Metadata{}
+	set := func(key, value string) {
+		if value != "" {
+			m[key] = value
+		}
+	}
+	set("manga", info.Title)
+	set("description", info.Summary)
+	set("author", info.Writer)
+	set("artist", info.Penciller)
+	set("group", info.Translator)
+	set("url", info.Web)
+	set("language", info.LanguageISO)
+	if info.Number != "" {
+		m["chapter"] = chapterMetadata(info.Number)
+	}
+	if info.Year > 0 {
+		m["year"] = info.Year
+	}
+	if info.Genre != "" {
+		m["genres"] = strings.Split(info.Genre, ", ")
+	}
+	if info.AgeRating == "Adults Only 18+" {
+		m["adult"] = true
+	}
+	if info.Format == "One-Shot" {
+		m["oneshot"] = true
+	}
+	if len(info.Pages) > 0 {
+		m["pageInfo"] = info.Pages
+	}
+	return m, nil
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/url"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
@@ -15,22 +21,105 @@ type Scraper interface {
 	GetImage(*goquery.Document) (img Resource)
 }
 
+// An APIScraper gets what a Scraper would from an API, a site's or a server's,
+// rather than from web pages.
+type APIScraper interface {
+	// Chapters lists the chapters of the series at mangaURL.
+	Chapters(f Fetcher, mangaURL *url.URL) ([]Resource, error)
+	// Pages lists the images of a chapter, in order.
+	Pages(f Fetcher, chapter Resource) ([]Resource, error)
+}
+
+// A QualityScraper offers images in more than one quality, e.g. smaller ones
+// for metered connections.
+type QualityScraper interface {
+	// Qualities names them, best first.
+	Qualities() []string
+}
+
+// pickQuality is which of s's qualities want means: "best", "smallest" or
+// one of their names.  It's the best one by default.
+func pickQuality(s QualityScraper, want string) string {
+	qualities := s.Qualities()
+	switch want {
+	case "", "best":
+		return qualities[0]
+	case "smallest":
+		return qualities[len(qualities)-1]
+	}
+	for _, q := range qualities {
+		if q == want {
+			return q
+		}
+	}
+	log.Printf("no %q quality (known: %s), getting the best", want, strings.Join(qualities, ", "))
+	return qualities[0]
+}
+
+// A LimitedScraper says how hard its site should be hit, unless the config
+// says otherwise.
+type LimitedScraper interface {
+	Limits() []DomainLimit
+}
+
 type CommonSimpleCrawler struct {
 	scraper Scraper
-	client  Fetcher
-	saver   Saver
-	rule    Rule
-	obs     Observer
+	// api, if set, is used instead of scraper
+	api    APIScraper
+	client Fetcher
+	saver  Saver
+	rule   Rule
+	obs    Observer
+	passes MetadataPasses
+	index  *MangaIndex
+	// how many pages of a chapter may fail before giving up on it all
+	maxFailedPages int
+	// the scanlation groups, and versions, to prefer when a chapter has
+	// several releases
+	preferGroups   []string
+	preferVersions []string
+	// the quality of images to get, for a QualityScraper
+	quality string
+	// the languages to get chapters in, where a site has several
+	languages []string
+	// whether to skip failed chapters instead of stopping everything
+	continueOnError bool
+	limit           *RunLimit
+	// havePage tells whether a page was already downloaded
+	havePage func(Metadata) bool
+	// saveHTML, if set, keeps the HTML of every page along with the images
+	saveHTML func(info Metadata, html []byte) error
+	// reusePage, if set, gets a page from an earlier download of the
+	// chapter, if it came from the same URL, instead of downloading it
+	reusePage func(info Metadata, u *url.URL) bool
 }
 
 func (m *CommonSimpleCrawler) handleManga(mangaURL *url.URL) {
-	mangaDoc, err := m.client.GetHTML(mangaURL)
+	wg := sync.WaitGroup{}
+	chapters, err := m.index.Chapters(m, mangaURL)
 	if err != nil {
-		log.Fatal(err)
+		m.failed(Metadata{"url": mangaURL.String()}, err)
+		return
+	}
+	for _, c := range chapters {
+		if version := chapterVersion(c.info); version != "" {
+			c.info["version"] = version
+		}
+	}
+	chapters = m.pickReleases(chapters)
+	var numbers []string
+	for _, c := range chapters {
+		numbers = append(numbers, fmt.Sprint(c.info["chapter"]))
+	}
+	highest := maxChapter(numbers)
+	oneshot := len(chapters) == 1 && isOneshot(chapters[0].info)
+	for _, c := range chapters {
+		c.info["maxChapter"] = highest
+		if oneshot {
+			c.info["oneshot"] = true
+		}
+		m.passes.Apply(c.info)
 	}
-
-	wg := sync.WaitGroup{}
-	chapters := m.scraper.GetChapters(mangaDoc)
 	for _, c := range chapters {
 		wg.Add(1)
 		go func(c Resource) {
@@ -41,73 +130,327 @@ func (m *CommonSimpleCrawler) handleManga(mangaURL *url.URL) {
 	wg.Wait()
 }
 
+// isOneshot tells whether the only chapter of a series is a one-shot rather
+// than the first of many: it has no number to speak of.
+func isOneshot(info Metadata) bool {
+	switch chapter := info["chapter"].(type) {
+	case ChapterNumber:
+		return chapter == ChapterNumber{}
+	case string:
+		_, ok := ParseChapterNumber(chapter)
+		return !ok
+	case nil:
+		return true
+	}
+	return false
+}
+
+// pickReleases deals with chapters that were released more than once, in
+// different versions or by different groups: the preferred version, by the
+// preferred group, is kept, or, failing that, all of them, each marked as a
+// variant so they get names of their own.
+func (m *CommonSimpleCrawler) pickReleases(chapters []Resource) []Resource {
+	releases := map[string][]int{}
+	for i, c := range chapters {
+		// translations aren't releases of the same chapter
+		n := fmt.Sprint(c.info["chapter"], "\x00", c.info["language"])
+		releases[n] = append(releases[n], i)
+	}
+
+	drop := map[int]bool{}
+	for _, same := range releases {
+		if len(same) < 2 {
+			continue
+		}
+		best := m.releaseRank(chapters[same[0]].info)
+		for _, i := range same {
+			if r := m.releaseRank(chapters[i].info); r < best {
+				best = r
+			}
+		}
+		var kept []int
+		for _, i := range same {
+			if m.releaseRank(chapters[i].info) > best {
+				drop[i] = true
+			} else {
+				kept = append(kept, i)
+			}
+		}
+		if len(kept) < 2 {
+			continue
+		}
+		for n, i := range kept {
+			var parts []string
+			for _, key := range []string{"version", "group"} {
+				if s, _ := chapters[i].info[key].(string); s != "" {
+					parts = append(parts, s)
+				}
+			}
+			variant := strings.Join(parts, ", ")
+			if variant == "" {
+				variant = fmt.Sprint(n + 1)
+			}
+			chapters[i].info["variant"] = variant
+		}
+	}
+
+	var picked []Resource
+	for i, c := range chapters {
+		if !drop[i] {
+			picked = append(picked, c)
+		}
+	}
+	return picked
+}
+
+// releaseRank orders the releases of a chapter by how much they're wanted,
+// the version first and the group second; lower is better.
+func (m *CommonSimpleCrawler) releaseRank(info Metadata) int {
+	return versionRank(m.preferVersions, info)*(len(m.preferGroups)+1) + groupRank(m.preferGroups, info)
+}
+
+// groupRank is how far down preferred the chapter's group is; groups that
+// aren't there come last.
+func groupRank(preferred []string, info Metadata) int {
+	group, _ := info["group"].(string)
+	for i, g := range preferred {
+		if strings.EqualFold(g, group) {
+			return i
+		}
+	}
+	return len(preferred)
+}
+
 func (m *CommonSimpleCrawler) handleChapter(chapter Resource) {
-	if m.rule.Block(chapter) {
+	if !m.index.Claim(chapter, m.rule) {
 		return
 	}
-
-	chapterDoc, err := m.client.GetHTML(chapter.url)
-	if err != nil {
-		log.Fatal(err)
+	queue, _ := m.obs.(QueueObserver)
+	if queue != nil {
+		queue.OnChapterQueued(chapter.info)
 	}
 
-	otherPages, thisPage := m.scraper.GetPages(chapterDoc)
+	chapter.info["started"] = time.Now()
+	// the pages to get the images from, and the images known already
+	var otherPages, images []Resource
+	if m.api != nil {
+		var err error
+		if images, err = m.api.Pages(m.client, chapter); err == nil && len(images) == 0 {
+			err = errors.New("no pages")
+		}
+		if err != nil {
+			m.failed(chapter.info, err)
+			return
+		}
+	} else {
+		chapterDoc, err := m.client.GetHTML(chapter.url)
+		if err != nil {
+			m.failed(chapter.info, err)
+			return
+		}
+		otherPages, images = m.scraper.GetPages(chapterDoc)
+		m.keepHTML(images[0].info, chapterDoc)
+	}
+	// the first one ends the chapter; the rest are pages like any other
+	thisPage, otherImages := images[:1], images[1:]
 	thisPage[0].info.Update(chapter.info)
 	for i := 0; i < len(otherPages); i++ {
 		otherPages[i].info.Update(chapter.info)
 	}
+	for i := 0; i < len(otherImages); i++ {
+		otherImages[i].info.Update(chapter.info)
+	}
+
+	salvaged := 0
+	if m.havePage != nil {
+		missing := func(pages []Resource) []Resource {
+			var missing []Resource
+			for _, p := range pages {
+				if m.havePage(p.info) {
+					salvaged++
+				} else {
+					missing = append(missing, p)
+				}
+			}
+			return missing
+		}
+		otherPages, otherImages = missing(otherPages), missing(otherImages)
+	}
+	if salvaged > 0 {
+		log.Printf("%s %v: resuming, %d pages already downloaded", chapter.info["manga"], chapter.info["chapter"], salvaged)
+	}
+
+	if !m.limit.Reserve(len(otherPages) + len(otherImages) + 1) {
+		// not a failure as such, but whoever keeps track of those still
+		// needs to know it's not coming
+		if f, ok := m.obs.(FailureObserver); ok {
+			f.OnFailure(chapter.info, errRunLimit)
+		}
+		return
+	}
+	if queue != nil {
+		queue.OnPagesQueued(chapter.info, len(otherPages)+len(otherImages)+1)
+	}
 
 	wg := sync.WaitGroup{}
+	failed := &failedPages{}
+	sources := &pageSources{}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		m.handleImage(thisPage[0])
+		if err := m.handleImage(thisPage[0], sources); err != nil {
+			failed.add(thisPage[0].info, err)
+		}
 	}()
 
 	for _, p := range otherPages {
 		wg.Add(1)
 		go func(p Resource) {
 			defer wg.Done()
-			m.handlePage(p)
+			m.handlePage(p, failed, sources)
 		}(p)
 	}
+	for _, img := range otherImages {
+		wg.Add(1)
+		go func(img Resource) {
+			defer wg.Done()
+			m.handlePageImage(img, failed, sources)
+		}(img)
+	}
 
 	wg.Wait()
+	if len(failed.pages) > m.maxFailedPages {
+		m.failed(chapter.info, fmt.Errorf("%d pages failed: %s", len(failed.pages), failed.err))
+		return
+	} else if len(failed.pages) > 0 {
+		sort.Ints(failed.pages)
+		log.Printf("%s %v: going on without pages %v", chapter.info["manga"], chapter.info["chapter"],
+			failed.pages)
+		thisPage[0].info["missingPages"] = failed.pages
+	}
+	thisPage[0].info["pageURLs"] = sources.urls
+	if m.reusePage != nil {
+		thisPage[0].info["reusedPages"] = sources.reused
+	}
 	m.obs.OnPageEnd(thisPage[0].info)
 	m.obs.OnChapterEnd(thisPage[0].info)
 }
 
-func (m *CommonSimpleCrawler) handlePage(page Resource) Resource {
+func (m *CommonSimpleCrawler) handlePage(page Resource, failed *failedPages, sources *pageSources) Resource {
 	pageDoc, err := m.client.GetHTML(page.url)
 	if err != nil {
-		log.Fatal(err)
+		failed.add(page.info, err)
+		m.obs.OnPageEnd(page.info)
+		return page
 	}
+	m.keepHTML(page.info, pageDoc)
 	img := m.scraper.GetImage(pageDoc)
 	img.info.Update(page.info)
+	m.handlePageImage(img, failed, sources)
+	return img
+}
+
+// handlePageImage downloads the image of a page, which is done with then.
+func (m *CommonSimpleCrawler) handlePageImage(img Resource, failed *failedPages, sources *pageSources) {
 	defer m.obs.OnPageEnd(img.info)
+	if err := m.handleImage(img, sources); err != nil {
+		failed.add(img.info, err)
+	}
+}
 
-	if err := m.handleImage(img); err != nil {
-		log.Fatal(err)
+// keepHTML saves the HTML of a page, if asked to; there's no reason to give
+// up on the images if it can't.
+func (m *CommonSimpleCrawler) keepHTML(info Metadata, doc *goquery.Document) {
+	if m.saveHTML == nil {
+		return
+	}
+	html, err := goquery.OuterHtml(doc.Selection)
+	if err == nil {
+		err = m.saveHTML(info, []byte(html))
+	}
+	if err != nil {
+		log.Printf("%s %v: page %v: cannot keep HTML: %s", info["manga"], info["chapter"], info["pageIndex"], err)
 	}
-	return img
 }
 
-func (m *CommonSimpleCrawler) handleImage(img Resource) error {
+// failed gives up on a chapter, or a series, and on everything else too
+// unless told to go on.
+func (m *CommonSimpleCrawler) failed(info Metadata, err error) {
+	if !m.continueOnError {
+		fatalf("%s: %s", describe(info), err)
+	}
+	log.Printf("%s: %s, skipping it", describe(info), err)
+	if f, ok := m.obs.(FailureObserver); ok {
+		f.OnFailure(info, err)
+	}
+}
+
+// describe names a chapter, or a series that only has a URL so far.
+func describe(info Metadata) string {
+	if _, ok := info["manga"]; !ok {
+		return fmt.Sprint(info["url"])
+	}
+	return fmt.Sprintf("%v %v", info["manga"], info["chapter"])
+}
+
+// failedPages collects the pages of a chapter that couldn't be downloaded.
+type failedPages struct {
+	mu    sync.Mutex
+	pages []int
+	err   error // the first one
+}
+
+func (f *failedPages) add(info Metadata, err error) {
+	log.Printf("%s %v: page %v: %s", info["manga"], info["chapter"], info["pageIndex"], err)
+	info["failed"] = true
+	info["error"] = err.Error()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if n, ok := info["pageIndex"].(int); ok {
+		f.pages = append(f.pages, n)
+	}
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+func (m *CommonSimpleCrawler) handleImage(img Resource, sources *pageSources) error {
+	if m.reusePage != nil && m.reusePage(img.info, img.url) {
+		sources.add(img.info, img.url, true)
+		return nil
+	}
+
 	r, err := m.client.Get(img.url)
 	if err != nil {
 		return err
 	}
 	defer r.Body.Close()
 
+	// The scrapers can only guess the image type from the URL (if at all),
+	// so have a look at the actual bytes before naming the file.
+	body := bufio.NewReader(r.Body)
+	header, _ := body.Peek(512)
+	if ext := sniffImageExtension(header, r.Header.Get("Content-Type")); ext != "" {
+		img.info["imageExtension"] = ext
+	}
+
 	out, err := m.saver.Save(img.info, r.ContentLength)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
 
-	if _, err := io.Copy(out, r.Body); err != nil {
+	n, err := io.Copy(out, body)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	img.info["bytes"] = n
+	m.limit.Add(n)
+	if err := out.Close(); err != nil {
 		return err
 	}
+	sources.add(img.info, img.url, false)
 	return nil
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Config holds the settings read from the configuration file.  Anything that
+// doesn't fit comfortably in a command-line flag lives here.
+type Config struct {
+	// Gradient lists the colors of the progress bar, going from 0% to 100%,
+	// as "#rrggbb" strings.
+	Gradient []string `json:"gradient,omitempty"`
+
+	// Blacklist holds perceptual hashes (as printed by "mango phash") of
+	// pages to drop; BlacklistDistance is how many bits a page's hash may
+	// differ by and still count as a match.
+	Blacklist         []string `json:"blacklist,omitempty"`
+	BlacklistDistance int      `json:"blacklistDistance,omitempty"`
+
+	// MetadataProviders are the external databases ("mangaupdates",
+	// "myanimelist") series information is looked up in, most preferred
+	// first.  What they have takes precedence over what's scraped.
+	MetadataProviders []string `json:"metadataProviders,omitempty"`
+
+	// PreferGroups are the scanlation groups to go with, most preferred
+	// first, when a chapter has been released by more than one; without a
+	// preference, every release is kept under a name of its own.
+	PreferGroups []string `json:"preferGroups,omitempty"`
+
+	// Komga, if set, is told to rescan its library after downloads.
+	Komga *MediaServerConfig `json:"komga,omitempty"`
+	// Kavita, likewise.  Only the APIKey is used to log in.
+	Kavita *MediaServerConfig `json:"kavita,omitempty"`
+	// Calibre, if set, gets every downloaded chapter added to its library.
+	Calibre *CalibreConfig `json:"calibre,omitempty"`
+	// Suwayomi, if set, is a server to download from by way of its
+	// extensions, given the URLs of series or chapters in its web UI.
+	Suwayomi *SuwayomiConfig `json:"suwayomi,omitempty"`
+
+	// Schedule is when to check the tracked series for new chapters, as a
+	// cron expression (e.g. "0 18 * * fri"); setting it keeps mango running,
+	// like -watch does.  Series can have schedules of their own.
+	Schedule string `json:"schedule,omitempty"`
+
+	// Bandwidth limits download speeds by time of day; the first profile
+	// that covers the current time applies, e.g.
+	//
+	//	[{"from": "01:00", "to": "07:00"}, {"limit": "500K"}]
+	//
+	// doesn't limit anything at night and 500KiB/s otherwise.
+	Bandwidth []BandwidthProfile `json:"bandwidth,omitempty"`
+
+	// Domains limits how hard particular sites are hit, the connections,
+	// requests a second and download speed, e.g.
+	//
+	//	[{"domain": "*.example.com", "connections": 2, "bandwidth": "200K"}]
+	//
+	// The speed is on top of the overall Bandwidth.
+	Domains []DomainLimit `json:"domains,omitempty"`
+
+	// TLS, if set, is for sites behind corporate proxies, self-hosted
+	// mirrors and such.
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// CredentialStore is where the logins set up with "mango login" are
+	// kept: "keyring", the system's, or "file", encrypted next to this
+	// one.  By default it's the keyring if there's one.  Passwords and API
+	// keys in here take precedence.
+	CredentialStore string `json:"credentialStore,omitempty"`
+
+	// Hooks are commands to run when chapters and series are done.
+	Hooks HooksConfig `json:"hooks"`
+}
+
+// configPath returns the location of the configuration file; $MANGO_CONFIG if
+// set, otherwise mango/config.json under the user's config directory.
+func configPath() string {
+	if path := os.Getenv("MANGO_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mango", "config.json")
+}
+
+// loadConfig reads the configuration file at path.  A missing file is not an
+// error, it just means the defaults are used.
+func loadConfig(path string) (Config, error) {
+	var config Config
+	if path == "" {
+		return config, nil
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return config, err
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(file)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&config)
+	return config, err
+}
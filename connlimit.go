@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// connLimit limits how many connections there are to a domain at once.  It
+// starts out allowing as many as it's been told, backs off by half whenever
+// the server seems to be struggling (it refuses with a 429 or a 5xx, or
+// doesn't answer at all) and creeps back up, by one at a time, while it
+// answers fine, so that the limits don't have to be tuned for every site.
+type connLimit struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	max   int
+	limit float64 // as it is now, between 1 and max
+	inUse int
+}
+
+func newConnLimit(max int) *connLimit {
+	c := &connLimit{max: max, limit: float64(max)}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// acquire waits for a connection to be allowed.
+func (c *connLimit) acquire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.inUse >= int(c.limit) {
+		c.cond.Wait()
+	}
+	c.inUse++
+}
+
+// release gives a connection back, saying how the server took it, and tells
+// whether the limit changed, and to what.
+func (c *connLimit) release(healthy bool) (limit int, changed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inUse--
+	before := int(c.limit)
+	if healthy {
+		// about one more for every limit's worth of connections that went
+		// well
+		c.limit = math.Min(c.limit+1/c.limit, float64(c.max))
+	} else {
+		c.limit = math.Max(c.limit/2, 1)
+	}
+	c.cond.Broadcast()
+	return int(c.limit), int(c.limit) != before
+}
+
+// used is how many connections there are now, and how many there may be.
+func (c *connLimit) used() (inUse, limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inUse, int(c.limit)
+}
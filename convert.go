@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	commands["convert"] = convertCommand
+}
+
+// convertCommand turns the CBZs in a library into another format, the way
+// they'd have been saved with -format, from what's in them and in the
+// manifest; nothing is downloaded again.
+func convertCommand(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	manifestFormat, manifestPath := manifestFlags(fs)
+	to := fs.String("to", "epub", "convert the archives to `FORMAT` (epub or pdf)")
+	remove := fs.Bool("remove", false, "remove the archives once they're converted")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango convert [flags] [LIBRARY|ARCHIVE...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	format, err := lookupArchiveFormat(*to)
+	if err != nil {
+		fatal(err)
+	}
+	if format.ext == ".cbz" {
+		fatalln("the archives are CBZs already")
+	}
+
+	manifest, err := openManifest(*manifestFormat, *manifestPath, manifestUpdate)
+	if err != nil {
+		fatalln("cannot open manifest:", err)
+	}
+	entries := map[string]ManifestEntry{}
+	chapters := map[string]int{}
+	if manifest != nil {
+		defer manifest.Close()
+		all, err := manifest.Entries("")
+		if err != nil {
+			fatalln("cannot read manifest:", err)
+		}
+		for _, e := range all {
+			entries[filepath.Clean(e.Path)] = e
+			chapters[e.Series]++
+		}
+	}
+
+	converted := 0
+	for _, archive := range findCBZs(fs.Args()) {
+		name := strings.TrimSuffix(archive, filepath.Ext(archive)) + format.ext
+		if isFile(name) {
+			log.Printf("%s: %s already exists, skipping it", archive, name)
+			continue
+		}
+
+		entry, inManifest := entries[filepath.Clean(archive)]
+		info := Metadata{}
+		if inManifest {
+			info = manifestMetadata(entry, chapters[entry.Series])
+		}
+		existing, err := archiveMetadata(archive)
+		if err != nil {
+			log.Printf("%s: %s", archive, err)
+			continue
+		}
+		info.Update(existing)
+
+		if err := convertArchive(archive, name, format, info); err != nil {
+			fatalf("%s: %s", archive, err)
+		}
+		fmt.Printf("%s -> %s\n", archive, name)
+		converted++
+
+		if inManifest {
+			entry.Path, entry.CID = name, ""
+			if entry.Hash, err = hashFile(name); err != nil {
+				log.Println("cannot hash chapter:", err)
+			}
+			if err := manifest.Add(entry); err != nil {
+				log.Println("cannot update manifest:", err)
+			} else if err := manifest.Remove(archive); err != nil {
+				log.Println("cannot update manifest:", err)
+			}
+		}
+		if *remove {
+			os.Remove(archive)
+		}
+	}
+	fmt.Printf("converted %d chapters\n", converted)
+}
+
+// findCBZs lists the CBZs under roots, the working directory if none.
+func findCBZs(roots []string) []string {
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	var archives []string
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.EqualFold(filepath.Ext(path), ".cbz") {
+				archives = append(archives, path)
+			}
+			return nil
+		})
+		if err != nil {
+			fatal(err)
+		}
+	}
+	return archives
+}
+
+// convertArchive makes name, in the given format, out of the pages of the
+// CBZ archive.
+func convertArchive(archive, name string, format archiveFormat, info Metadata) error {
+	work, err := os.MkdirTemp(filepath.Dir(name), ".mango-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+
+	pages := filepath.Join(work, "pages")
+	if err := unpackArchive(archive, pages); err != nil {
+		return err
+	}
+	if err := decodeJPEGXLPages(pages); err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(work, "out-*")
+	if err != nil {
+		return err
+	}
+	out.Chmod(0660)
+	if err := format.write(pages, info, out); err != nil {
+		return err
+	}
+	return moveFile(out.Name(), name)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// browserCookieJar makes a cookie jar out of what a browser has for domains
+// (and their subdomains), so that sites that need a logged in session, or a
+// challenge solved, work the same as they do in the browser.  browser is
+// "firefox", "chrome" or "chromium", optionally followed by ":" and the
+// profile directory; otherwise the one used last is.
+func browserCookieJar(browser string, domains []string) (http.CookieJar, error) {
+	name, profile, _ := strings.Cut(browser, ":")
+	var cookies []browserCookie
+	var err error
+	switch name {
+	case "firefox":
+		cookies, err = firefoxCookies(profile)
+	case "chrome", "chromium":
+		cookies, err = chromeCookies(name, profile, domains)
+	default:
+		return nil, fmt.Errorf("unknown browser %q (known: chrome, chromium, firefox)", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, c := range cookies {
+		host := strings.TrimPrefix(c.host, ".")
+		if !cookieMatches(host, domains) || (!c.expires.IsZero() && c.expires.Before(time.Now())) {
+			continue
+		}
+		cookie := &http.Cookie{
+			Name:     c.name,
+			Value:    c.value,
+			Path:     c.path,
+			Expires:  c.expires,
+			Secure:   c.secure,
+			HttpOnly: c.httpOnly,
+		}
+		// the ones for a domain, rather than a host, start with a dot
+		if strings.HasPrefix(c.host, ".") {
+			cookie.Domain = host
+		}
+		jar.SetCookies(&url.URL{Scheme: "https", Host: host, Path: "/"}, []*http.Cookie{cookie})
+		n++
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%s has no cookies for %s", name, strings.Join(domains, ", "))
+	}
+	return jar, nil
+}
+
+// cookieMatches tells whether a cookie for host would be sent to any of
+// domains, or their subdomains.
+func cookieMatches(host string, domains []string) bool {
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.ToLower(d), "www.")
+		if host == d || strings.HasSuffix(host, "."+d) || strings.HasSuffix(d, "."+host) {
+			return true
+		}
+	}
+	return false
+}
+
+type browserCookie struct {
+	host, name, value, path string
+	expires                 time.Time // zero for a session cookie
+	secure, httpOnly        bool
+}
+
+// openCookieDB opens a copy of a browser's cookie database, which the browser
+// keeps locked while it runs.  The copy goes when the database is closed.
+func openCookieDB(path string) (*sql.DB, func(), error) {
+	dir, err := os.MkdirTemp("", "mango-cookies")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	dst := filepath.Join(dir, filepath.Base(path))
+	if err := copyFile(path, dst); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	// what hasn't made it to the database proper yet
+	if isFile(path + "-wal") {
+		copyFile(path+"-wal", dst+"-wal")
+	}
+	db, err := sql.Open("sqlite", dst)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return db, func() { db.Close(); cleanup() }, nil
+}
+
+// lastUsed is whichever of paths was changed last.
+func lastUsed(paths []string) (string, error) {
+	var best string
+	var bestTime time.Time
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil && fi.ModTime().After(bestTime) {
+			best, bestTime = p, fi.ModTime()
+		}
+	}
+	if best == "" {
+		return "", errors.New("no profile found")
+	}
+	return best, nil
+}
+
+func firefoxCookies(profile string) ([]browserCookie, error) {
+	var path string
+	if profile != "" {
+		path = filepath.Join(profile, "cookies.sqlite")
+	} else {
+		var profiles string
+		switch runtime.GOOS {
+		case "darwin", "windows":
+			config, _ := os.UserConfigDir()
+			profiles = filepath.Join(config, "Mozilla", "Firefox", "Profiles")
+			if runtime.GOOS == "darwin" {
+				profiles = filepath.Join(config, "Firefox", "Profiles")
+			}
+		default:
+			home, _ := os.UserHomeDir()
+			profiles = filepath.Join(home, ".mozilla", "firefox")
+		}
+		found, _ := filepath.Glob(filepath.Join(profiles, "*", "cookies.sqlite"))
+		var err error
+		if path, err = lastUsed(found); err != nil {
+			return nil, err
+		}
+	}
+
+	db, closeDB, err := openCookieDB(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDB()
+	rows, err := db.Query("SELECT host, name, value, path, expiry, isSecure, isHttpOnly FROM moz_cookies")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cookies []browserCookie
+	for rows.Next() {
+		var c browserCookie
+		var expiry int64
+		if err := rows.Scan(&c.host, &c.name, &c.value, &c.path, &expiry, &c.secure, &c.httpOnly); err != nil {
+			return nil, err
+		}
+		// newer versions keep it in milliseconds
+		if expiry > 1e11 {
+			c.expires = time.UnixMilli(expiry)
+		} else if expiry > 0 {
+			c.expires = time.Unix(expiry, 0)
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, rows.Err()
+}
+
+// chromeCookies only decrypts the cookies for domains; the rest may be in
+// formats, or under keys, that there's no way to decrypt, and it doesn't
+// matter.
+func chromeCookies(browser, profile string, domains []string) ([]browserCookie, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("cannot decrypt cookies on Windows, export them from the browser instead")
+	}
+	if profile == "" {
+		config, _ := os.UserConfigDir()
+		dir := map[string]string{"chrome": "google-chrome", "chromium": "chromium"}[browser]
+		if runtime.GOOS == "darwin" {
+			dir = map[string]string{"chrome": "Google/Chrome", "chromium": "Chromium"}[browser]
+		}
+		profile = filepath.Join(config, filepath.FromSlash(dir), "Default")
+	}
+	path, err := lastUsed([]string{
+		filepath.Join(profile, "Network", "Cookies"),
+		filepath.Join(profile, "Cookies"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db, closeDB, err := openCookieDB(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDB()
+	// since version 24, the values start with a hash of the domain
+	var version int
+	db.QueryRow("SELECT value FROM meta WHERE key = 'version'").Scan(&version)
+
+	rows, err := db.Query("SELECT host_key, name, value, encrypted_value, path, expires_utc, is_secure, is_httponly FROM cookies")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := chromeKeys{browser: browser}
+	var cookies []browserCookie
+	for rows.Next() {
+		var c browserCookie
+		var encrypted []byte
+		var expires int64
+		if err := rows.Scan(&c.host, &c.name, &c.value, &encrypted, &c.path, &expires, &c.secure, &c.httpOnly); err != nil {
+			return nil, err
+		}
+		if !cookieMatches(strings.TrimPrefix(c.host, "."), domains) {
+			continue
+		}
+		if len(encrypted) > 0 {
+			value, err := keys.decrypt(encrypted)
+			if err != nil {
+				return nil, fmt.Errorf("cookie %s for %s: %v", c.name, c.host, err)
+			}
+			if version >= 24 && len(value) >= 32 {
+				value = value[32:]
+			}
+			c.value = string(value)
+		}
+		// in microseconds since 1601
+		if expires > 0 {
+			c.expires = time.UnixMicro(expires - 11644473600e6)
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, rows.Err()
+}
+
+// chromeKeys works out the keys Chrome encrypts cookies with, as they're
+// needed.
+type chromeKeys struct {
+	browser  string
+	v10, v11 []byte
+}
+
+func (k *chromeKeys) decrypt(encrypted []byte) ([]byte, error) {
+	prefix := string(encrypted[:min(3, len(encrypted))])
+	var key []byte
+	switch {
+	case prefix == "v10" && runtime.GOOS == "darwin", prefix == "v11":
+		if k.v11 == nil {
+			password, err := k.password()
+			if err != nil {
+				return nil, err
+			}
+			iterations := 1
+			if runtime.GOOS == "darwin" {
+				iterations = 1003
+			}
+			if k.v11, err = pbkdf2.Key(sha1.New, password, []byte("saltysalt"), iterations, 16); err != nil {
+				return nil, err
+			}
+		}
+		key = k.v11
+	case prefix == "v10":
+		// what Chrome uses on Linux without a keyring
+		if k.v10 == nil {
+			var err error
+			if k.v10, err = pbkdf2.Key(sha1.New, "peanuts", []byte("saltysalt"), 1, 16); err != nil {
+				return nil, err
+			}
+		}
+		key = k.v10
+	default:
+		return nil, fmt.Errorf("cookies encrypted in a way that's not known (%q)", prefix)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data := encrypted[3:]
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("cannot decrypt cookie: bad length")
+	}
+	iv := bytes.Repeat([]byte{' '}, aes.BlockSize)
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	padding := int(plain[len(plain)-1])
+	if padding < 1 || padding > aes.BlockSize {
+		return nil, errors.New("cannot decrypt cookie: wrong key")
+	}
+	return plain[:len(plain)-padding], nil
+}
+
+// password is the one Chrome keeps in the system's keyring.
+func (k *chromeKeys) password() (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		service := map[string]string{"chrome": "Chrome Safe Storage", "chromium": "Chromium Safe Storage"}[k.browser]
+		cmd = exec.Command("security", "find-generic-password", "-w", "-s", service)
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "application", k.browser)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("cannot get the cookie password from the keyring: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	commands["covers"] = coversCommand
+}
+
+// coversCommand gets just the covers of series, and what there is to say
+// about them, into the directories their chapters would go in; that's enough
+// for library managers to list them without any chapters.
+func coversCommand(args []string) {
+	fs := flag.NewFlagSet("covers", flag.ExitOnError)
+	list := fs.String("i", "", "read the series' URLs from `FILE`, one a line (- for the standard input)")
+	nameTemplate := fs.String("template", defaultNameTemplate, "where chapters go, as a Go `TEMPLATE`; the covers go in their series' directory")
+	sanitizeStyle := fs.String("sanitize", "replace", "make names filesystem-safe by replacing unsafe characters with underscores, unicode lookalikes or stripping them")
+	force := fs.Bool("force", false, "get the covers again even if they're there already")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango covers [flags] [URL...]")
+		fmt.Fprintln(os.Stderr, "With no URLs, those of the tracked series are used.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	urls := fs.Args()
+	if *list != "" {
+		more, err := readURLList(*list)
+		if err != nil {
+			fatal(err)
+		}
+		urls = append(urls, more...)
+	}
+	if len(urls) == 0 {
+		for _, t := range watchTargets(nil) {
+			urls = append(urls, t.URL)
+		}
+	}
+	if len(urls) == 0 {
+		fatal("no URLs given and no tracked series")
+	}
+
+	naming, err := ParseNameTemplate(*nameTemplate)
+	if err != nil {
+		fatalln("invalid template:", err)
+	}
+	naming.Sanitizer = Sanitizer{*sanitizeStyle}
+	if err := naming.Sanitizer.Validate(); err != nil {
+		fatal(err)
+	}
+	config, err := loadConfig(configPath())
+	if err != nil {
+		fatalln("cannot load config:", err)
+	}
+	suwayomi = config.Suwayomi
+
+	fetcher := NewFetcher(4, 2)
+	passes := MetadataPasses{NewOverridePass(Metadata{}, overridesDir()), NormalizePass{}}
+	if len(config.MetadataProviders) > 0 {
+		found, err := lookupProviders(config.MetadataProviders)
+		if err != nil {
+			fatalln("cannot load config:", err)
+		}
+		passes = append(MetadataPasses{NewProviderPass(fetcher, found)}, passes...)
+	}
+	failed := 0
+	for _, s := range urls {
+		if err := getCover(fetcher, s, naming, passes, *force); err != nil {
+			log.Printf("%s: %s", s, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// getCover gets the cover, and series.json, of the series at s.
+func getCover(f Fetcher, s string, naming NameTemplate, passes MetadataPasses, force bool) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	chapters, err := seriesChapters(f, u)
+	if err != nil {
+		return err
+	}
+	info := chapters[0].info
+	passes.Apply(info)
+	name, err := naming.Name(info)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, os.ModeDir|0770); err != nil {
+		return err
+	}
+
+	if err := writeSeriesJSON(filepath.Join(dir, "series.json"), info, len(chapters)); err != nil {
+		return err
+	}
+	cover, _ := info["coverImage"].(string)
+	if cover == "" {
+		return errors.New("no cover")
+	}
+	if existing, _ := filepath.Glob(filepath.Join(dir, "cover.*")); len(existing) > 0 && !force {
+		fmt.Printf("%s: %s\n", info["manga"], existing[0])
+		return nil
+	}
+	coverURL, err := u.Parse(cover)
+	if err != nil {
+		return err
+	}
+	r, err := f.Get(coverURL)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	body := bufio.NewReader(r.Body)
+	header, _ := body.Peek(512)
+	ext := sniffImageExtension(header, r.Header.Get("Content-Type"))
+	if ext == "" {
+		ext = "jpg"
+	}
+	path := filepath.Join(dir, "cover."+ext)
+	out, err := os.Create(path + ".part")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, body); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".part", path); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", info["manga"], path)
+	return nil
+}
+
+// seriesChapters lists the chapters of the series at u, whatever site it's
+// on, the way handler would get them.
+func seriesChapters(f Fetcher, u *url.URL) ([]Resource, error) {
+	m := &CommonSimpleCrawler{client: f}
+	switch {
+	case isSuwayomi(u):
+		m.api = SuwayomiScraper{*suwayomi}
+	case u.Hostname() == "mangadex.org" || u.Hostname() == "www.mangadex.org":
+		m.api = MangaDexScraper{}
+	default:
+		m.scraper = scraperFor(u)
+		if m.scraper == nil {
+			e, _, err := detectEngine(f, u)
+			if err != nil {
+				return nil, err
+			}
+			if e == nil {
+				return nil, errors.New("no scraper for the site")
+			}
+			m.scraper = e.scraper
+		}
+	}
+	chapters, err := fetchChapters(m, u)
+	if err == nil && len(chapters) == 0 {
+		err = errors.New("no chapters")
+	}
+	return chapters, err
+}
+
+// readURLList reads the URLs in path, one a line, skipping blank ones and
+// those starting with "#".
+func readURLList(path string) ([]string, error) {
+	in := os.Stdin
+	if path != "-" {
+		var err error
+		if in, err = os.Open(path); err != nil {
+			return nil, err
+		}
+		defer in.Close()
+	}
+	var urls []string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	return urls, scanner.Err()
+}
+
+// writeSeriesJSON describes a series the way Mylar does, which Komga, among
+// others, reads.
+func writeSeriesJSON(path string, info Metadata, chapters int) error {
+	status := "Continuing"
+	if s, _ := info["status"].(string); strings.Contains(strings.ToLower(s), "complete") || strings.EqualFold(s, "ended") {
+		status = "Ended"
+	}
+	description, _ := info["description"].(string)
+	metadata := map[string]interface{}{
+		"type":             "comicSeries",
+		"name":             info["manga"],
+		"description_text": strings.TrimSpace(description),
+		"status":           status,
+		"total_issues":     chapters,
+		"booktype":         "Print",
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{"version": "1.0.2", "metadata": metadata}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0660)
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+)
+
+func init() {
+	commands["login"] = loginCommand
+}
+
+// Credentials are what's needed to log in to something.
+type Credentials struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Token is an API key, or the like, used instead of the rest.
+	Token string `json:"token,omitempty"`
+	// ClientID and ClientSecret are an OAuth client's, for services that
+	// want one along with the user's login.
+	ClientID     string `json:"clientId,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+var errNoCredentials = errors.New("no credentials stored")
+
+// A CredentialStore keeps logins out of the config file, by the name of what
+// they're for.
+type CredentialStore interface {
+	Get(name string) (Credentials, error)
+	Set(name string, c Credentials) error
+}
+
+// What there are logins for, and what they go in, if anything in the config;
+// the rest are looked up when they're needed.
+var credentialTargets = map[string]func(*Config, Credentials){
+	"komga": func(config *Config, c Credentials) {
+		if config.Komga != nil {
+			fillMediaServerCredentials(config.Komga, c)
+		}
+	},
+	"kavita": func(config *Config, c Credentials) {
+		if config.Kavita != nil {
+			fillMediaServerCredentials(config.Kavita, c)
+		}
+	},
+	"calibre": func(config *Config, c Credentials) {
+		if config.Calibre != nil && config.Calibre.Password == "" {
+			config.Calibre.Username, config.Calibre.Password = c.Username, c.Password
+		}
+	},
+	"suwayomi": func(config *Config, c Credentials) {
+		if config.Suwayomi != nil && config.Suwayomi.Password == "" {
+			config.Suwayomi.Username, config.Suwayomi.Password = c.Username, c.Password
+		}
+	},
+	"mangadex": nil,
+}
+
+func fillMediaServerCredentials(m *MediaServerConfig, c Credentials) {
+	if m.Password == "" && m.APIKey == "" {
+		m.Username, m.Password, m.APIKey = c.Username, c.Password, c.Token
+	}
+}
+
+// openCredentialStore opens the store the config says to, "keyring" for the
+// system's or "file" for an encrypted file next to the config; by default,
+// the system's if there's one to be had.
+func openCredentialStore(kind string) (CredentialStore, error) {
+	switch kind {
+	case "keyring":
+		return keyring{}, nil
+	case "file":
+		return &credentialFile{Path: filepath.Join(filepath.Dir(configPath()), "credentials")}, nil
+	case "":
+		if keyringAvailable() {
+			return keyring{}, nil
+		}
+		return openCredentialStore("file")
+	}
+	return nil, fmt.Errorf("unknown credential store %q (known: file, keyring)", kind)
+}
+
+// fillCredentials puts the stored logins in the config, where it doesn't have
+// any of its own.
+func fillCredentials(config *Config, store CredentialStore) {
+	for name, fill := range credentialTargets {
+		if fill == nil {
+			continue
+		}
+		c, err := store.Get(name)
+		if errors.Is(err, errNoCredentials) {
+			continue
+		} else if err != nil {
+			log.Printf("cannot get the credentials for %s: %s", name, err)
+			continue
+		}
+		fill(config, c)
+	}
+}
+
+// keyring is the system's: the Secret Service (GNOME Keyring, KWallet) by
+// way of secret-tool on Linux and the like, the Keychain on macOS.
+type keyring struct{}
+
+func keyringAvailable() bool {
+	command := "secret-tool"
+	switch runtime.GOOS {
+	case "darwin":
+		command = "security"
+	case "windows":
+		return false
+	}
+	_, err := exec.LookPath(command)
+	return err == nil
+}
+
+func (keyring) Get(name string) (Credentials, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", "mango", "-a", name, "-w")
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "service", "mango", "account", name)
+	}
+	out, err := cmd.Output()
+	if len(bytes.TrimSpace(out)) == 0 {
+		// both say so by failing, with nothing to go on
+		return Credentials{}, errNoCredentials
+	} else if err != nil {
+		return Credentials{}, err
+	}
+	out = bytes.TrimSpace(out)
+	if runtime.GOOS == "darwin" && !bytes.HasPrefix(out, []byte("{")) {
+		// security gives anything that's not ASCII back in hex
+		if decoded, err := hex.DecodeString(string(out)); err == nil {
+			out = decoded
+		}
+	}
+	var c Credentials
+	err = json.Unmarshal(out, &c)
+	return c, err
+}
+
+func (keyring) Set(name string, c Credentials) error {
+	secret, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// security only takes it as an argument, which anyone can see
+		// with ps unless it's a command read from its stdin; in hex, so
+		// that there's no quoting to get wrong
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s mango -a %s -X %s\n",
+			name, hex.EncodeToString(secret)))
+	} else {
+		cmd = exec.Command("secret-tool", "store", "--label=mango: "+name, "service", "mango", "account", name)
+		cmd.Stdin = bytes.NewReader(secret)
+	}
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// credentialFile keeps the logins in a file encrypted with a passphrase, taken
+// from $MANGO_PASSPHRASE or asked for.  It's an age file, so it can be read,
+// or put together by hand, with "age -d" and "age -p".
+type credentialFile struct {
+	Path string
+
+	passphrase string
+	all        map[string]Credentials // once unlocked
+}
+
+func (f *credentialFile) getPassphrase() (string, error) {
+	if f.passphrase == "" {
+		f.passphrase = os.Getenv("MANGO_PASSPHRASE")
+	}
+	if f.passphrase == "" {
+		if !stdinIsTerminal() {
+			return "", errors.New("set MANGO_PASSPHRASE to unlock the credentials")
+		}
+		var err error
+		if f.passphrase, err = readSecret(bufio.NewReader(os.Stdin), "passphrase for "+f.Path+": "); err != nil {
+			return "", err
+		}
+	}
+	return f.passphrase, nil
+}
+
+func (f *credentialFile) load() (map[string]Credentials, error) {
+	if f.all != nil {
+		return f.all, nil
+	}
+	data, err := os.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return map[string]Credentials{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	passphrase, err := f.getPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), identity)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f.Path, err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f.Path, err)
+	}
+	all := map[string]Credentials{}
+	if err := json.Unmarshal(plain, &all); err != nil {
+		return nil, err
+	}
+	f.all = all
+	return all, nil
+}
+
+func (f *credentialFile) Get(name string) (Credentials, error) {
+	// no need to ask for a passphrase if there's nothing to unlock
+	if !isFile(f.Path) {
+		return Credentials{}, errNoCredentials
+	}
+	all, err := f.load()
+	if err != nil {
+		return Credentials{}, err
+	}
+	c, ok := all[name]
+	if !ok {
+		return Credentials{}, errNoCredentials
+	}
+	return c, nil
+}
+
+func (f *credentialFile) Set(name string, c Credentials) error {
+	all, err := f.load()
+	if err != nil {
+		return err
+	}
+	all[name] = c
+	plain, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := f.getPassphrase()
+	if err != nil {
+		return err
+	}
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	w, err := age.Encrypt(&out, recipient)
+	if err != nil {
+		return err
+	}
+	w.Write(plain)
+	if err := w.Close(); err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(f.Path), os.ModeDir|0700)
+	tmpname := f.Path + ".part"
+	if err := os.WriteFile(tmpname, out.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpname, f.Path)
+}
+
+// readSecret asks for something without echoing it.
+func readSecret(in *bufio.Reader, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	stty := exec.Command("stty", "-echo")
+	stty.Stdin = os.Stdin
+	if stty.Run() == nil {
+		defer func() {
+			restore := exec.Command("stty", "echo")
+			restore.Stdin = os.Stdin
+			restore.Run()
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// loginCommand asks for the login to something mango logs in to and stores
+// it, so that it needn't be in the config.
+func loginCommand(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	storeKind := fs.String("store", "", "keep the login in the `STORE` (keyring or file); by default, as the config says")
+	var names []string
+	for name := range credentialTargets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: mango login [flags] %s\n", strings.Join(names, "|"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := fs.Arg(0)
+	if _, ok := credentialTargets[name]; !ok {
+		fatalf("unknown login %q (known: %s)", name, strings.Join(names, ", "))
+	}
+
+	config, err := loadConfig(configPath())
+	if err != nil {
+		fatalln("cannot load config:", err)
+	}
+	if *storeKind == "" {
+		*storeKind = config.CredentialStore
+	}
+	store, err := openCredentialStore(*storeKind)
+	if err != nil {
+		fatal(err)
+	}
+
+	var c Credentials
+	in := bufio.NewReader(os.Stdin)
+	fmt.Fprint(os.Stderr, "username (empty for none): ")
+	line, _ := in.ReadString('\n')
+	c.Username = strings.TrimSpace(line)
+	if c.Password, err = readSecret(in, "password (empty for none): "); err != nil {
+		fatal(err)
+	}
+	switch name {
+	case "mangadex":
+		// a personal API client, from the settings on the site
+		fmt.Fprint(os.Stderr, "API client ID: ")
+		line, _ := in.ReadString('\n')
+		c.ClientID = strings.TrimSpace(line)
+		if c.ClientSecret, err = readSecret(in, "API client secret: "); err != nil {
+			fatal(err)
+		}
+	case "komga", "kavita":
+		if c.Token, err = readSecret(in, "API key (empty for none): "); err != nil {
+			fatal(err)
+		}
+	}
+	if c == (Credentials{}) {
+		fatal("nothing to store")
+	}
+	if err := store.Set(name, c); err != nil {
+		fatalln("cannot store the login:", err)
+	}
+	log.Println("stored the login for", name)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Schedule tells when something should next happen.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// everySchedule happens at a fixed interval.
+type everySchedule time.Duration
+
+func (d everySchedule) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(d))
+}
+
+// CronSchedule is a schedule written the way crontab(5) does:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field is *, a number, a range (1-5), a step (*/15, 1-30/2) or a comma
+// separated list of those.  Months and days of the week can also be given by
+// their (English, three-letter) names.  As with cron, if both days of the
+// month and of the week are restricted, either of them matching is enough.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// whether dom and dow were *
+	anyDOM, anyDOW bool
+}
+
+var (
+	cronMonths = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDays   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+func ParseCronSchedule(s string) (*CronSchedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron schedule %q: expected 5 fields, got %d", s, len(fields))
+	}
+
+	var c CronSchedule
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron schedule %q: minute: %s", s, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron schedule %q: hour: %s", s, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron schedule %q: day of month: %s", s, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("cron schedule %q: month: %s", s, err)
+	}
+	// 7 is Sunday too
+	if c.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return nil, fmt.Errorf("cron schedule %q: day of week: %s", s, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.anyDOM = fields[2] == "*"
+	c.anyDOW = fields[4] == "*"
+	if c.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron schedule %q: never happens", s)
+	}
+	return &c, nil
+}
+
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			loPart, hiPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = parseCronValue(loPart, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = parseCronValue(hiPart, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// 5/10 means 5-max/10
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return n, nil
+}
+
+func (c *CronSchedule) matchesDay(t time.Time) bool {
+	domOK := c.dom&(1<<uint(t.Day())) != 0
+	dowOK := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDOM && c.anyDOW:
+		return true
+	case c.anyDOM:
+		return dowOK
+	case c.anyDOW:
+		return domOK
+	}
+	return domOK || dowOK
+}
+
+// Next returns the first minute after after that the schedule matches, in
+// after's time zone.  A schedule that never matches (e.g. the 31st of
+// February) returns the zero time; ParseCronSchedule doesn't make those.
+func (c *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// every possible day comes up within a few years
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
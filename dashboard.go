@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How much of the past the dashboard remembers.
+const (
+	dashboardRecent = 50
+	dashboardLog    = 100
+	dashboardErrors = 20
+)
+
+// Dashboard keeps track of what a long-running mango is doing and shows it
+// in a browser.
+//
+//	/            the dashboard
+//	/api/status  the same, as JSON
+type Dashboard struct {
+	mu      sync.Mutex
+	active  map[string]*DashboardChapter
+	recent  []DashboardChapter
+	errors  []string
+	log     []string
+	started time.Time
+}
+
+// DashboardChapter is a chapter that's being, or has been, downloaded.
+type DashboardChapter struct {
+	Series   string    `json:"series"`
+	Chapter  string    `json:"chapter"`
+	Pages    int       `json:"pages"`
+	Done     int       `json:"done"`
+	Path     string    `json:"path,omitempty"`
+	Finished time.Time `json:"finished,omitzero"`
+}
+
+type dashboardStatus struct {
+	Started time.Time          `json:"started"`
+	Tracked []TrackedSeries    `json:"tracked"`
+	Active  []DashboardChapter `json:"active"`
+	Recent  []DashboardChapter `json:"recent"`
+	Errors  []string           `json:"errors"`
+	Log     []string           `json:"log"`
+}
+
+func NewDashboard() *Dashboard {
+	return &Dashboard{active: map[string]*DashboardChapter{}, started: time.Now()}
+}
+
+func dashboardKey(info Metadata) string {
+	return fmt.Sprint(info["manga"], "\x00", info["chapter"])
+}
+
+func (d *Dashboard) OnPageEnd(info Metadata) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := dashboardKey(info)
+	c, ok := d.active[key]
+	if !ok {
+		c = &DashboardChapter{
+			Series:  fmt.Sprint(info["manga"]),
+			Chapter: fmt.Sprint(info["chapter"]),
+		}
+		d.active[key] = c
+	}
+	if pages, ok := info["pages"].(int); ok {
+		c.Pages = pages
+	}
+	c.Done++
+}
+
+func (d *Dashboard) OnChapterEnd(info Metadata) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := dashboardKey(info)
+	c, ok := d.active[key]
+	if !ok {
+		c = &DashboardChapter{
+			Series:  fmt.Sprint(info["manga"]),
+			Chapter: fmt.Sprint(info["chapter"]),
+		}
+	}
+	delete(d.active, key)
+	c.Path, _ = info["path"].(string)
+	c.Finished = time.Now()
+
+	d.recent = append(d.recent, *c)
+	if len(d.recent) > dashboardRecent {
+		d.recent = d.recent[len(d.recent)-dashboardRecent:]
+	}
+}
+
+func (d *Dashboard) OnFailure(info Metadata, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.active, dashboardKey(info))
+
+	d.errors = append(d.errors, fmt.Sprintf("%s %v: %v", info["manga"], info["chapter"], err))
+	if len(d.errors) > dashboardErrors {
+		d.errors = d.errors[len(d.errors)-dashboardErrors:]
+	}
+}
+
+// LogWriter returns a writer that passes what's written to it on to w and
+// also keeps it for the dashboard; it's meant for log.SetOutput.
+func (d *Dashboard) LogWriter(w io.Writer) io.Writer {
+	return dashboardLogWriter{d, w}
+}
+
+type dashboardLogWriter struct {
+	d *Dashboard
+	w io.Writer
+}
+
+func (l dashboardLogWriter) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	// the requests themselves would drown out everything else
+	if !strings.Contains(line, " GET ") {
+		l.d.mu.Lock()
+		l.d.log = append(l.d.log, line)
+		if len(l.d.log) > dashboardLog {
+			l.d.log = l.d.log[len(l.d.log)-dashboardLog:]
+		}
+		l.d.mu.Unlock()
+	}
+	return l.w.Write(p)
+}
+
+func (d *Dashboard) status() dashboardStatus {
+	tracked, err := loadTracked(trackedPath())
+	if err != nil {
+		log.Println("cannot load tracked series:", err)
+	}
+	status := d.snapshot()
+	status.Tracked = tracked
+	return status
+}
+
+// snapshot is the status without the tracked series, which have to be read
+// from disk.
+func (d *Dashboard) snapshot() dashboardStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := dashboardStatus{
+		Started: d.started,
+		Active:  []DashboardChapter{},
+		Errors:  append([]string{}, d.errors...),
+		Log:     append([]string{}, d.log...),
+	}
+	for _, c := range d.active {
+		status.Active = append(status.Active, *c)
+	}
+	sort.Slice(status.Active, func(i, j int) bool {
+		a, b := status.Active[i], status.Active[j]
+		if a.Series != b.Series {
+			return a.Series < b.Series
+		}
+		return a.Chapter < b.Chapter
+	})
+	// newest first
+	for i := len(d.recent) - 1; i >= 0; i-- {
+		status.Recent = append(status.Recent, d.recent[i])
+	}
+	return status
+}
+
+var dashboardTemplate = template.Must(template.New("").Funcs(template.FuncMap{
+	"percent": func(c DashboardChapter) int {
+		if c.Pages == 0 {
+			return 0
+		}
+		return 100 * c.Done / c.Pages
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="5">
+<title>mango</title>
+<style>
+body { font-family: sans-serif; margin: 0 auto; max-width: 50em; padding: 1em; }
+table { border-collapse: collapse; width: 100%; }
+td, th { padding: .2em .5em; text-align: left; }
+progress { width: 100%; }
+pre { background: #eee; overflow-x: auto; padding: .5em; }
+</style>
+</head>
+<body>
+<h1>mango</h1>
+<p>Running since {{.Started.Format "2006-01-02 15:04"}}.</p>
+
+<h2>Downloading</h2>
+{{if .Active}}<table>
+{{range .Active}}<tr><td>{{.Series}}</td><td>{{.Chapter}}</td><td><progress max="100" value="{{percent .}}"></progress></td><td>{{.Done}}/{{.Pages}}</td></tr>
+{{end}}</table>
+{{else}}<p>Nothing.</p>
+{{end}}
+
+<h2>Recent downloads</h2>
+{{if .Recent}}<table>
+{{range .Recent}}<tr><td>{{.Finished.Format "2006-01-02 15:04"}}</td><td>{{.Series}}</td><td>{{.Chapter}}</td></tr>
+{{end}}</table>
+{{else}}<p>None yet.</p>
+{{end}}
+
+{{if .Errors}}<h2>Errors</h2>
+<ul>
+{{range .Errors}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}
+<h2>Tracked series</h2>
+<ul>
+{{range .Tracked}}<li><a href="{{.URL}}">{{or .Title .URL}}</a></li>
+{{else}}<li>None.</li>
+{{end}}</ul>
+
+<h2>Log</h2>
+<pre>{{range .Log}}{{.}}
+{{end}}</pre>
+</body>
+</html>
+`))
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTemplate.Execute(w, d.status()); err != nil {
+			log.Println("dashboard:", err)
+		}
+	case "/api/status":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d.status())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveDashboard serves d on addr.
+func serveDashboard(addr string, d *Dashboard) {
+	go func() {
+		log.Println("dashboard:", http.ListenAndServe(addr, d))
+	}()
+}
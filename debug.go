@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+func init() {
+	commands["debug"] = debugCommand
+}
+
+// debugCommand fetches a page and evaluates CSS selectors against it
+// interactively, for working out what a scraper should look for.
+func debugCommand(args []string) {
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	replay := fs.String("replay", "", "fetch the page from the HAR `FILE` instead of the network")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango debug [flags] URL")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	u, err := url.Parse(fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	fetcher := NewFetcher(1, 1)
+	if *replay != "" {
+		replayer, err := LoadReplayer(*replay)
+		if err != nil {
+			fatalln("cannot load recording:", err)
+		}
+		fetcher.client = &http.Client{Transport: replayer}
+	}
+	doc, err := fetcher.GetHTML(u)
+	if err != nil {
+		fatal(err)
+	}
+
+	fmt.Println(`Enter a CSS selector to list what it matches, ":html SELECTOR" to see`)
+	fmt.Println(`the matches' markup, or ":quit".`)
+	in := bufio.NewScanner(os.Stdin)
+	for fmt.Print("> "); in.Scan(); fmt.Print("> ") {
+		line := strings.TrimSpace(in.Text())
+		showHTML := false
+		switch {
+		case line == "":
+			continue
+		case line == ":quit" || line == ":q":
+			return
+		case strings.HasPrefix(line, ":html "):
+			showHTML = true
+			line = strings.TrimSpace(strings.TrimPrefix(line, ":html "))
+		}
+
+		// goquery panics on bad selectors, so compile them first
+		sel, err := cascadia.Compile(line)
+		if err != nil {
+			fmt.Println("invalid selector:", err)
+			continue
+		}
+		printMatches(doc.FindMatcher(sel), showHTML)
+	}
+	fmt.Println()
+}
+
+func printMatches(matches *goquery.Selection, showHTML bool) {
+	fmt.Printf("%d match(es)\n", matches.Length())
+	matches.Each(func(i int, s *goquery.Selection) {
+		if showHTML {
+			html, _ := goquery.OuterHtml(s)
+			fmt.Printf("[%d] %s\n", i, html)
+			return
+		}
+
+		var attrs []string
+		for _, a := range s.Nodes[0].Attr {
+			attrs = append(attrs, fmt.Sprintf("%s=%q", a.Key, a.Val))
+		}
+		fmt.Printf("[%d] <%s %s> %q\n", i, goquery.NodeName(s), strings.Join(attrs, " "), truncate(80, strings.TrimSpace(s.Text())))
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	_ "net/http/pprof"
+	"runtime"
+)
+
+// serveDebug exposes the net/http/pprof profiles under /debug/pprof/ and
+// runtime metrics under /debug/vars, for looking into hangs and memory use
+// while mango runs.
+func serveDebug(addr string, fetcher Fetcher) {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	// A semaphore that stays full is a good sign of a hang
+	expvar.Publish("connections", expvar.Func(func() interface{} {
+		inUse := map[string]int{}
+		for _, r := range fetcher.domainRules {
+			inUse[r.pattern], _ = r.conns.used()
+		}
+		return inUse
+	}))
+	// and how many there may be, which goes down when servers struggle
+	expvar.Publish("connectionLimits", expvar.Func(func() interface{} {
+		limits := map[string]int{}
+		for _, r := range fetcher.domainRules {
+			_, limits[r.pattern] = r.conns.used()
+		}
+		return limits
+	}))
+
+	go func() {
+		log.Println("debug server:", http.ListenAndServe(addr, nil))
+	}()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"archive/zip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ReusePage copies a page out of the chapter's existing archive, into the
+// chapter being put together, instead of downloading it again; as long as
+// the archive has a page that came from the same URL.  It tells whether it
+// did.
+func (s CBZSaver) ReusePage(info Metadata, u *url.URL) bool {
+	archivename, _ := s.name(info)
+	archive, err := zip.OpenReader(archivename)
+	if err != nil {
+		return false
+	}
+	defer archive.Close()
+
+	old := archivePageFrom(&archive.Reader, u.String())
+	if old == nil {
+		return false
+	}
+	// it's kept the way it was processed then
+	info["imageExtension"] = strings.TrimPrefix(path.Ext(old.Name), ".")
+	_, imagename := s.name(info)
+	tmparchivename := stagingName(s.staging, archivename)
+	os.MkdirAll(tmparchivename, os.ModeDir|0770)
+	if err := extractFile(old, filepath.Join(tmparchivename, imagename)); err != nil {
+		os.Remove(filepath.Join(tmparchivename, imagename))
+		return false
+	}
+	return true
+}
+
+// archivePageFrom finds the page of archive that was downloaded from source,
+// going by the ComicInfo.xml in it.
+func archivePageFrom(archive *zip.Reader, source string) *zip.File {
+	for _, f := range archive.File {
+		if f.Name != "ComicInfo.xml" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil
+		}
+		info, err := parseComicInfo(r)
+		r.Close()
+		if err != nil {
+			return nil
+		}
+		pages, _ := info["pageInfo"].([]ComicPageInfo)
+		entries := archivePages(archive)
+		for _, p := range pages {
+			if p.Key == source && p.Image < len(entries) {
+				return entries[p.Image]
+			}
+		}
+	}
+	return nil
+}
+
+// unchangedChapter tells whether every page of a chapter was taken from its
+// existing archive, which has no others: there's nothing to update.
+func unchangedChapter(info Metadata, archivename string) bool {
+	reused, _ := info["reusedPages"].(int)
+	pages, ok := info["pages"].(int)
+	if !ok || reused != pages {
+		return false
+	}
+	archive, err := zip.OpenReader(archivename)
+	if err != nil {
+		return false
+	}
+	defer archive.Close()
+	return len(archivePages(&archive.Reader)) == pages
+}
+
+// pageSources keeps track of where the pages of a chapter came from, so that
+// it can be told later which ones changed.
+type pageSources struct {
+	mu     sync.Mutex
+	urls   map[int]string
+	reused int
+}
+
+func (s *pageSources) add(info Metadata, u *url.URL, reused bool) {
+	n, ok := info["pageIndex"].(int)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.urls == nil {
+		s.urls = map[int]string{}
+	}
+	// without any password in it, these are kept
+	s.urls[n] = u.Redacted()
+	if reused {
+		s.reused++
+	}
+}
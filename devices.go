@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+)
+
+// Device describes the screen of an e-reader, so that pages can be prepared
+// for it the way KCC does: resized, letterboxed and gamma-corrected.
+type Device struct {
+	Width, Height int
+	Gamma         float64
+}
+
+var devices = map[string]Device{
+	"kindle":             {600, 800, 1.8},
+	"kindle-paperwhite":  {1072, 1448, 1.8},
+	"kindle-paperwhite5": {1236, 1648, 1.8},
+	"kindle-oasis":       {1264, 1680, 1.8},
+	"kindle-scribe":      {1860, 2480, 1.8},
+	"kobo-clara":         {1072, 1448, 1.8},
+	"kobo-libra":         {1264, 1680, 1.8},
+	"kobo-sage":          {1440, 1920, 1.8},
+	"kobo-elipsa":        {1404, 1872, 1.8},
+}
+
+func lookupDevice(name string) (Device, error) {
+	if d, ok := devices[name]; ok {
+		return d, nil
+	}
+
+	names := make([]string, 0, len(devices))
+	for n := range devices {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return Device{}, fmt.Errorf("unknown device %q (known: %s)", name, strings.Join(names, ", "))
+}
+
+// Filters returns the processing chain for the device.
+func (d Device) Filters() []Filter {
+	return []Filter{
+		ResizeFilter{d.Width, d.Height},
+		LetterboxFilter{d.Width, d.Height, color.White},
+		GammaFilter(d.Gamma),
+	}
+}
+
+// A Profile sets everything needed for reading on a particular device at
+// once: the screen to prepare pages for, how to process them and how to
+// name the chapters.  Its settings are only defaults, flags given alongside
+// it still win.
+type Profile struct {
+	Format    string // what chapters are saved as
+	Device    string
+	Grayscale bool
+	Crop      bool
+	Quality   int // for re-encoding pages as JPEGs, if not 0
+	Template  string
+	// Split cuts double-page spreads in two, for screens too small to
+	// show them whole; only mango reprocess can do that.
+	Split bool
+}
+
+// e-readers list books by file name without the directories, so the series
+// goes in the name too
+const readerNameTemplate = "{{.manga}}/{{.manga}} {{.number}}{{with .title}} - {{.}}{{end}}"
+
+var profiles = map[string]Profile{
+	// Kindles take EPUBs by way of Send to Kindle
+	"kindle-pw5":    {"epub", "kindle-paperwhite5", true, true, 85, readerNameTemplate, true},
+	"kindle-oasis":  {"epub", "kindle-oasis", true, true, 85, readerNameTemplate, true},
+	"kindle-scribe": {"epub", "kindle-scribe", true, true, 85, readerNameTemplate, true},
+	"kobo-clara":    {"cbz", "kobo-clara", true, true, 85, readerNameTemplate, true},
+	"kobo-libra":    {"cbz", "kobo-libra", true, true, 85, readerNameTemplate, true},
+	// the color screens of tablets have nothing to gain from grayscale
+	"tablet": {"pdf", "", false, true, 90, defaultNameTemplate, false},
+}
+
+func lookupProfile(name string) (Profile, error) {
+	if p, ok := profiles[name]; ok {
+		return p, nil
+	}
+
+	names := make([]string, 0, len(profiles))
+	for n := range profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return Profile{}, fmt.Errorf("unknown profile %q (known: %s)", name, strings.Join(names, ", "))
+}
+
+// Flags returns the profile as the values of the flags it stands for.
+func (p Profile) Flags() map[string]string {
+	flags := map[string]string{
+		"format":    p.Format,
+		"grayscale": fmt.Sprint(p.Grayscale),
+		"crop":      fmt.Sprint(p.Crop),
+		"template":  p.Template,
+	}
+	if p.Device != "" {
+		flags["device"] = p.Device
+	}
+	if p.Quality > 0 {
+		flags["jpeg-quality"] = fmt.Sprint(p.Quality)
+	}
+	if p.Split {
+		flags["split"] = "true"
+	}
+	return flags
+}
+
+// applyProfile sets the flags of fs that the profile has a say in, unless
+// they were given explicitly.
+func applyProfile(fs *flag.FlagSet, p Profile) {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for name, value := range p.Flags() {
+		if !given[name] {
+			fs.Set(name, value)
+		}
+	}
+}
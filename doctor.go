@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["doctor"] = doctorCommand
+}
+
+// Below this much free space, downloads of any size are likely to fail.
+const lowDiskSpace = 1 << 30
+
+// doctor collects what it finds, so that everything gets checked even when
+// something early on is broken.
+type doctor struct {
+	failed bool
+}
+
+func (d *doctor) ok(format string, args ...interface{}) {
+	fmt.Printf("ok   "+format+"\n", args...)
+}
+
+func (d *doctor) warn(format string, args ...interface{}) {
+	fmt.Printf("warn "+format+"\n", args...)
+}
+
+func (d *doctor) fail(format string, args ...interface{}) {
+	d.failed = true
+	fmt.Printf("FAIL "+format+"\n", args...)
+}
+
+// doctorCommand looks for the usual reasons mango doesn't work: a terminal
+// the progress bars don't render on, an output directory it can't write to,
+// sites it can't reach and a broken configuration.
+func doctorCommand(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango doctor [OUTPUT-DIR]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	output := "."
+	if fs.NArg() > 0 {
+		output = fs.Arg(0)
+	}
+
+	d := &doctor{}
+	d.checkTerminal()
+	d.checkOutput(output)
+	d.checkConfig()
+	d.checkSites()
+
+	if d.failed {
+		os.Exit(1)
+	}
+}
+
+func (d *doctor) checkTerminal() {
+	if fi, err := os.Stdout.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		d.warn("output is not a terminal, progress bars will come out garbled")
+	}
+
+	term := os.Getenv("TERM")
+	switch {
+	case term == "" || term == "dumb":
+		d.warn("TERM is %q, progress bars need a terminal that understands escape codes", term)
+	case strings.Contains(term, "256color") || os.Getenv("COLORTERM") != "":
+		d.ok("terminal supports 256 colours (TERM=%s)", term)
+	default:
+		d.warn("TERM=%s may not support 256 colours, progress bars may look off", term)
+	}
+
+	locale := os.Getenv("LC_ALL")
+	if locale == "" {
+		locale = os.Getenv("LC_CTYPE")
+	}
+	if locale == "" {
+		locale = os.Getenv("LANG")
+	}
+	if l := strings.ToLower(locale); strings.Contains(l, "utf-8") || strings.Contains(l, "utf8") {
+		d.ok("locale is UTF-8 (%s)", locale)
+	} else {
+		d.warn("locale %q is not UTF-8, consider -ascii for the progress bars", locale)
+	}
+}
+
+func (d *doctor) checkOutput(dir string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		d.fail("output directory %s: %s", dir, err)
+		return
+	}
+
+	probe, err := os.CreateTemp(abs, ".mango-doctor-")
+	if err != nil {
+		d.fail("cannot write to output directory %s: %s", abs, err)
+		return
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	d.ok("output directory %s is writable", abs)
+
+	free, err := freeSpace(abs)
+	switch {
+	case err != nil:
+		d.warn("cannot tell how much space is free in %s: %s", abs, err)
+	case free < lowDiskSpace:
+		d.warn("only %s free in %s", formatBytes(free), abs)
+	default:
+		d.ok("%s free in %s", formatBytes(free), abs)
+	}
+}
+
+func (d *doctor) checkConfig() {
+	path := configPath()
+	if path == "" {
+		d.warn("cannot find the configuration directory")
+		return
+	}
+
+	config, err := loadConfig(path)
+	if err != nil {
+		d.fail("config %s: %s", path, err)
+		return
+	}
+	problems := 0
+	if len(config.Gradient) > 0 {
+		if _, err := ParseLinearGradient(config.Gradient); err != nil {
+			d.fail("config %s: gradient: %s", path, err)
+			problems++
+		}
+	}
+	if _, err := NewBlacklist(config.Blacklist, config.BlacklistDistance); err != nil {
+		d.fail("config %s: blacklist: %s", path, err)
+		problems++
+	}
+	if _, err := lookupProviders(config.MetadataProviders); err != nil {
+		d.fail("config %s: %s", path, err)
+		problems++
+	}
+	if _, err := NewThrottle(config.Bandwidth); err != nil {
+		d.fail("config %s: bandwidth: %s", path, err)
+		problems++
+	}
+	if config.Schedule != "" {
+		if _, err := ParseCronSchedule(config.Schedule); err != nil {
+			d.fail("config %s: %s", path, err)
+			problems++
+		}
+	}
+	if problems == 0 {
+		d.ok("config %s is valid", path)
+	}
+
+	if _, err := loadTracked(trackedPath()); err != nil {
+		d.fail("tracked series %s: %s", trackedPath(), err)
+	}
+}
+
+func (d *doctor) checkSites() {
+	// a broken config has been reported already
+	if config, err := loadConfig(configPath()); err == nil && config.Suwayomi != nil {
+		healthChecks["suwayomi"] = suwayomiCheck(*config.Suwayomi)
+	}
+	var sites []string
+	for site := range healthChecks {
+		sites = append(sites, site)
+	}
+	sort.Strings(sites)
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	for _, site := range sites {
+		u, _ := url.Parse(healthChecks[site].url)
+		home := u.Scheme + "://" + u.Host + "/"
+
+		resp, err := client.Head(home)
+		if err != nil {
+			d.fail("cannot reach %s: %s", site, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			d.fail("%s answered %s", site, resp.Status)
+		} else {
+			d.ok("%s is reachable", site)
+		}
+	}
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
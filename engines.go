@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// An engine is software that many sites run on, so that one scraper does for
+// all of them.  Sites without a scraper of their own are checked for one.
+type engine struct {
+	name    string
+	detect  func(doc *goquery.Document) bool
+	scraper Scraper
+	// series finds the series a chapter's page is of; it's false for the
+	// page of the series itself.
+	series func(doc *goquery.Document) (*url.URL, bool)
+}
+
+var engines = []engine{
+	{"madara", isMadara, MadaraScraper{}, madaraSeries},
+	{"foolslide", isFoolSlide, FoolSlideScraper{}, foolSlideSeries},
+	{"mangathemesia", isMangaThemesia, MangaThemesiaScraper{}, mangaThemesiaSeries},
+}
+
+// detectEngine fetches u and looks for the marks the engines leave on their
+// pages.  The page comes along, as it's needed next.
+func detectEngine(f Fetcher, u *url.URL) (*engine, *goquery.Document, error) {
+	doc, err := f.GetHTML(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range engines {
+		if engines[i].detect(doc) {
+			return &engines[i], doc, nil
+		}
+	}
+	return nil, doc, nil
+}
+
+type EngineCrawler struct {
+	CommonSimpleCrawler
+	engine *engine
+	doc    *goquery.Document
+}
+
+// NewEngineCrawler handles a site running e, with doc the page it was
+// detected on.
+func NewEngineCrawler(common CommonSimpleCrawler, e *engine, doc *goquery.Document) *EngineCrawler {
+	common.scraper = e.scraper
+	return &EngineCrawler{common, e, doc}
+}
+
+func (m *EngineCrawler) Handle(u *url.URL) {
+	mangaURL := u
+	if series, ok := m.engine.series(m.doc); ok {
+		// a chapter url, or one of its pages'
+		userPath := strings.TrimRight(u.EscapedPath(), "/")
+		whitelistRule := funcRule(func(r Resource) bool {
+			chapterPath := strings.TrimRight(r.url.EscapedPath(), "/")
+			return userPath != chapterPath && !strings.HasPrefix(userPath, chapterPath+"/")
+		})
+		m.rule = AndRule{whitelistRule, m.rule}
+		mangaURL = series
+	}
+	log.Printf("%s: looks like %s", u.Hostname(), m.engine.name)
+	m.handleManga(mangaURL)
+}
+
+// ownText is the text of s, without that of its children (badges and such).
+func ownText(s *goquery.Selection) string {
+	return strings.TrimSpace(s.Clone().Children().Remove().End().Text())
+}
+
+// joinText is the text of every one of s, separated by commas.
+func joinText(s *goquery.Selection) string {
+	var texts []string
+	s.Each(func(i int, s *goquery.Selection) {
+		if t := strings.TrimSpace(s.Text()); t != "" {
+			texts = append(texts, t)
+		}
+	})
+	return strings.Join(texts, ", ")
+}
+
+// engineChapterRe pulls the number and the name out of "Chapter 12 - Name"
+// and the like.
+var engineChapterRe = regexp.MustCompile(`(?i)(?:chapter|ch\.?)\s*([0-9][0-9.]*)(?:\s*[-:]\s*(.*))?`)
+
+// engineChapter makes a chapter of a link, numbered index.  The lists are
+// newest first, so they're numbered backwards.
+func engineChapter(doc *goquery.Document, href, title string, index int) Resource {
+	u, err := doc.Url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		fatalln("cannot extract chapters:", err)
+	}
+	title = strings.TrimSpace(title)
+	chapterinfo := Metadata{
+		"chapterIndex": index,
+		"chapter":      chapterMetadata(title),
+		"url":          u.String(),
+	}
+	if match := engineChapterRe.FindStringSubmatch(title); match != nil {
+		chapterinfo["chapter"] = chapterMetadata(match[1])
+		chapterinfo["chapterName"] = strings.TrimSpace(match[2])
+	}
+	return Resource{u, chapterinfo}
+}
+
+// engineImages makes the pages of a chapter of its images' URLs.
+func engineImages(doc *goquery.Document, srcs []string) (images []Resource) {
+	for i, src := range srcs {
+		u, err := doc.Url.Parse(strings.TrimSpace(src))
+		if err != nil {
+			fatalln("cannot extract pages:", err)
+		}
+		ext := strings.TrimPrefix(path.Ext(u.Path), ".")
+		if ext == "" {
+			ext = "jpg" // the real type is sniffed on download
+		}
+		images = append(images, Resource{u, Metadata{
+			"pages":          len(srcs),
+			"pageIndex":      i + 1,
+			"imageExtension": ext,
+		}})
+	}
+	if len(images) < 1 {
+		fatal("cannot extract pages: none found")
+	}
+	return
+}
+
+// scriptJSON decodes what re's first group matches in the page's scripts.
+func scriptJSON(doc *goquery.Document, re *regexp.Regexp, v interface{}) bool {
+	found := false
+	doc.Find("script").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if match := re.FindStringSubmatch(s.Text()); match != nil {
+			found = json.Unmarshal([]byte(match[1]), v) == nil
+		}
+		return !found
+	})
+	return found
+}
+
+// The chapter lists are in the series' page, newest first; the images are
+// all in the chapter's, so GetImage is only there to be a Scraper.
+
+// MadaraScraper is for the WordPress theme.
+type MadaraScraper struct{}
+
+func isMadara(doc *goquery.Document) bool {
+	return doc.Find("body[class*='wp-manga'], link[href*='/themes/madara'], script[src*='/themes/madara']").Length() > 0
+}
+
+func madaraSeries(doc *goquery.Document) (*url.URL, bool) {
+	if doc.Find(".reading-content").Length() == 0 {
+		return nil, false
+	}
+	var series *url.URL
+	doc.Find(".breadcrumb a").Each(func(i int, s *goquery.Selection) {
+		if u, err := doc.Url.Parse(s.AttrOr("href", "")); err == nil && u.Path != doc.Url.Path {
+			series = u
+		}
+	})
+	return series, series != nil
+}
+
+func (m MadaraScraper) GetChapters(doc *goquery.Document) (chapters []Resource) {
+	mangainfo := Metadata{
+		"manga":       ownText(doc.Find(".post-title h1, .post-title h3").First()),
+		"author":      joinText(doc.Find(".author-content a")),
+		"artist":      joinText(doc.Find(".artist-content a")),
+		"status":      strings.TrimSpace(doc.Find(".post-status .post-content_item:contains('Status') .summary-content").Text()),
+		"genres":      doc.Find(".genres-content a").Map(mapSelectionText),
+		"description": strings.TrimSpace(doc.Find(".description-summary .summary__content, .manga-excerpt").First().Text()),
+		"coverImage":  doc.Find(".summary_image img").AttrOr("src", ""),
+	}
+	if len(mangainfo["manga"].(string)) < 1 {
+		fatal("cannot extract chapters: no manga name")
+	}
+
+	links := doc.Find("li.wp-manga-chapter")
+	mangainfo["chapters"] = links.Length()
+	links.Each(func(i int, s *goquery.Selection) {
+		a := s.Find("a").First()
+		c := engineChapter(doc, a.AttrOr("href", ""), a.Text(), links.Length()-i)
+		if uploaded, ok := parseDate(s.Find(".chapter-release-date").Text()); ok {
+			c.info["uploaded"] = uploaded
+		}
+		c.info.Update(mangainfo)
+		chapters = append(chapters, c)
+	})
+
+	if len(chapters) < 1 {
+		// newer versions load them separately, by a POST
+		fatal("cannot extract chapters: none found (the site may load them separately, which isn't supported)")
+	}
+	return
+}
+
+func (m MadaraScraper) GetPages(doc *goquery.Document) (pages []Resource, images []Resource) {
+	var srcs []string
+	doc.Find(".reading-content img").Each(func(i int, s *goquery.Selection) {
+		// lazy loading keeps the real one elsewhere
+		for _, attr := range []string{"data-src", "data-lazy-src", "src"} {
+			if src := strings.TrimSpace(s.AttrOr(attr, "")); src != "" {
+				srcs = append(srcs, src)
+				return
+			}
+		}
+	})
+	return nil, engineImages(doc, srcs)
+}
+
+func (m MadaraScraper) GetImage(doc *goquery.Document) Resource {
+	_, images := m.GetPages(doc)
+	return images[0]
+}
+
+// FoolSlideScraper is for FoOlSlide, which scanlation groups run.
+type FoolSlideScraper struct{}
+
+func isFoolSlide(doc *goquery.Document) bool {
+	return strings.Contains(strings.ToLower(doc.Text()), "foolslide")
+}
+
+func foolSlideSeries(doc *goquery.Document) (*url.URL, bool) {
+	if !strings.Contains(doc.Url.Path, "/read/") {
+		return nil, false
+	}
+	u, err := doc.Url.Parse(doc.Find("a[href*='/series/']").First().AttrOr("href", ""))
+	return u, err == nil && strings.Contains(u.Path, "/series/")
+}
+
+func (m FoolSlideScraper) GetChapters(doc *goquery.Document) (chapters []Resource) {
+	mangainfo := Metadata{
+		"manga":       strings.TrimSpace(doc.Find("h1.title").First().Text()),
+		"description": strings.TrimSpace(nextTextNode(doc.Find(".info b:contains('Description')")).Text()),
+		"coverImage":  doc.Find(".thumbnail img").AttrOr("src", ""),
+	}
+	if len(mangainfo["manga"].(string)) < 1 {
+		fatal("cannot extract chapters: no manga name")
+	}
+
+	links := doc.Find(".list .element")
+	mangainfo["chapters"] = links.Length()
+	links.Each(func(i int, s *goquery.Selection) {
+		a := s.Find(".title a").First()
+		c := engineChapter(doc, a.AttrOr("href", ""), a.AttrOr("title", a.Text()), links.Length()-i)
+		// "by Group, 2019.01.02"
+		meta := s.Find(".meta_r").Text()
+		if comma := strings.LastIndex(meta, ","); comma >= 0 {
+			if uploaded, ok := parseDate(meta[comma+1:]); ok {
+				c.info["uploaded"] = uploaded
+			}
+		}
+		if group := strings.TrimSpace(s.Find(".meta_r a").First().Text()); group != "" {
+			c.info["group"] = group
+		}
+		c.info.Update(mangainfo)
+		chapters = append(chapters, c)
+	})
+
+	if len(chapters) < 1 {
+		fatal("cannot extract chapters: none found")
+	}
+	return
+}
+
+var (
+	foolSlidePagesRe  = regexp.MustCompile(`var pages = (\[.*?\]);`)
+	foolSlideBase64Re = regexp.MustCompile(`var pages = JSON\.parse\(atob\("([^"]*)"\)\)`)
+)
+
+func (m FoolSlideScraper) GetPages(doc *goquery.Document) (pages []Resource, images []Resource) {
+	var list []struct {
+		URL string `json:"url"`
+	}
+	if !scriptJSON(doc, foolSlidePagesRe, &list) {
+		// some hide them a little
+		var encoded string
+		doc.Find("script").Each(func(i int, s *goquery.Selection) {
+			if match := foolSlideBase64Re.FindStringSubmatch(s.Text()); match != nil {
+				encoded = match[1]
+			}
+		})
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || json.Unmarshal(decoded, &list) != nil {
+			fatal("cannot extract pages: no page list")
+		}
+	}
+	var srcs []string
+	for _, p := range list {
+		srcs = append(srcs, p.URL)
+	}
+	return nil, engineImages(doc, srcs)
+}
+
+func (m FoolSlideScraper) GetImage(doc *goquery.Document) Resource {
+	_, images := m.GetPages(doc)
+	return images[0]
+}
+
+// MangaThemesiaScraper is for the WordPress themes whose reader is ts_reader.
+type MangaThemesiaScraper struct{}
+
+var tsReaderRe = regexp.MustCompile(`ts_reader\.run\((\{.*?\})\);`)
+
+func isMangaThemesia(doc *goquery.Document) bool {
+	return doc.Find("#chapterlist").Length() > 0 || tsReaderRe.MatchString(doc.Find("script").Text())
+}
+
+func mangaThemesiaSeries(doc *goquery.Document) (*url.URL, bool) {
+	if !tsReaderRe.MatchString(doc.Find("script").Text()) {
+		return nil, false
+	}
+	u, err := doc.Url.Parse(doc.Find(".allc a, .headpost a").First().AttrOr("href", ""))
+	return u, err == nil && u.Path != doc.Url.Path
+}
+
+func (m MangaThemesiaScraper) GetChapters(doc *goquery.Document) (chapters []Resource) {
+	field := func(name string) string {
+		return strings.TrimSpace(doc.Find(".imptdt:contains('" + name + "') i, .fmed:contains('" + name + "') span").First().Text())
+	}
+	mangainfo := Metadata{
+		"manga":       strings.TrimSpace(doc.Find("h1.entry-title").First().Text()),
+		"author":      field("Author"),
+		"artist":      field("Artist"),
+		"status":      field("Status"),
+		"genres":      doc.Find(".mgen a").Map(mapSelectionText),
+		"description": strings.TrimSpace(doc.Find(".entry-content[itemprop='description']").Text()),
+		"coverImage":  doc.Find(".thumb img").AttrOr("src", ""),
+	}
+	if len(mangainfo["manga"].(string)) < 1 {
+		fatal("cannot extract chapters: no manga name")
+	}
+
+	links := doc.Find("#chapterlist li")
+	mangainfo["chapters"] = links.Length()
+	links.Each(func(i int, s *goquery.Selection) {
+		a := s.Find("a").First()
+		c := engineChapter(doc, a.AttrOr("href", ""), s.Find(".chapternum").Text(), links.Length()-i)
+		if num, ok := s.Attr("data-num"); ok {
+			c.info["chapter"] = chapterMetadata(num)
+		}
+		if uploaded, ok := parseDate(s.Find(".chapterdate").Text()); ok {
+			c.info["uploaded"] = uploaded
+		}
+		c.info.Update(mangainfo)
+		chapters = append(chapters, c)
+	})
+
+	if len(chapters) < 1 {
+		fatal("cannot extract chapters: none found")
+	}
+	return
+}
+
+func (m MangaThemesiaScraper) GetPages(doc *goquery.Document) (pages []Resource, images []Resource) {
+	var reader struct {
+		Sources []struct {
+			Images []string `json:"images"`
+		} `json:"sources"`
+	}
+	if !scriptJSON(doc, tsReaderRe, &reader) || len(reader.Sources) == 0 {
+		fatal("cannot extract pages: no ts_reader")
+	}
+	return nil, engineImages(doc, reader.Sources[0].Images)
+}
+
+func (m MangaThemesiaScraper) GetImage(doc *goquery.Document) Resource {
+	_, images := m.GetPages(doc)
+	return images[0]
+}
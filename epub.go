@@ -0,0 +1,283 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"html"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var epubMediaTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".avif": "image/avif",
+	".jxl":  "image/jxl",
+}
+
+// An epubPage is one of the pages of a chapter, as it goes in an EPUB.
+type epubPage struct {
+	file          string // where it is now
+	image, xhtml  string // where it goes in the EPUB, under OEBPS
+	width, height int
+	spread        string // the side of a two-page spread it's on
+}
+
+// writeEPUB makes a fixed-layout EPUB 3 out of the pages in dir, one page
+// per image.  It's laid out the way Kindles (by way of Send to Kindle) and
+// Kobos expect comics to be.
+func writeEPUB(dir string, info Metadata, out *os.File) error {
+	defer out.Close()
+
+	files, err := pageFiles(dir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no pages in %s", dir)
+	}
+	pages := make([]epubPage, len(files))
+	wide := make([]bool, len(files))
+	for i, file := range files {
+		ext := strings.ToLower(filepath.Ext(file))
+		if ext == ".jpeg" {
+			ext = ".jpg"
+		}
+		pages[i] = epubPage{
+			file:  file,
+			image: fmt.Sprintf("images/%04d%s", i, ext),
+			xhtml: fmt.Sprintf("pages/%04d.xhtml", i),
+		}
+		if pages[i].width, pages[i].height, err = imageSize(file); err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(file), err)
+		}
+		wide[i] = pages[i].width > pages[i].height
+	}
+	for i, spread := range pageSpreads(wide, isRightToLeft(info)) {
+		pages[i].spread = spread
+	}
+
+	archive := zip.NewWriter(out)
+	// the mimetype has to come first, and uncompressed, so that it can be
+	// told what the file is from its first bytes
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	io.WriteString(w, "application/epub+zip")
+
+	docs := []struct {
+		name, content string
+	}{
+		{"META-INF/container.xml", epubContainer},
+		{"OEBPS/content.opf", epubPackage(info, pages)},
+		{"OEBPS/nav.xhtml", epubNav(info, pages)},
+	}
+	for _, p := range pages {
+		docs = append(docs, struct{ name, content string }{"OEBPS/" + p.xhtml, epubPageXHTML(p)})
+	}
+	for _, f := range docs {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, f.content); err != nil {
+			return err
+		}
+	}
+	for _, p := range pages {
+		// they're compressed already
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: "OEBPS/" + p.image, Method: zip.Store, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if err := copyFileTo(w, p.file); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+func epubPackage(info Metadata, pages []epubPage) string {
+	rtl := isRightToLeft(info)
+	id := "urn:uuid:" + newUUID()
+	if u, ok := info["url"].(string); ok {
+		id = u
+	}
+	language, _ := info["language"].(string)
+	if language == "" {
+		language = "und"
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(&b, "    <dc:identifier id=\"id\">%s</dc:identifier>\n", html.EscapeString(id))
+	fmt.Fprintf(&b, "    <dc:title>%s</dc:title>\n", html.EscapeString(bookTitle(info)))
+	fmt.Fprintf(&b, "    <dc:language>%s</dc:language>\n", html.EscapeString(language))
+	for _, role := range []struct{ key, code string }{{"author", "aut"}, {"artist", "art"}} {
+		if name, _ := info[role.key].(string); name != "" {
+			fmt.Fprintf(&b, "    <dc:creator id=\"%s\">%s</dc:creator>\n", role.key, html.EscapeString(name))
+			fmt.Fprintf(&b, "    <meta refines=\"#%s\" property=\"role\" scheme=\"marc:relators\">%s</meta>\n", role.key, role.code)
+		}
+	}
+	if description, _ := info["description"].(string); description != "" {
+		fmt.Fprintf(&b, "    <dc:description>%s</dc:description>\n", html.EscapeString(description))
+	}
+	if genres, ok := info["genres"].([]string); ok {
+		for _, g := range genres {
+			fmt.Fprintf(&b, "    <dc:subject>%s</dc:subject>\n", html.EscapeString(g))
+		}
+	}
+	if group, _ := info["group"].(string); group != "" {
+		fmt.Fprintf(&b, "    <dc:contributor>%s</dc:contributor>\n", html.EscapeString(group))
+	}
+	if uploaded, ok := info["uploaded"].(time.Time); ok {
+		fmt.Fprintf(&b, "    <dc:date>%s</dc:date>\n", uploaded.Format("2006-01-02"))
+	}
+	// the series the EPUB 3 way, and calibre's, which most readers go by
+	series := html.EscapeString(fmt.Sprint(info["manga"]))
+	fmt.Fprintf(&b, "    <meta property=\"belongs-to-collection\" id=\"series\">%s</meta>\n", series)
+	b.WriteString("    <meta refines=\"#series\" property=\"collection-type\">series</meta>\n")
+	fmt.Fprintf(&b, "    <meta name=\"calibre:series\" content=\"%s\"/>\n", series)
+	if index := seriesIndex(info["chapter"]); index != "" {
+		fmt.Fprintf(&b, "    <meta refines=\"#series\" property=\"group-position\">%s</meta>\n", index)
+		fmt.Fprintf(&b, "    <meta name=\"calibre:series_index\" content=\"%s\"/>\n", index)
+	}
+	fmt.Fprintf(&b, "    <meta property=\"dcterms:modified\">%s</meta>\n", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	b.WriteString(`    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:spread">landscape</meta>
+    <meta name="fixed-layout" content="true"/>
+    <meta name="book-type" content="comic"/>
+    <meta name="cover" content="image0"/>
+`)
+	if rtl {
+		// for the Kindle's sake; the spine is enough for everyone else
+		b.WriteString("    <meta name=\"primary-writing-mode\" content=\"horizontal-rl\"/>\n")
+	}
+	b.WriteString("  </metadata>\n  <manifest>\n")
+	b.WriteString("    <item id=\"nav\" href=\"nav.xhtml\" media-type=\"application/xhtml+xml\" properties=\"nav\"/>\n")
+	for i, p := range pages {
+		properties := ""
+		if i == 0 {
+			properties = ` properties="cover-image"`
+		}
+		fmt.Fprintf(&b, "    <item id=\"image%d\" href=\"%s\" media-type=\"%s\"%s/>\n",
+			i, p.image, epubMediaTypes[filepath.Ext(p.image)], properties)
+		fmt.Fprintf(&b, "    <item id=\"page%d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i, p.xhtml)
+	}
+	b.WriteString("  </manifest>\n")
+
+	direction := "ltr"
+	if rtl {
+		direction = "rtl"
+	}
+	fmt.Fprintf(&b, "  <spine page-progression-direction=\"%s\">\n", direction)
+	for i, p := range pages {
+		fmt.Fprintf(&b, "    <itemref idref=\"page%d\" properties=\"%s\"/>\n", i, epubSpreadProperty(p.spread))
+	}
+	b.WriteString("  </spine>\n</package>\n")
+	return b.String()
+}
+
+func epubSpreadProperty(spread string) string {
+	if spread == "center" {
+		return "rendition:page-spread-center"
+	}
+	return "page-spread-" + spread
+}
+
+func epubNav(info Metadata, pages []epubPage) string {
+	title := html.EscapeString(bookTitle(info))
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>%s</title></head>
+<body>
+  <nav epub:type="toc"><ol><li><a href="%s">%s</a></li></ol></nav>
+</body>
+</html>
+`, title, pages[0].xhtml, title)
+}
+
+func epubPageXHTML(p epubPage) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+  <title>%s</title>
+  <meta name="viewport" content="width=%d, height=%d"/>
+  <style>html, body { margin: 0; padding: 0; } img { display: block; width: %dpx; height: %dpx; }</style>
+</head>
+<body><img src="../%s" alt=""/></body>
+</html>
+`, filepath.Base(p.xhtml), p.width, p.height, p.width, p.height, p.image)
+}
+
+// pageSpreads works out which side of a two-page spread each page goes on
+// ("left", "right" or "center", for a page on its own).  The cover is on its
+// own and so are double pages; the rest are paired up, the first of each pair
+// on the right when reading from right to left.
+func pageSpreads(wide []bool, rtl bool) []string {
+	first, second := "left", "right"
+	if rtl {
+		first, second = second, first
+	}
+
+	spreads := make([]string, len(wide))
+	next := first
+	for i := range wide {
+		if i == 0 || wide[i] {
+			spreads[i] = "center"
+			next = first
+			continue
+		}
+		spreads[i] = next
+		if next == first {
+			next = second
+		} else {
+			next = first
+		}
+	}
+	return spreads
+}
+
+func imageSize(path string) (width, height int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	return config.Width, config.Height, err
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
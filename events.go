@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event is something that happened to a chapter, or a series:
+//
+//	chapter_started   the first page of a chapter is done
+//	page_failed       a page couldn't be downloaded
+//	chapter_finished  a chapter was saved, with its size and how long it took
+//	failed            a chapter or series was given up on
+type Event struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Series   string    `json:"series,omitempty"`
+	Chapter  string    `json:"chapter,omitempty"`
+	Page     int       `json:"page,omitempty"`
+	Pages    int       `json:"pages,omitempty"`
+	Bytes    int64     `json:"bytes,omitempty"`
+	Duration float64   `json:"duration,omitempty"` // in seconds
+	Path     string    `json:"path,omitempty"`
+	URL      string    `json:"url,omitempty"`
+	Missing  []int     `json:"missing,omitempty"`
+	Error    string    `json:"error,omitempty"`
+
+	// Info is everything known about the chapter (or page) at the time and
+	// Err what went wrong, if anything; they're for programs embedding
+	// mango, and not logged.
+	Info Metadata `json:"-"`
+	Err  error    `json:"-"`
+}
+
+type eventChapter struct {
+	started time.Time
+	bytes   int64
+}
+
+// EventStream is an Observer that turns what happens into Events, for anyone
+// who subscribed to them.  Subscribers have to keep up, downloads wait for
+// them otherwise.
+type EventStream struct {
+	mu       sync.Mutex
+	subs     map[chan Event]bool
+	chapters map[string]*eventChapter
+}
+
+func NewEventStream() *EventStream {
+	return &EventStream{subs: map[chan Event]bool{}, chapters: map[string]*eventChapter{}}
+}
+
+// Subscribe returns a channel with every event from now on, and the function
+// to call once done with it, which closes it.
+func (s *EventStream) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	s.mu.Lock()
+	s.subs[ch] = true
+	s.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subs, ch)
+			s.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// emit sends e to every subscriber; the caller holds the lock.
+func (s *EventStream) emit(e Event) {
+	e.Time = time.Now()
+	for ch := range s.subs {
+		ch <- e
+	}
+}
+
+func chapterEvent(event string, info Metadata) Event {
+	e := Event{Event: event, Info: info}
+	if manga, ok := info["manga"]; ok {
+		e.Series = fmt.Sprint(manga)
+	}
+	if chapter, ok := info["chapter"]; ok {
+		e.Chapter = fmt.Sprint(chapter)
+	}
+	e.Pages, _ = info["pages"].(int)
+	return e
+}
+
+func (s *EventStream) OnPageEnd(info Metadata) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := dashboardKey(info)
+	c, ok := s.chapters[key]
+	if !ok {
+		c = &eventChapter{started: time.Now()}
+		if started, ok := info["started"].(time.Time); ok {
+			c.started = started
+		}
+		s.chapters[key] = c
+		s.emit(chapterEvent("chapter_started", info))
+	}
+	if n, ok := info["bytes"].(int64); ok {
+		c.bytes += n
+	}
+
+	if failed, _ := info["failed"].(bool); failed {
+		e := chapterEvent("page_failed", info)
+		e.Page, _ = info["pageIndex"].(int)
+		e.Error, _ = info["error"].(string)
+		s.emit(e)
+	}
+}
+
+func (s *EventStream) OnChapterEnd(info Metadata) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := chapterEvent("chapter_finished", info)
+	e.Path, _ = info["path"].(string)
+	e.Missing, _ = info["missingPages"].([]int)
+	key := dashboardKey(info)
+	if c, ok := s.chapters[key]; ok {
+		e.Bytes = c.bytes
+		e.Duration = time.Since(c.started).Seconds()
+		delete(s.chapters, key)
+	}
+	s.emit(e)
+}
+
+func (s *EventStream) OnFailure(info Metadata, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := chapterEvent("failed", info)
+	e.URL, _ = info["url"].(string)
+	e.Error = err.Error()
+	e.Err = err
+	s.emit(e)
+	delete(s.chapters, dashboardKey(info))
+}
+
+// EventLog appends the events of an EventStream to a JSON Lines file, one
+// per line, for other programs to tail.
+type EventLog struct {
+	file        *os.File
+	unsubscribe func()
+	done        chan empty
+}
+
+func OpenEventLog(path string, stream *EventStream) (*EventLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return nil, err
+	}
+	events, unsubscribe := stream.Subscribe(64)
+	l := &EventLog{file: file, unsubscribe: unsubscribe, done: make(chan empty)}
+	go func() {
+		defer close(l.done)
+		enc := json.NewEncoder(file)
+		for e := range events {
+			if err := enc.Encode(e); err != nil {
+				fmt.Fprintln(os.Stderr, "cannot write event:", err)
+			}
+		}
+	}()
+	return l, nil
+}
+
+// Close writes out whatever events are left and closes the file.
+func (l *EventLog) Close() error {
+	l.unsubscribe()
+	<-l.done
+	return l.file.Close()
+}
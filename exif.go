@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// jpegSegments calls fn with each marker segment of a JPEG up to the start of
+// the image data; fn returns false to stop.  It returns the offset where the
+// segments end or -1 if the file isn't a JPEG we understand.
+func jpegSegments(data []byte, fn func(marker byte, segment []byte) bool) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return -1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return -1
+		}
+		marker := data[i+1]
+		if marker == 0xDA {
+			// start of scan, compressed data follows
+			return i
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return -1
+		}
+		if !fn(marker, data[i:i+2+length]) {
+			return i
+		}
+		i += 2 + length
+	}
+	return -1
+}
+
+// jpegOrientation returns the EXIF orientation (1-8) of a JPEG, 1 meaning
+// upright, or 0 if it has none.
+func jpegOrientation(data []byte) (orientation int) {
+	jpegSegments(data, func(marker byte, segment []byte) bool {
+		payload := segment[4:]
+		if marker != 0xE1 || !bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return true
+		}
+		orientation = exifOrientation(payload[6:])
+		return false
+	})
+	return
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// stripJPEGMetadata losslessly removes the EXIF, XMP and Photoshop segments
+// from a JPEG.
+func stripJPEGMetadata(data []byte) []byte {
+	var out bytes.Buffer
+	out.Write(data[:2])
+	end := jpegSegments(data, func(marker byte, segment []byte) bool {
+		// APP1 is EXIF/XMP, APP13 is Photoshop's IRB
+		if marker != 0xE1 && marker != 0xED {
+			out.Write(segment)
+		}
+		return true
+	})
+	if end < 0 {
+		return data
+	}
+	out.Write(data[end:])
+	return out.Bytes()
+}
+
+// orient transforms img so that it's upright given its EXIF orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dstBounds := image.Rect(0, 0, w, h)
+	if orientation >= 5 {
+		// these ones swap the axes
+		dstBounds = image.Rect(0, 0, h, w)
+	}
+	dst := newLike(img, dstBounds)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
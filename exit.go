@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// What has to be put back, or saved, when mango dies early, on a fatal error
+// or a signal, where deferred calls don't get to run: the terminal the TUI
+// took over, the recording of the requests.
+var atExit struct {
+	sync.Mutex
+	funcs []func()
+	once  sync.Once
+}
+
+// onExit has f run if mango dies early.  The last one added runs first, the
+// way defer does.
+func onExit(f func()) {
+	atExit.Lock()
+	defer atExit.Unlock()
+	atExit.funcs = append(atExit.funcs, f)
+}
+
+// runAtExit runs what onExit was given, only the first time it's called;
+// anyone else calling it meanwhile waits for it to finish.
+func runAtExit() {
+	atExit.once.Do(func() {
+		atExit.Lock()
+		funcs := atExit.funcs
+		atExit.Unlock()
+		for i := len(funcs) - 1; i >= 0; i-- {
+			funcs[i]()
+		}
+	})
+}
+
+// fatal, fatalf and fatalln are log.Fatal and co, but they clean up first,
+// so that the message makes it to the terminal.
+func fatal(v ...interface{}) {
+	die(fmt.Sprint(v...))
+}
+
+func fatalf(format string, v ...interface{}) {
+	die(fmt.Sprintf(format, v...))
+}
+
+func fatalln(v ...interface{}) {
+	die(fmt.Sprintln(v...))
+}
+
+func die(msg string) {
+	runAtExit()
+	log.Output(3, msg)
+	os.Exit(1)
+}
+
+// exitOnSignal cleans up before going down on an interrupt or a SIGTERM.
+func exitOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		runAtExit()
+		log.Println("stopped by", sig)
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
+}
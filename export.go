@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["export"] = exportCommand
+}
+
+// exportedChapter is a manifest entry as it's exported, with the size of the
+// archive as it is now.
+type exportedChapter struct {
+	ManifestEntry
+	// Size is -1 if the file's gone.
+	Size int64 `json:"size"`
+}
+
+// exportCommand dumps the manifest, for looking into the library with other
+// tools or showing it to others.
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	manifestFormat, manifestPath := manifestFlags(fs)
+	format := fs.String("format", "csv", "write the catalog as `FORMAT` (csv or json)")
+	output := fs.String("o", "", "write the catalog to `FILE` instead of the standard output")
+	series := fs.String("series", "", "only export the chapters of `SERIES`")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango export [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *format != "csv" && *format != "json" {
+		fatalf("unknown format %q (known: csv, json)", *format)
+	}
+
+	manifest, err := openManifest(*manifestFormat, *manifestPath, manifestRead)
+	if err != nil {
+		fatalln("cannot open manifest:", err)
+	}
+	if manifest == nil {
+		fatal("export needs a manifest to know what's been downloaded")
+	}
+	entries, err := manifest.Entries(*series)
+	manifest.Close()
+	if err != nil {
+		fatalln("cannot read manifest:", err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Series != entries[j].Series {
+			return entries[i].Series < entries[j].Series
+		}
+		return chapterLess(entries[i].Chapter, entries[j].Chapter)
+	})
+	chapters := []exportedChapter{}
+	for _, e := range entries {
+		c := exportedChapter{e, -1}
+		if fi, err := os.Stat(e.Path); err == nil {
+			c.Size = fi.Size()
+		}
+		chapters = append(chapters, c)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fatal(err)
+		}
+		defer file.Close()
+		out = file
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(chapters)
+	} else {
+		err = exportCSV(out, chapters)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func exportCSV(out io.Writer, chapters []exportedChapter) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"series", "chapter", "group", "pages", "missing", "size", "uploaded", "downloaded", "source", "path", "hash", "cid"})
+	for _, c := range chapters {
+		var missing []string
+		for _, p := range c.Missing {
+			missing = append(missing, strconv.Itoa(p))
+		}
+		size := ""
+		if c.Size >= 0 {
+			size = strconv.FormatInt(c.Size, 10)
+		}
+		w.Write([]string{
+			c.Series, c.Chapter, c.Group, strconv.Itoa(c.Pages), strings.Join(missing, " "), size,
+			exportTime(c.Uploaded), exportTime(c.Downloaded), c.Source, c.Path, c.Hash, c.CID,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func exportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// newLike returns an empty image with bounds r that can hold the colors of
+// img; grayscale stays grayscale, everything else becomes RGBA.
+func newLike(img image.Image, r image.Rectangle) draw.Image {
+	if _, ok := img.(*image.Gray); ok {
+		return image.NewGray(r)
+	}
+	return image.NewRGBA(r)
+}
+
+// ResizeFilter scales pages down, keeping their aspect ratio, so that they fit
+// in Width×Height.  Pages are never scaled up.
+type ResizeFilter struct {
+	Width, Height int
+}
+
+func (f ResizeFilter) Filter(img image.Image, info Metadata) image.Image {
+	b := img.Bounds()
+	scale := math.Min(float64(f.Width)/float64(b.Dx()), float64(f.Height)/float64(b.Dy()))
+	if scale >= 1 {
+		return img
+	}
+
+	w := int(float64(b.Dx())*scale + 0.5)
+	h := int(float64(b.Dy())*scale + 0.5)
+	dst := newLike(img, image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// LetterboxFilter centers pages on a Width×Height canvas so that readers
+// don't rescale them themselves (usually badly).
+type LetterboxFilter struct {
+	Width, Height int
+	Background    color.Color
+}
+
+func (f LetterboxFilter) Filter(img image.Image, info Metadata) image.Image {
+	b := img.Bounds()
+	if b.Dx() >= f.Width && b.Dy() >= f.Height {
+		return img
+	}
+
+	dst := newLike(img, image.Rect(0, 0, max(f.Width, b.Dx()), max(f.Height, b.Dy())))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(f.Background), image.Point{}, draw.Src)
+
+	offset := image.Pt((dst.Bounds().Dx()-b.Dx())/2, (dst.Bounds().Dy()-b.Dy())/2)
+	draw.Draw(dst, b.Sub(b.Min).Add(offset), img, b.Min, draw.Src)
+	return dst
+}
+
+// GammaFilter applies a gamma curve to every channel.  Values above 1 darken
+// the midtones, which is what e-ink screens usually need.
+type GammaFilter float64
+
+func (f GammaFilter) Filter(img image.Image, info Metadata) image.Image {
+	if f == 1 || f <= 0 {
+		return img
+	}
+
+	var lut [256]uint8
+	for i := range lut {
+		lut[i] = uint8(255*math.Pow(float64(i)/255, float64(f)) + 0.5)
+	}
+
+	b := img.Bounds()
+	switch dst := newLike(img, b).(type) {
+	case *image.Gray:
+		draw.Draw(dst, b, img, b.Min, draw.Src)
+		for i, y := range dst.Pix {
+			dst.Pix[i] = lut[y]
+		}
+		return dst
+
+	case *image.RGBA:
+		draw.Draw(dst, b, img, b.Min, draw.Src)
+		for i, c := range dst.Pix {
+			if i%4 != 3 {
+				// leave alpha alone
+				dst.Pix[i] = lut[c]
+			}
+		}
+		return dst
+	}
+	return img
+}
+
+// GrayscaleFilter turns pages into 8-bit grayscale.
+type GrayscaleFilter struct{}
+
+func (GrayscaleFilter) Filter(img image.Image, info Metadata) image.Image {
+	if _, ok := img.(*image.Gray); ok {
+		return img
+	}
+
+	b := img.Bounds()
+	dst := image.NewGray(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	return dst
+}
+
+// CropFilter trims uniform white or black borders off pages.  Tolerance is
+// how far (0-255) a pixel's luminance may be from the border's and still
+// count as part of it.
+type CropFilter struct {
+	Tolerance uint8
+}
+
+func (f CropFilter) Filter(img image.Image, info Metadata) image.Image {
+	sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if !ok {
+		return img
+	}
+
+	b := img.Bounds()
+	gray := image.NewGray(b)
+	draw.Draw(gray, b, img, b.Min, draw.Src)
+
+	// Whatever the top-left corner is decides whether we trim white or black
+	var background uint8
+	if gray.GrayAt(b.Min.X, b.Min.Y).Y >= 128 {
+		background = 255
+	}
+	isMargin := func(x, y int) bool {
+		c := gray.GrayAt(x, y).Y
+		if c > background {
+			return c-background <= f.Tolerance
+		}
+		return background-c <= f.Tolerance
+	}
+	// A line is still margin if only a speck or two of noise is on it
+	uniform := func(n, length int) bool {
+		return n <= length/200
+	}
+
+	crop := b
+	for ; crop.Min.Y < crop.Max.Y; crop.Min.Y++ {
+		n := 0
+		for x := crop.Min.X; x < crop.Max.X; x++ {
+			if !isMargin(x, crop.Min.Y) {
+				n++
+			}
+		}
+		if !uniform(n, crop.Dx()) {
+			break
+		}
+	}
+	for ; crop.Max.Y > crop.Min.Y; crop.Max.Y-- {
+		n := 0
+		for x := crop.Min.X; x < crop.Max.X; x++ {
+			if !isMargin(x, crop.Max.Y-1) {
+				n++
+			}
+		}
+		if !uniform(n, crop.Dx()) {
+			break
+		}
+	}
+	for ; crop.Min.X < crop.Max.X; crop.Min.X++ {
+		n := 0
+		for y := crop.Min.Y; y < crop.Max.Y; y++ {
+			if !isMargin(crop.Min.X, y) {
+				n++
+			}
+		}
+		if !uniform(n, crop.Dy()) {
+			break
+		}
+	}
+	for ; crop.Max.X > crop.Min.X; crop.Max.X-- {
+		n := 0
+		for y := crop.Min.Y; y < crop.Max.Y; y++ {
+			if !isMargin(crop.Max.X-1, y) {
+				n++
+			}
+		}
+		if !uniform(n, crop.Dy()) {
+			break
+		}
+	}
+
+	if crop.Empty() {
+		// blank page, nothing sensible to crop it to
+		return img
+	}
+	return sub.SubImage(crop)
+}
+
+// splitSpread cuts a double-page spread, a page wider than it's tall, into
+// its two pages, in reading order; anything else is left whole.  It's not a
+// Filter since it makes two pages out of one.
+func splitSpread(img image.Image, rtl bool) []image.Image {
+	b := img.Bounds()
+	if b.Dx() <= b.Dy() {
+		return []image.Image{img}
+	}
+
+	middle := b.Min.X + b.Dx()/2
+	left := image.Rect(b.Min.X, b.Min.Y, middle, b.Max.Y)
+	right := image.Rect(middle, b.Min.Y, b.Max.X, b.Max.Y)
+	halves := make([]image.Image, 2)
+	for i, r := range []image.Rectangle{left, right} {
+		dst := newLike(img, r.Sub(r.Min))
+		draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+		halves[i] = dst
+	}
+	if rtl {
+		halves[0], halves[1] = halves[1], halves[0]
+	}
+	return halves
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// moveFile moves src to dst so that dst either doesn't exist or is complete.
+// A rename does that on its own; across filesystems, src is copied next to
+// dst, under a name no one else will use, and renamed from there.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".mango-*")
+	if err != nil {
+		return err
+	}
+	tmp.Chmod(0660)
+	tmp.Close()
+	tmpname := tmp.Name()
+	if err := copyFile(src, tmpname); err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+	if err := os.Rename(tmpname, dst); err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+	syncDir(filepath.Dir(dst))
+	return os.Remove(src)
+}
+
+// moveDir is moveFile for directories.
+func moveDir(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	tmpname, err := os.MkdirTemp(filepath.Dir(dst), ".mango-*")
+	if err != nil {
+		return err
+	}
+	if err := copyDir(src, tmpname); err != nil {
+		os.RemoveAll(tmpname)
+		return err
+	}
+	if err := os.Rename(tmpname, dst); err != nil {
+		os.RemoveAll(tmpname)
+		return err
+	}
+	syncDir(filepath.Dir(dst))
+	return os.RemoveAll(src)
+}
+
+// copyFile copies src to dst and makes sure it's on disk before returning.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, os.ModeDir|0770)
+		}
+		return copyFile(path, target)
+	})
+}
+
+// syncDir makes a rename in dir durable.  Not every platform can open
+// directories for that, so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
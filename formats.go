@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An archiveFormat is a kind of file chapters can be saved as.
+type archiveFormat struct {
+	ext string
+	// write makes the file out of the pages put together in dir, and
+	// closes it.
+	write func(dir string, info Metadata, out *os.File) error
+}
+
+var archiveFormats = map[string]archiveFormat{
+	"cbz": {".cbz", func(dir string, info Metadata, out *os.File) error {
+		var saver CBZSaver
+		saver.addMetadataFiles(info, dir)
+		return saver.writeArchive(dir, out)
+	}},
+	"epub": {".epub", writeEPUB},
+	"pdf":  {".pdf", writePDF},
+}
+
+func lookupArchiveFormat(name string) (archiveFormat, error) {
+	if f, ok := archiveFormats[name]; ok {
+		return f, nil
+	}
+
+	names := make([]string, 0, len(archiveFormats))
+	for n := range archiveFormats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return archiveFormat{}, fmt.Errorf("unknown format %q (known: %s)", name, strings.Join(names, ", "))
+}
+
+// pageFiles lists the pages put together in dir, in order.
+func pageFiles(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var pages []string
+	for _, f := range files {
+		if f.IsDir() || !isPageEntry(f.Name()) || strings.HasSuffix(f.Name(), ".part") {
+			continue
+		}
+		pages = append(pages, filepath.Join(dir, f.Name()))
+	}
+	sortNatural(pages)
+	return pages, nil
+}
+
+// isRightToLeft tells whether a chapter is read from right to left, as manga
+// usually are.
+func isRightToLeft(info Metadata) bool {
+	return info["readingDirection"] == "rtl"
+}
+
+// bookTitle is what a chapter is called when it's a book of its own, the way
+// e-book readers and calibre list them: "One Piece 1000: Straw Hat Luffy".
+func bookTitle(info Metadata) string {
+	title := fmt.Sprint(info["manga"])
+	if seriesIndex(info["chapter"]) != "" {
+		title += " " + fmt.Sprint(info["chapter"])
+	}
+	if name, _ := info["chapterName"].(string); name != "" {
+		title += ": " + name
+	}
+	return title
+}
+
+// seriesIndex is the chapter's number as the decimal e-book software wants
+// for its position in the series, or empty if it doesn't have one.
+func seriesIndex(chapter interface{}) string {
+	switch c := chapter.(type) {
+	case ChapterNumber:
+		if c == (ChapterNumber{}) {
+			return ""
+		}
+		if c.Minor == "" {
+			return strconv.Itoa(c.Major)
+		}
+		return strconv.Itoa(c.Major) + "." + c.Minor
+	case string:
+		if n, ok := ParseChapterNumber(c); ok {
+			return seriesIndex(n)
+		}
+	}
+	return ""
+}
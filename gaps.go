@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+func init() {
+	commands["gaps"] = gapsCommand
+}
+
+// gapsCommand compares the chapters the manifest has of each series with the
+// ones its site lists, and reports those that are missing.
+func gapsCommand(args []string) {
+	fs := flag.NewFlagSet("gaps", flag.ExitOnError)
+	manifestFormat, manifestPath := manifestFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango gaps [flags] [URL...]")
+		fmt.Fprintln(os.Stderr, "With no URLs, the tracked series are checked.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	manifest, err := openManifest(*manifestFormat, *manifestPath, manifestRead)
+	if err != nil {
+		fatalln("cannot open manifest:", err)
+	}
+	if manifest == nil {
+		fatal("gaps needs a manifest to know what's been downloaded")
+	}
+	entries, err := manifest.Entries("")
+	manifest.Close()
+	if err != nil {
+		fatalln("cannot read manifest:", err)
+	}
+	have := map[string]map[string]bool{}
+	for _, e := range entries {
+		k := seriesKey(e.Series)
+		if have[k] == nil {
+			have[k] = map[string]bool{}
+		}
+		have[k][chapterKey(e.Chapter)] = true
+	}
+
+	targets := watchTargets(fs.Args())
+	if len(targets) == 0 {
+		fatal("no URLs given and no tracked series")
+	}
+
+	fetcher := NewFetcher(4, 2)
+	// the manifest has the series under whatever name they were given
+	passes := MetadataPasses{NewOverridePass(Metadata{}, overridesDir()), NormalizePass{}}
+	incomplete := 0
+	for _, t := range targets {
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			fatal(err)
+		}
+		chapters, err := seriesChapters(fetcher, u)
+		if err != nil {
+			fatalf("%s: %s", u, err)
+		}
+
+		var series string
+		var missing []string
+		// a chapter may have been released more than once, by different
+		// groups; it counts once
+		listed := map[string]bool{}
+		for _, c := range chapters {
+			passes.Apply(c.info)
+			series = fmt.Sprint(c.info["manga"])
+			chapter := fmt.Sprint(c.info["chapter"])
+			key := seriesKey(series) + "\x00" + chapterKey(chapter)
+			if listed[key] {
+				continue
+			}
+			listed[key] = true
+			if !have[seriesKey(series)][chapterKey(chapter)] {
+				missing = append(missing, chapter)
+			}
+		}
+		if len(missing) > 0 {
+			incomplete++
+			sort.SliceStable(missing, func(i, j int) bool { return chapterLess(missing[i], missing[j]) })
+			fmt.Printf("%s: missing %s (%d of %d)\n", series, chapterRanges(missing), len(missing), len(listed))
+		}
+	}
+
+	if incomplete > 0 {
+		fmt.Printf("%d series with missing chapters\n", incomplete)
+		os.Exit(1)
+	}
+}
+
+// chapterKey makes "010" and "10" the same chapter.
+func chapterKey(s string) string {
+	if c, ok := ParseChapterNumber(s); ok {
+		return c.String()
+	}
+	return s
+}
+
+// chapterRanges lists sorted chapters, putting whole numbers that follow
+// each other together: 1-3, 5, 6.5.
+func chapterRanges(chapters []string) string {
+	var out []string
+	for i := 0; i < len(chapters); {
+		first, ok := ParseChapterNumber(chapters[i])
+		j := i + 1
+		if ok && first.Minor == "" && first.Suffix == "" {
+			for j < len(chapters) {
+				next, ok := ParseChapterNumber(chapters[j])
+				if !ok || next.Minor != "" || next.Suffix != "" || next.Major != first.Major+(j-i) {
+					break
+				}
+				j++
+			}
+		}
+		if j-i > 1 {
+			out = append(out, chapters[i]+"-"+chapters[j-1])
+		} else {
+			out = append(out, chapters[i])
+		}
+		i = j
+	}
+	return strings.Join(out, ", ")
+}
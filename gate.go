@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ChapterGate is a Rule that holds back new chapters while it's paused.  Any
+// of the chapters waiting at it can be skipped instead.
+type ChapterGate struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	paused  bool
+	waiting []GateChapter
+	skip    map[string]bool
+}
+
+// GateChapter is a chapter waiting at a ChapterGate.
+type GateChapter struct {
+	Series, Chapter string
+	key             string
+}
+
+func NewChapterGate() *ChapterGate {
+	g := &ChapterGate{skip: map[string]bool{}}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+func (g *ChapterGate) Block(r Resource) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		return false
+	}
+
+	c := GateChapter{
+		Series:  fmt.Sprint(r.info["manga"]),
+		Chapter: fmt.Sprint(r.info["chapter"]),
+		key:     dashboardKey(r.info),
+	}
+	g.waiting = append(g.waiting, c)
+	for g.paused && !g.skip[c.key] {
+		g.cond.Wait()
+	}
+	for i := range g.waiting {
+		if g.waiting[i].key == c.key {
+			g.waiting = append(g.waiting[:i], g.waiting[i+1:]...)
+			break
+		}
+	}
+	skipped := g.skip[c.key]
+	delete(g.skip, c.key)
+	return skipped
+}
+
+// Pause holds back new chapters until Resume; it's a no-op if they already
+// are.
+func (g *ChapterGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = true
+}
+
+func (g *ChapterGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = false
+	g.cond.Broadcast()
+}
+
+func (g *ChapterGate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// Skip lets the waiting chapter c go without downloading it.
+func (g *ChapterGate) Skip(c GateChapter) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.skip[c.key] = true
+	g.cond.Broadcast()
+}
+
+// Waiting lists the chapters being held back, in the order they came.
+func (g *ChapterGate) Waiting() []GateChapter {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]GateChapter{}, g.waiting...)
+}
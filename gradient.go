@@ -1,8 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"image/color"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 // LinearGradient is a linear gradient.
@@ -42,3 +45,36 @@ func (lg LinearGradient) At(t float64) color.Color {
 	t = N*t - float64(i) + 1
 	return blend(colorA, colorB, t)
 }
+
+// ParseLinearGradient builds a gradient out of "#rrggbb" (or "#rgb") strings.
+func ParseLinearGradient(colors []string) (LinearGradient, error) {
+	if len(colors) < 2 {
+		return nil, fmt.Errorf("gradient needs at least 2 colors, got %d", len(colors))
+	}
+
+	lg := make(LinearGradient, 0, len(colors))
+	for _, s := range colors {
+		c, err := parseHexColor(s)
+		if err != nil {
+			return nil, err
+		}
+		lg = append(lg, c)
+	}
+	return lg, nil
+}
+
+func parseHexColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+	return color.RGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 0xff}, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The parts of HTTP Archive (HAR) 1.2 that mango writes; browsers' developer
+// tools and most HTTP debugging tools can open these.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []harHeader `json:"headers"`
+	QueryString []harHeader `json:"queryString"`
+	Cookies     []struct{}  `json:"cookies"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []harHeader `json:"headers"`
+	Cookies     []struct{}  `json:"cookies"`
+	Content     harContent  `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// redactedHeaders carry logins and sessions, which have no place in a file
+// that gets attached to bug reports.
+var redactedHeaders = map[string]bool{"Authorization": true, "Cookie": true, "Set-Cookie": true}
+
+func harHeaders(h http.Header) []harHeader {
+	headers := []harHeader{}
+	for name, values := range h {
+		for _, v := range values {
+			if redactedHeaders[http.CanonicalHeaderKey(name)] {
+				v = "[redacted]"
+			}
+			headers = append(headers, harHeader{name, v})
+		}
+	}
+	return headers
+}
+
+// Recorder is an http.RoundTripper that writes every request made through
+// it, and the response to it, to a HAR file as it goes.  The file is whole
+// after each one, so it's there even when mango is killed.
+type Recorder struct {
+	Transport http.RoundTripper
+
+	mu      sync.Mutex
+	file    *os.File
+	entries int
+}
+
+// harTrailer closes the entries and the log; each new entry goes over it.
+const harTrailer = "\n]}}\n"
+
+func OpenRecorder(path string, transport http.RoundTripper) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	head := `{"log": {"version": "1.2", "creator": {"name": "mango", "version": "1"}, "entries": [`
+	if _, err := file.WriteString(head + harTrailer); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Recorder{Transport: transport, file: file}, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	resp, err := r.Transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	waited := time.Since(started)
+
+	// The body has to be read to be recorded; whoever made the request
+	// gets a copy.
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	received := time.Since(started)
+
+	query := []harHeader{}
+	for name, values := range req.URL.Query() {
+		for _, v := range values {
+			query = append(query, harHeader{name, v})
+		}
+	}
+
+	entry := harEntry{
+		StartedDateTime: started,
+		Time:            milliseconds(received),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Headers:     harHeaders(req.Header),
+			QueryString: query,
+			Cookies:     []struct{}{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: harResponse{
+			Status:      resp.StatusCode,
+			StatusText:  http.StatusText(resp.StatusCode),
+			HTTPVersion: resp.Proto,
+			Headers:     harHeaders(resp.Header),
+			Cookies:     []struct{}{},
+			Content:     harBody(body, resp.Header.Get("Content-Type")),
+			RedirectURL: resp.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    len(body),
+		},
+		Timings: harTimings{
+			Send:    0,
+			Wait:    milliseconds(waited),
+			Receive: milliseconds(received - waited),
+		},
+	}
+
+	if err := r.write(entry); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *Recorder) write(entry harEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entries == 0 {
+		sep = "\n"
+	}
+	if _, err := r.file.Seek(-int64(len(harTrailer)), io.SeekEnd); err != nil {
+		return err
+	}
+	if _, err := r.file.WriteString(sep + string(data) + harTrailer); err != nil {
+		return err
+	}
+	r.entries++
+	return nil
+}
+
+// harBody stores text as it is and anything else (i.e. images) in base64.
+func harBody(body []byte, mimeType string) harContent {
+	content := harContent{Size: len(body), MimeType: mimeType}
+	isText := strings.HasPrefix(mimeType, "text/") ||
+		strings.Contains(mimeType, "json") ||
+		strings.Contains(mimeType, "xml") ||
+		strings.Contains(mimeType, "javascript")
+	if isText && utf8.Valid(body) {
+		content.Text = string(body)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(body)
+		content.Encoding = "base64"
+	}
+	return content
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (r *Recorder) Close() error {
+	return r.file.Close()
+}
+
+// Replayer is an http.RoundTripper that answers requests from a HAR file
+// instead of the network.  A request that was made more than once gets the
+// recorded responses in order, and the last one after that.
+type Replayer struct {
+	mu        sync.Mutex
+	responses map[string][]harResponse
+}
+
+func LoadReplayer(path string) (*Replayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, err
+	}
+
+	r := &Replayer{responses: map[string][]harResponse{}}
+	for _, e := range har.Log.Entries {
+		key := e.Request.Method + " " + e.Request.URL
+		r.responses[key] = append(r.responses[key], e.Response)
+	}
+	return r, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.Method + " " + req.URL.String()
+
+	r.mu.Lock()
+	queue := r.responses[key]
+	if len(queue) == 0 {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("%s is not in the recording", key)
+	}
+	recorded := queue[0]
+	if len(queue) > 1 {
+		r.responses[key] = queue[1:]
+	}
+	r.mu.Unlock()
+
+	body := []byte(recorded.Content.Text)
+	if recorded.Content.Encoding == "base64" {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(recorded.Content.Text); err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+	}
+
+	header := http.Header{}
+	for _, h := range recorded.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, recorded.StatusText),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
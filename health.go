@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+func init() {
+	commands["health"] = healthCommand
+}
+
+// A healthCheck is a series that's known to exist on a site; if its scraper
+// can't make sense of it, the site has most likely changed its markup, or its
+// API.
+type healthCheck struct {
+	scraper interface{} // a Scraper or an APIScraper
+	url     string
+}
+
+var healthChecks = map[string]healthCheck{
+	"mangareader":   {MangaReaderScraper{}, "https://www.mangareader.net/one-piece"},
+	"mangaeden":     {MangaEdenScraper{}, "https://www.mangaeden.com/en/en-manga/one-piece/"},
+	"mangastream":   {MangaStreamerScraper{}, "https://readms.net/manga/one_piece"},
+	"mangadex":      {MangaDexScraper{}, "https://mangadex.org/title/a1c7c817-4e59-43b7-9365-09675a149a6f"},
+	"madara":        {MadaraScraper{}, "https://www.mangaread.org/manga/one-piece/"},
+	"foolslide":     {FoolSlideScraper{}, "https://reader.deathtollscans.net/series/wonder_cat_kyuu_chan/"},
+	"mangathemesia": {MangaThemesiaScraper{}, "https://mangagalaxy.org/series/one-piece/"},
+}
+
+// suwayomiCheck is for the configured Suwayomi server.  Any series will do,
+// and the first one added to the server is as good as any.
+func suwayomiCheck(config SuwayomiConfig) healthCheck {
+	return healthCheck{SuwayomiScraper{config}, strings.TrimRight(config.URL, "/") + "/manga/1"}
+}
+
+// healthCommand runs the scrapers against the live sites and reports which
+// of them still work.
+func healthCommand(args []string) {
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	inProcess := fs.Bool("in-process", false, "check a single `SITE` in this process; any failure is fatal")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango health [flags] [SITE...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	config, err := loadConfig(configPath())
+	if err != nil {
+		fatalln("cannot load config:", err)
+	}
+	if config.Suwayomi != nil {
+		healthChecks["suwayomi"] = suwayomiCheck(*config.Suwayomi)
+	}
+
+	sites := fs.Args()
+	if len(sites) == 0 {
+		for site := range healthChecks {
+			sites = append(sites, site)
+		}
+		sort.Strings(sites)
+	}
+	for _, site := range sites {
+		if _, ok := healthChecks[site]; !ok {
+			fatalln("unknown site", site)
+		}
+	}
+
+	if *inProcess {
+		if len(sites) != 1 {
+			fatal("-in-process checks exactly one site")
+		}
+		if sites[0] == "suwayomi" {
+			// only the check itself needs the login
+			store, err := openCredentialStore(config.CredentialStore)
+			if err != nil {
+				fatalln("cannot load config:", err)
+			}
+			fillCredentials(&config, store)
+			healthChecks["suwayomi"] = suwayomiCheck(*config.Suwayomi)
+		}
+		checkHealth(healthChecks[sites[0]])
+		return
+	}
+
+	// The scrapers give up with fatal, so each check gets a process of
+	// its own.
+	self, err := os.Executable()
+	if err != nil {
+		fatal(err)
+	}
+	failed := 0
+	for _, site := range sites {
+		var stderr bytes.Buffer
+		cmd := exec.Command(self, "health", "-in-process", site)
+		cmd.Stdin = os.Stdin // for the credentials' passphrase
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %s\n", site, lastLine(stderr.String(), err))
+		} else {
+			fmt.Printf("ok   %s\n", site)
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkHealth goes from the series to its first chapter's pages, the same way
+// a download would.
+func checkHealth(check healthCheck) {
+	fetcher := NewFetcher(4, 2)
+
+	u, err := url.Parse(check.url)
+	if err != nil {
+		fatal(err)
+	}
+	if api, ok := check.scraper.(APIScraper); ok {
+		checkAPIHealth(fetcher, api, u)
+		return
+	}
+	scraper := check.scraper.(Scraper)
+	doc, err := fetcher.GetHTML(u)
+	if err != nil {
+		fatal(err)
+	}
+	chapters := scraper.GetChapters(doc)
+	if len(chapters) == 0 {
+		fatal("cannot extract chapters: none found")
+	}
+
+	doc, err = fetcher.GetHTML(chapters[0].url)
+	if err != nil {
+		fatal(err)
+	}
+	pages, images := scraper.GetPages(doc)
+	if len(pages)+len(images) == 0 {
+		fatal("cannot extract pages: none found")
+	}
+	if len(images) == 0 {
+		doc, err = fetcher.GetHTML(pages[0].url)
+		if err != nil {
+			fatal(err)
+		}
+		scraper.GetImage(doc)
+	}
+}
+
+func checkAPIHealth(fetcher Fetcher, api APIScraper, u *url.URL) {
+	chapters, err := api.Chapters(fetcher, u)
+	if err != nil {
+		fatalln("cannot extract chapters:", err)
+	}
+	if len(chapters) == 0 {
+		fatal("cannot extract chapters: none found")
+	}
+	images, err := api.Pages(fetcher, chapters[0])
+	if err != nil {
+		fatalln("cannot extract pages:", err)
+	}
+	if len(images) == 0 {
+		fatal("cannot extract pages: none found")
+	}
+}
+
+// lastLine returns the last thing a failed check logged, which is usually
+// why it failed.
+func lastLine(output string, err error) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if last := lines[len(lines)-1]; last != "" {
+		// drop the log timestamp
+		if fields := strings.SplitN(last, " ", 3); len(fields) == 3 {
+			return fields[2]
+		}
+		return last
+	}
+	return err.Error()
+}
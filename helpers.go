@@ -2,8 +2,9 @@ package main
 
 import (
 	"io"
-	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"golang.org/x/net/html"
@@ -25,13 +26,33 @@ func nextTextNode(s *goquery.Selection) *goquery.Selection {
 	return s.Slice(0, 0).AddNodes(textNodes...)
 }
 
+// The ways sites like to write dates.
+var dateLayouts = []string{
+	"01/02/2006",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"2 Jan 2006",
+	"2006-01-02",
+	"2006.01.02",
+}
+
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
 func isFile(path string) bool {
 	finfo, err := os.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false
 		}
-		log.Fatal(err)
+		fatal(err)
 	}
 	// There are more things than directories that are not files (e.g. sockets,
 	// devices, etc)
@@ -44,7 +65,7 @@ func isDir(path string) bool {
 		if os.IsNotExist(err) {
 			return false
 		}
-		log.Fatal(err)
+		fatal(err)
 	}
 	return finfo.IsDir()
 }
@@ -79,6 +100,8 @@ type ProgressWriter struct {
 	Writer   io.WriteCloser
 	Size     int64
 	Callback func(int64, int64)
+	// Done, if set, is called once the writer is closed.
+	Done func()
 
 	progress int64
 }
@@ -94,5 +117,8 @@ func (p *ProgressWriter) Write(buf []byte) (int, error) {
 }
 
 func (p *ProgressWriter) Close() error {
+	if p.Done != nil {
+		defer p.Done()
+	}
 	return p.Writer.Close()
 }
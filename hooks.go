@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HooksConfig holds the commands run at various points of a download.  They
+// are run by the shell, with what they're about described in MANGO_*
+// environment variables.
+type HooksConfig struct {
+	// Chapter runs after every chapter that's saved.
+	Chapter string `json:"chapter,omitempty"`
+	// Series runs once for every series that got new chapters, at the end
+	// of a run.
+	Series string `json:"series,omitempty"`
+	// Filter runs before every chapter is downloaded, and can veto it; see
+	// FilterHook.
+	Filter string `json:"filter,omitempty"`
+}
+
+// shellCommand runs command with the platform's shell.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
+
+// hookEnv describes a chapter (or series) to a hook.
+func hookEnv(event string, info Metadata) []string {
+	env := []string{"MANGO_EVENT=" + event}
+	vars := map[string]string{
+		"MANGO_SERIES":  "manga",
+		"MANGO_CHAPTER": "chapter",
+		"MANGO_TITLE":   "chapterName",
+		"MANGO_PATH":    "path",
+		"MANGO_URL":     "url",
+		"MANGO_PAGES":   "pages",
+		"MANGO_GROUP":   "group",
+	}
+	for name, key := range vars {
+		if v, ok := info[key]; ok && v != nil {
+			env = append(env, name+"="+fmt.Sprint(v))
+		}
+	}
+	if missing, ok := info["missingPages"].([]int); ok {
+		env = append(env, "MANGO_MISSING_PAGES="+joinInts(missing))
+	}
+	return env
+}
+
+func runHook(command, event string, info Metadata) {
+	cmd := shellCommand(command)
+	cmd.Env = append(os.Environ(), hookEnv(event, info)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	started := time.Now()
+	if err := cmd.Run(); err != nil {
+		log.Printf("%s hook for %v: %s", event, info["manga"], err)
+	} else if took := time.Since(started); took > time.Minute {
+		log.Printf("%s hook for %v took %s", event, info["manga"], took.Round(time.Second))
+	}
+}
+
+// HookObserver runs the chapter and series hooks.
+type HookObserver struct {
+	Hooks HooksConfig
+
+	mu     sync.Mutex
+	series map[string]Metadata
+}
+
+func NewHookObserver(hooks HooksConfig) *HookObserver {
+	return &HookObserver{Hooks: hooks, series: map[string]Metadata{}}
+}
+
+func (o *HookObserver) OnPageEnd(info Metadata) {}
+
+func (o *HookObserver) OnChapterEnd(info Metadata) {
+	path, ok := info["path"].(string)
+	if !ok {
+		// nothing was saved
+		return
+	}
+
+	if o.Hooks.Chapter != "" {
+		runHook(o.Hooks.Chapter, "chapter", info)
+	}
+	if o.Hooks.Series != "" {
+		series := Metadata{}
+		series.Update(info)
+		// the series is where its chapters are
+		series["path"] = filepath.Dir(path)
+		delete(series, "chapter")
+		delete(series, "chapterName")
+
+		o.mu.Lock()
+		o.series[fmt.Sprint(info["manga"])] = series
+		o.mu.Unlock()
+	}
+}
+
+// Flush runs the series hook for every series that got new chapters since
+// the last time.
+func (o *HookObserver) Flush() {
+	o.mu.Lock()
+	series := o.series
+	o.series = map[string]Metadata{}
+	o.mu.Unlock()
+
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		runHook(o.Hooks.Series, "series", series[name])
+	}
+}
+
+// FilterHook is a Rule that leaves it to an external command whether to
+// download a chapter.  The command gets the chapter's metadata as JSON on
+// its standard input; exiting with anything but 0 skips the chapter.  Each
+// chapter is only asked about once.
+type FilterHook struct {
+	Command string
+
+	mu        sync.Mutex
+	decisions map[string]bool
+}
+
+func NewFilterHook(command string) *FilterHook {
+	return &FilterHook{Command: command, decisions: map[string]bool{}}
+}
+
+func (h *FilterHook) Block(r Resource) bool {
+	key := r.url.String()
+
+	h.mu.Lock()
+	block, ok := h.decisions[key]
+	h.mu.Unlock()
+	if ok {
+		return block
+	}
+
+	block = h.ask(r.info)
+	h.mu.Lock()
+	h.decisions[key] = block
+	h.mu.Unlock()
+	return block
+}
+
+func (h *FilterHook) ask(info Metadata) bool {
+	data, err := json.Marshal(info)
+	if err != nil {
+		fatalln("filter hook:", err)
+	}
+
+	cmd := shellCommand(h.Command)
+	cmd.Env = append(os.Environ(), hookEnv("filter", info)...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		log.Printf("%v %v: skipped by the filter hook", info["manga"], info["chapter"])
+		return true
+	} else if err != nil {
+		fatalln("filter hook:", err)
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+)
+
+// Magic numbers of the image formats we're likely to come across.
+var imageSignatures = []struct {
+	offset    int
+	magic     []byte
+	extension string
+}{
+	{0, []byte("\xFF\xD8\xFF"), "jpg"},
+	{0, []byte("\x89PNG\r\n\x1A\n"), "png"},
+	{0, []byte("GIF87a"), "gif"},
+	{0, []byte("GIF89a"), "gif"},
+	{8, []byte("WEBP"), "webp"},
+	{4, []byte("ftypavif"), "avif"},
+	{4, []byte("ftypavis"), "avif"},
+	{0, []byte("\xFF\x0A"), "jxl"},
+	{0, []byte("\x00\x00\x00\x0CJXL \r\n\x87\n"), "jxl"},
+	{0, []byte("BM"), "bmp"},
+}
+
+var imageMIMETypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+	"image/avif": "avif",
+	"image/jxl":  "jxl",
+	"image/bmp":  "bmp",
+}
+
+// sniffImageExtension guesses the file extension of an image, first from its
+// leading bytes and then from the Content-Type the server sent.  It returns
+// the empty string if neither gives a definite answer.
+func sniffImageExtension(header []byte, contentType string) string {
+	for _, sig := range imageSignatures {
+		if len(header) >= sig.offset+len(sig.magic) &&
+			bytes.Equal(header[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.extension
+		}
+	}
+
+	// Servers love to lie about this, so it only comes second
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return imageMIMETypes[strings.ToLower(mediaType)]
+	}
+	return ""
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func init() {
+	commands["import"] = importCommand
+}
+
+var importers = map[string]func(args []string) []TrackedSeries{
+	"tachiyomi": importTachiyomi,
+}
+
+// importCommand adds the series found in some other program's library to the
+// tracked series list.
+func importCommand(args []string) {
+	if len(args) < 1 || importers[args[0]] == nil {
+		fmt.Fprintln(os.Stderr, "usage: mango import tachiyomi BACKUP")
+		fmt.Fprintln(os.Stderr, "       mango import csv [flags] FILE")
+		fmt.Fprintln(os.Stderr, "       mango import mal [flags] EXPORT")
+		os.Exit(2)
+	}
+
+	imported := importers[args[0]](args[1:])
+
+	path := trackedPath()
+	tracked, err := loadTracked(path)
+	if err != nil {
+		fatalln("cannot load tracked series:", err)
+	}
+
+	n := 0
+	for _, s := range imported {
+		var added bool
+		if tracked, added = addTracked(tracked, s); added {
+			n++
+		}
+	}
+	if err := saveTracked(path, tracked); err != nil {
+		fatalln("cannot save tracked series:", err)
+	}
+	fmt.Printf("tracking %d new series (%d in total)\n", n, len(tracked))
+}
+
+// Where the Tachiyomi sources we can handle live, by (lowercased, spaceless)
+// source name.  Tachiyomi only keeps the path part of the URL.
+var tachiyomiSources = map[string]string{
+	"mangareader": "https://www.mangareader.net",
+	"mangaeden":   "https://www.mangaeden.com",
+	"mangastream": "https://readms.net",
+	"mangadex":    "https://mangadex.org",
+}
+
+type tachiyomiManga struct {
+	source int64
+	url    string
+	title  string
+}
+
+// importTachiyomi reads a Tachiyomi (or Mihon) backup; they're gzipped
+// protobufs.
+func importTachiyomi(args []string) []TrackedSeries {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: mango import tachiyomi BACKUP")
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		fatal(err)
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			fatalln("cannot read backup:", err)
+		}
+		if data, err = io.ReadAll(gz); err != nil {
+			fatalln("cannot read backup:", err)
+		}
+	}
+
+	manga, sources, err := parseTachiyomiBackup(data)
+	if err != nil {
+		fatalln("cannot read backup:", err)
+	}
+
+	var tracked []TrackedSeries
+	for _, m := range manga {
+		name := sources[m.source]
+		base, ok := tachiyomiSources[strings.ToLower(strings.Replace(name, " ", "", -1))]
+		if !ok {
+			log.Printf("skipping %q: unsupported source %q", m.title, name)
+			continue
+		}
+		path := m.url
+		// Tachiyomi keeps MangaDex series as /manga/ID, the site has /title/ID
+		if strings.HasPrefix(base, "https://mangadex.org") {
+			path = "/title/" + strings.TrimPrefix(path, "/manga/")
+		}
+		tracked = append(tracked, TrackedSeries{URL: base + path, Title: m.title})
+	}
+	return tracked
+}
+
+func parseTachiyomiBackup(b []byte) (manga []tachiyomiManga, sources map[int64]string, err error) {
+	sources = map[int64]string{}
+	err = consumeMessage(b, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			// BackupManga
+			var m tachiyomiManga
+			err := consumeMessage(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+				switch {
+				case num == 1 && typ == protowire.VarintType:
+					v, _ := protowire.ConsumeVarint(field)
+					m.source = int64(v)
+				case num == 2 && typ == protowire.BytesType:
+					m.url = string(field)
+				case num == 3 && typ == protowire.BytesType:
+					m.title = string(field)
+				}
+				return nil
+			})
+			manga = append(manga, m)
+			return err
+
+		case num == 101 && typ == protowire.BytesType:
+			// BackupSource
+			var name string
+			var id int64
+			err := consumeMessage(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					name = string(field)
+				case num == 2 && typ == protowire.VarintType:
+					v, _ := protowire.ConsumeVarint(field)
+					id = int64(v)
+				}
+				return nil
+			})
+			sources[id] = name
+			return err
+		}
+		return nil
+	})
+	return
+}
+
+// consumeMessage calls fn with the value of every field of a protobuf message;
+// the contents for length-delimited fields, the still encoded value otherwise.
+func consumeMessage(b []byte, fn func(protowire.Number, protowire.Type, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		value := b[:n]
+		if typ == protowire.BytesType {
+			value, _ = protowire.ConsumeBytes(value)
+		}
+		if err := fn(num, typ, value); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
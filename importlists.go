@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func init() {
+	importers["csv"] = importCSV
+	importers["mal"] = importMAL
+}
+
+// importCSV reads a list of series, one per row.  The titles are in the
+// column headed "title", or else the first one; rows that have a URL in them
+// are taken as they are.
+func importCSV(args []string) []TrackedSeries {
+	fs := flag.NewFlagSet("import csv", flag.ExitOnError)
+	interactive := fs.Bool("interactive", stdinIsTerminal(), "ask which series is meant when a title is ambiguous")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango import csv [flags] FILE")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		fatalln("cannot read list:", err)
+	}
+
+	column := 0
+	if len(rows) > 0 {
+		for i, cell := range rows[0] {
+			if strings.EqualFold(strings.TrimSpace(cell), "title") {
+				column = i
+				rows = rows[1:]
+				break
+			}
+		}
+	}
+
+	var tracked []TrackedSeries
+	var entries []listEntry
+	for _, row := range rows {
+		var u, title string
+		for i, cell := range row {
+			cell = strings.TrimSpace(cell)
+			if strings.HasPrefix(cell, "http://") || strings.HasPrefix(cell, "https://") {
+				u = cell
+			} else if i == column {
+				title = cell
+			}
+		}
+		switch {
+		case u != "":
+			tracked = append(tracked, TrackedSeries{URL: u, Title: title})
+		case title != "":
+			entries = append(entries, listEntry{[]string{title}})
+		}
+	}
+	return append(tracked, resolveEntries(listFetcher(), entries, *interactive)...)
+}
+
+// importMAL reads a MyAnimeList manga list export, gzipped or not.
+func importMAL(args []string) []TrackedSeries {
+	fs := flag.NewFlagSet("import mal", flag.ExitOnError)
+	interactive := fs.Bool("interactive", stdinIsTerminal(), "ask which series is meant when a title is ambiguous")
+	all := fs.Bool("all", false, "import every series, not only the ones being or planned to be read")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango import mal [flags] EXPORT")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			fatalln("cannot read export:", err)
+		}
+		if data, err = io.ReadAll(gz); err != nil {
+			fatalln("cannot read export:", err)
+		}
+	}
+
+	var export struct {
+		Manga []struct {
+			Title  string `xml:"manga_title"`
+			Status string `xml:"my_status"`
+		} `xml:"manga"`
+	}
+	if err := xml.Unmarshal(data, &export); err != nil {
+		fatalln("cannot read export:", err)
+	}
+
+	var entries []listEntry
+	for _, m := range export.Manga {
+		if !*all && m.Status != "Reading" && m.Status != "Plan to Read" {
+			continue
+		}
+		entries = append(entries, listEntry{[]string{m.Title}})
+	}
+	return resolveEntries(listFetcher(), entries, *interactive)
+}
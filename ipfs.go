@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IPFSObserver adds every finished archive to an IPFS node through its HTTP
+// API (the one "ipfs daemon" serves on port 5001) and leaves the CID it gets
+// in info["cid"], for the manifest; so it has to come after the Saver and
+// before the ManifestObserver.  Chapters that aren't archives are left out.
+type IPFSObserver struct {
+	API    string // e.g. http://127.0.0.1:5001
+	client *http.Client
+}
+
+func NewIPFSObserver(api string) *IPFSObserver {
+	return &IPFSObserver{API: strings.TrimSuffix(api, "/"), client: &http.Client{}}
+}
+
+func (o *IPFSObserver) OnPageEnd(info Metadata) {}
+
+func (o *IPFSObserver) OnChapterEnd(info Metadata) {
+	path, _ := info["path"].(string)
+	if path == "" || !isFile(path) {
+		return
+	}
+	cid, err := o.Add(path)
+	if err != nil {
+		log.Printf("%s: cannot add to IPFS: %v", path, err)
+		return
+	}
+	info["cid"] = cid
+}
+
+// Add adds, and pins, the file at path and returns its CID.
+func (o *IPFSObserver) Add(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// the archive is streamed rather than read into memory first
+	body, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		part, err := form.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	query := url.Values{"pin": {"true"}, "cid-version": {"1"}}
+	resp, err := o.client.Post(o.API+"/api/v0/add?"+query.Encode(), form.FormDataContentType(), body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var added struct {
+		Hash string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&added); err != nil {
+		return "", err
+	}
+	if added.Hash == "" {
+		return "", fmt.Errorf("no CID in the response")
+	}
+	return added.Hash, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// The name of the JSON manifest in each series directory.
+const jsonManifestName = ".mango.json"
+
+// JSONManifest is a Manifest kept as a small JSON file in each series'
+// directory, for those who'd rather not have a database around.
+type JSONManifest struct {
+	// Root is the directory the series directories are in.
+	Root string
+
+	mu sync.Mutex
+}
+
+type jsonManifestFile struct {
+	Series   string          `json:"series"`
+	Chapters []ManifestEntry `json:"chapters"`
+}
+
+func readJSONManifest(path string) (jsonManifestFile, error) {
+	var f jsonManifestFile
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return f, err
+	}
+	err = json.Unmarshal(data, &f)
+	return f, err
+}
+
+func writeJSONManifest(path string, f jsonManifestFile) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write it aside first so that a crash doesn't leave half a manifest
+	tmpname := path + ".part"
+	if err := os.WriteFile(tmpname, data, 0660); err != nil {
+		return err
+	}
+	return os.Rename(tmpname, path)
+}
+
+func (m *JSONManifest) Add(e ManifestEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	path := filepath.Join(filepath.Dir(e.Path), jsonManifestName)
+	f, err := readJSONManifest(path)
+	if err != nil {
+		return err
+	}
+
+	f.Series = e.Series
+	replaced := false
+	for i := range f.Chapters {
+		if f.Chapters[i].Path == e.Path {
+			f.Chapters[i] = e
+			replaced = true
+		}
+	}
+	if !replaced {
+		f.Chapters = append(f.Chapters, e)
+	}
+	sort.SliceStable(f.Chapters, func(i, j int) bool {
+		return chapterLess(f.Chapters[i].Chapter, f.Chapters[j].Chapter)
+	})
+	return writeJSONManifest(path, f)
+}
+
+func (m *JSONManifest) Entries(series string) ([]ManifestEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(m.Root, "*", jsonManifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ManifestEntry
+	for _, path := range paths {
+		f, err := readJSONManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		if series == "" || f.Series == series {
+			entries = append(entries, f.Chapters...)
+		}
+	}
+	return entries, nil
+}
+
+func (m *JSONManifest) Remove(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	manifestPath := filepath.Join(filepath.Dir(path), jsonManifestName)
+	f, err := readJSONManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	chapters := f.Chapters[:0]
+	for _, e := range f.Chapters {
+		if e.Path != path {
+			chapters = append(chapters, e)
+		}
+	}
+	f.Chapters = chapters
+
+	if len(f.Chapters) == 0 {
+		err := os.Remove(manifestPath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return writeJSONManifest(manifestPath, f)
+}
+
+func (m *JSONManifest) Close() error {
+	return nil
+}
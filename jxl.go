@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// There's no JPEG XL encoder, or decoder, in Go, so pages are handed to cjxl
+// and djxl, from libjxl.
+
+// haveCJXL tells whether cjxl is there to be run.
+func haveCJXL() bool {
+	_, err := exec.LookPath("cjxl")
+	return err == nil
+}
+
+// toJPEGXL transcodes a page to JPEG XL, losslessly: JPEGs keep their exact
+// pixels (and can be turned back into the same file), and the rest lose
+// nothing either.  If that can't be done the page is converted to fallback
+// instead, or, without one, left as it is.
+func (p Pipeline) toJPEGXL(info Metadata, data []byte) ([]byte, error) {
+	ext, _ := info["imageExtension"].(string)
+	if ext == "jxl" {
+		return data, nil
+	}
+	jxl, err := cjxl(ext, data)
+	if err == nil {
+		info["imageExtension"] = "jxl"
+		return jxl, nil
+	}
+	if p.JPEGXLFallback == "" || p.JPEGXLFallback == ext {
+		return data, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode %s image: %v", ext, err)
+	}
+	var buf bytes.Buffer
+	if err := p.encode(&buf, img, p.JPEGXLFallback); err != nil {
+		return nil, fmt.Errorf("cannot encode %s image: %v", p.JPEGXLFallback, err)
+	}
+	info["imageExtension"] = p.JPEGXLFallback
+	return buf.Bytes(), nil
+}
+
+// cjxl runs cjxl over an image of type ext.  What it can't read is made a PNG
+// first.
+func cjxl(ext string, data []byte) ([]byte, error) {
+	if !haveCJXL() {
+		return nil, fmt.Errorf("cjxl not found")
+	}
+	if ext != "jpg" && ext != "png" && ext != "gif" {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := (Pipeline{}).encode(&buf, img, "png"); err != nil {
+			return nil, err
+		}
+		ext, data = "png", buf.Bytes()
+	}
+
+	dir, err := os.MkdirTemp("", "mango-jxl")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "page."+ext), filepath.Join(dir, "page.jxl")
+	if err := os.WriteFile(in, data, 0644); err != nil {
+		return nil, err
+	}
+	args := []string{in, out, "--quiet"}
+	if ext != "jpg" {
+		// JPEGs are transcoded losslessly anyway
+		args = append(args, "--distance=0")
+	}
+	if output, err := exec.Command("cjxl", args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("cjxl: %v: %s", err, bytes.TrimSpace(output))
+	}
+	return os.ReadFile(out)
+}
+
+// A JPEG XL file is either a bare codestream or an ISOBMFF container.
+var (
+	jxlCodestream = []byte{0xff, 0x0a}
+	jxlContainer  = []byte{0, 0, 0, 0x0c, 'J', 'X', 'L', ' ', 0x0d, 0x0a, 0x87, 0x0a}
+)
+
+// isJPEGXL tells whether r starts like a JPEG XL file, which is as much as
+// can be checked without a decoder.
+func isJPEGXL(r io.Reader) bool {
+	header := make([]byte, len(jxlContainer))
+	n, _ := io.ReadFull(r, header)
+	header = header[:n]
+	return bytes.HasPrefix(header, jxlCodestream) || bytes.Equal(header, jxlContainer)
+}
+
+// decodeJPEGXLPages turns the JPEG XL pages in dir back into something the
+// formats other than CBZ can hold: the original JPEG for those that were
+// transcoded from one, a PNG otherwise.
+func decodeJPEGXLPages(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.EqualFold(filepath.Ext(f.Name()), ".jxl") {
+			continue
+		}
+		if _, err := exec.LookPath("djxl"); err != nil {
+			return fmt.Errorf("%s: djxl not found, cannot decode JPEG XL", f.Name())
+		}
+
+		in := filepath.Join(dir, f.Name())
+		data, err := os.ReadFile(in)
+		if err != nil {
+			return err
+		}
+		// the JPEG, if there was one, is kept in a jbrd box
+		ext := ".png"
+		if bytes.HasPrefix(data, jxlContainer) && bytes.Contains(data, []byte("jbrd")) {
+			ext = ".jpg"
+		}
+		out := strings.TrimSuffix(in, filepath.Ext(in)) + ext
+		if output, err := exec.Command("djxl", in, out, "--quiet").CombinedOutput(); err != nil {
+			return fmt.Errorf("djxl: %v: %s", err, bytes.TrimSpace(output))
+		}
+		if err := os.Remove(in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
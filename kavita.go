@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Kavita talks to a Kavita server's REST API.  It logs in with an API key,
+// the way Kavita plugins do.
+type Kavita struct {
+	MediaServerConfig
+
+	mu    sync.Mutex
+	token string
+}
+
+func (k *Kavita) Name() string { return "kavita" }
+
+func (k *Kavita) do(method, path string, body interface{}, v interface{}) error {
+	k.mu.Lock()
+	token := k.token
+	k.mu.Unlock()
+	return k.request(method, path, body, v, func(req *http.Request) {
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	})
+}
+
+func (k *Kavita) login() error {
+	k.mu.Lock()
+	loggedIn := k.token != ""
+	k.mu.Unlock()
+	if loggedIn {
+		return nil
+	}
+
+	var user struct {
+		Token string `json:"token"`
+	}
+	query := url.Values{"apiKey": {k.APIKey}, "pluginName": {"mango"}}
+	if err := k.do("POST", "/api/Plugin/authenticate?"+query.Encode(), nil, &user); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.token = user.Token
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *Kavita) Scan(dirs []string) error {
+	if len(dirs) == 0 || k.Library != "" {
+		if err := k.login(); err != nil {
+			return err
+		}
+		return k.do("POST", "/api/Library/scan?libraryId="+url.QueryEscape(k.Library), nil, nil)
+	}
+
+	// Without a library, scan just the series that changed
+	for _, dir := range dirs {
+		err := k.do("POST", "/api/Library/scan-folder", map[string]string{
+			"apiKey":     k.APIKey,
+			"folderPath": k.remotePath(dir),
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *Kavita) UpdateSeries(info Metadata) error {
+	if !k.Metadata {
+		return nil
+	}
+	if err := k.login(); err != nil {
+		return err
+	}
+	name, _ := info["manga"].(string)
+
+	var found struct {
+		Series []struct {
+			SeriesID int    `json:"seriesId"`
+			Name     string `json:"name"`
+		} `json:"series"`
+	}
+	if err := k.do("GET", "/api/Search/search?queryString="+url.QueryEscape(name), nil, &found); err != nil {
+		return err
+	}
+	id := 0
+	for _, s := range found.Series {
+		if s.Name == name {
+			id = s.SeriesID
+		}
+	}
+	if id == 0 {
+		return errNotScanned
+	}
+
+	// The update replaces the whole thing, so start from what's there
+	var metadata map[string]interface{}
+	if err := k.do("GET", fmt.Sprintf("/api/Series/metadata?seriesId=%d", id), nil, &metadata); err != nil {
+		return err
+	}
+	if description, ok := info["description"].(string); ok && description != "" {
+		metadata["summary"] = description
+	}
+	if genres, ok := info["genres"].([]string); ok && len(genres) > 0 {
+		var tags []map[string]interface{}
+		for _, g := range genres {
+			tags = append(tags, map[string]interface{}{"id": 0, "title": g})
+		}
+		metadata["genres"] = tags
+	}
+	switch status, _ := info["status"].(string); strings.ToLower(status) {
+	case "ongoing":
+		metadata["publicationStatus"] = 0
+	case "completed":
+		metadata["publicationStatus"] = 2
+	}
+	return k.do("POST", "/api/Series/metadata", map[string]interface{}{"seriesMetadata": metadata}, nil)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Komga talks to a Komga server's REST API.
+type Komga struct {
+	MediaServerConfig
+}
+
+func (k Komga) Name() string { return "komga" }
+
+func (k Komga) do(method, path string, body interface{}, v interface{}) error {
+	return k.request(method, path, body, v, func(req *http.Request) {
+		if k.APIKey != "" {
+			req.Header.Set("X-API-Key", k.APIKey)
+		} else {
+			req.SetBasicAuth(k.Username, k.Password)
+		}
+	})
+}
+
+func (k Komga) Scan(dirs []string) error {
+	// Komga can only scan whole libraries
+	return k.do("POST", "/api/v1/libraries/"+url.PathEscape(k.Library)+"/scan", nil, nil)
+}
+
+func (k Komga) UpdateSeries(info Metadata) error {
+	if !k.Metadata {
+		return nil
+	}
+	name, _ := info["manga"].(string)
+
+	var found struct {
+		Content []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"content"`
+	}
+	query := url.Values{"search": {name}, "library_id": {k.Library}}
+	if err := k.do("GET", "/api/v1/series?"+query.Encode(), nil, &found); err != nil {
+		return err
+	}
+	id := ""
+	for _, s := range found.Content {
+		if s.Name == name {
+			id = s.ID
+		}
+	}
+	if id == "" {
+		return errNotScanned
+	}
+
+	metadata := map[string]interface{}{}
+	if description, ok := info["description"].(string); ok && description != "" {
+		metadata["summary"] = description
+	}
+	if genres, ok := info["genres"].([]string); ok && len(genres) > 0 {
+		metadata["genres"] = genres
+	}
+	switch info["readingDirection"] {
+	case "rtl":
+		metadata["readingDirection"] = "RIGHT_TO_LEFT"
+	case "ltr":
+		metadata["readingDirection"] = "LEFT_TO_RIGHT"
+	}
+	switch status, _ := info["status"].(string); strings.ToLower(status) {
+	case "ongoing":
+		metadata["status"] = "ONGOING"
+	case "completed":
+		metadata["status"] = "ENDED"
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	return k.do("PATCH", "/api/v1/series/"+url.PathEscape(id)+"/metadata", metadata, nil)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LibrarySeries is a series directory in the output directory.
+type LibrarySeries struct {
+	Name     string
+	Chapters []LibraryChapter
+}
+
+// LibraryChapter is an archive in a series directory.
+type LibraryChapter struct {
+	Name    string
+	Path    string // relative to the library, slash-separated
+	Size    int64
+	ModTime time.Time
+}
+
+// scanLibrary lists the series in root that have at least one archive.
+func scanLibrary(root string) ([]LibrarySeries, error) {
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var library []LibrarySeries
+	for _, dir := range dirs {
+		if !dir.IsDir() || strings.HasSuffix(dir.Name(), ".part") {
+			continue
+		}
+		chapters, err := scanSeries(root, dir.Name())
+		if err != nil {
+			return nil, err
+		}
+		if len(chapters) > 0 {
+			library = append(library, LibrarySeries{dir.Name(), chapters})
+		}
+	}
+	return library, nil
+}
+
+func scanSeries(root, series string) ([]LibraryChapter, error) {
+	files, err := os.ReadDir(filepath.Join(root, series))
+	if err != nil {
+		return nil, err
+	}
+
+	var chapters []LibraryChapter
+	for _, f := range files {
+		if f.IsDir() || !strings.EqualFold(path.Ext(f.Name()), ".cbz") {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, LibraryChapter{
+			Name:    strings.TrimSuffix(f.Name(), path.Ext(f.Name())),
+			Path:    path.Join(series, f.Name()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+	sort.Slice(chapters, func(i, j int) bool {
+		return chapters[i].Name < chapters[j].Name
+	})
+	return chapters, nil
+}
+
+// libraryPath turns a slash-separated path from a URL into a file path in
+// root, making sure it can't escape it.
+func libraryPath(root, p string) string {
+	return filepath.Join(root, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+// archivePages returns the page entries of an archive, in reading order.
+func archivePages(archive *zip.Reader) []*zip.File {
+	var pages []*zip.File
+	for _, f := range archive.File {
+		if isPageEntry(f.Name) {
+			pages = append(pages, f)
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return naturalLess(pages[i].Name, pages[j].Name)
+	})
+	return pages
+}
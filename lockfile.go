@@ -0,0 +1,35 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on the file at path, waiting for whoever
+// has it.  The lock goes away with the process, however it ends.
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0660)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		log.Println("waiting for another mango to be done with", path)
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func unlockFile(file *os.File) {
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	file.Close()
+}
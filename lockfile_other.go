@@ -0,0 +1,35 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// lockFile creates the file at path, waiting for whoever created it to
+// remove it.  A process that dies holding it leaves it behind, and it then
+// has to be removed by hand.
+func lockFile(path string) (*os.File, error) {
+	waiting := false
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0660)
+		if err == nil {
+			return file, nil
+		} else if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if !waiting {
+			log.Println("waiting for another mango to be done with", path)
+			waiting = true
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func unlockFile(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SeriesLocks keeps other mango processes out of the series this one is
+// downloading chapters of, by holding a lock file in the series' directory
+// until they're finished.
+type SeriesLocks struct {
+	naming NameTemplate
+
+	mu   sync.Mutex
+	held map[string]*seriesLock
+}
+
+type seriesLock struct {
+	mu   sync.Mutex
+	refs int
+	file *os.File
+}
+
+func NewSeriesLocks(naming NameTemplate) *SeriesLocks {
+	return &SeriesLocks{naming: naming, held: map[string]*seriesLock{}}
+}
+
+func (l *SeriesLocks) path(info Metadata) string {
+	name, err := l.naming.Name(info)
+	if err != nil {
+		fatalln("cannot name chapter:", err)
+	}
+	return filepath.Join(filepath.Dir(name), ".mango.lock")
+}
+
+// Acquire waits until the chapter's series is locked by this process.
+func (l *SeriesLocks) Acquire(info Metadata) {
+	path := l.path(info)
+
+	l.mu.Lock()
+	lock, ok := l.held[path]
+	if !ok {
+		lock = &seriesLock{}
+		l.held[path] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.file == nil {
+		os.MkdirAll(filepath.Dir(path), os.ModeDir|0770)
+		file, err := lockFile(path)
+		if err != nil {
+			fatalln("cannot lock series:", err)
+		}
+		lock.file = file
+	}
+}
+
+// Release lets go of the chapter's series, once no other chapter of it is
+// still being downloaded.
+func (l *SeriesLocks) Release(info Metadata) {
+	path := l.path(info)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock, ok := l.held[path]
+	if !ok {
+		return
+	}
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.held, path)
+		unlockFile(lock.file)
+	}
+}
+
+func (l *SeriesLocks) OnPageEnd(info Metadata) {}
+
+func (l *SeriesLocks) OnChapterEnd(info Metadata) {
+	l.Release(info)
+}
+
+// OnFailure lets go of a chapter that won't be getting to its end.
+func (l *SeriesLocks) OnFailure(info Metadata, err error) {
+	if _, ok := info["chapter"]; ok {
+		l.Release(info)
+	}
+}
+
+// Rule returns a Rule that locks the series of every chapter inner lets
+// through.  inner is asked again once the lock is held, since another process
+// may have downloaded the chapter in the meantime.
+func (l *SeriesLocks) Rule(inner Rule) Rule {
+	return funcRule(func(r Resource) bool {
+		if inner.Block(r) {
+			return true
+		}
+		l.Acquire(r.info)
+		if inner.Block(r) {
+			l.Release(r.info)
+			return true
+		}
+		return false
+	})
+}
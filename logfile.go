@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// RotatingFile is a log file that's moved out of the way once it gets too big
+// or too old; the old ones are kept as FILE.1 (the newest), FILE.2 and so on,
+// up to Keep of them.
+type RotatingFile struct {
+	Path    string
+	MaxSize int64         // 0 for no limit
+	MaxAge  time.Duration // 0 for no limit
+	Keep    int
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	started time.Time
+}
+
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, keep int) (*RotatingFile, error) {
+	f := &RotatingFile{Path: path, MaxSize: maxSize, MaxAge: maxAge, Keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.started = file, stat.Size(), time.Now()
+	return nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	full := f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize
+	old := f.MaxAge > 0 && time.Since(f.started) > f.MaxAge
+	if full || old {
+		if err := f.rotate(); err != nil {
+			// better to keep logging to the one we have
+			fmt.Fprintln(os.Stderr, "cannot rotate log:", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+	os.Remove(fmt.Sprintf("%s.%d", f.Path, f.Keep))
+	for i := f.Keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.Path, i), fmt.Sprintf("%s.%d", f.Path, i+1))
+	}
+	var err error
+	if f.Keep > 0 {
+		err = os.Rename(f.Path, f.Path+".1")
+	} else {
+		err = os.Remove(f.Path)
+	}
+	// even if it couldn't be moved, there has to be something to write to
+	if err2 := f.open(); err2 != nil {
+		return err2
+	}
+	return err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
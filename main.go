@@ -3,6 +3,8 @@ package main
 import (
 	"archive/zip"
 	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -10,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -60,48 +63,182 @@ type Observer interface {
 	OnPageEnd(Metadata)
 }
 
+// MultiObserver passes every event on to all of its Observers, in order.
+type MultiObserver []Observer
+
+func (o MultiObserver) OnChapterEnd(info Metadata) {
+	for _, x := range o {
+		x.OnChapterEnd(info)
+	}
+}
+
+func (o MultiObserver) OnPageEnd(info Metadata) {
+	for _, x := range o {
+		x.OnPageEnd(info)
+	}
+}
+
+// FailureObserver is an Observer that also hears about the chapters, or whole
+// series, that were given up on.
+type FailureObserver interface {
+	OnFailure(info Metadata, err error)
+}
+
+func (o MultiObserver) OnFailure(info Metadata, err error) {
+	for _, x := range o {
+		if f, ok := x.(FailureObserver); ok {
+			f.OnFailure(info, err)
+		}
+	}
+}
+
+// QueueObserver is an Observer that also hears about chapters as they're
+// taken on, before any of their pages are downloaded, so it can tell how much
+// is left to do.
+type QueueObserver interface {
+	// OnChapterQueued is for a chapter that's going to be downloaded.
+	OnChapterQueued(info Metadata)
+	// OnPagesQueued is for its pages, once it's known how many there are.
+	OnPagesQueued(info Metadata, pages int)
+}
+
+func (o MultiObserver) OnChapterQueued(info Metadata) {
+	for _, x := range o {
+		if q, ok := x.(QueueObserver); ok {
+			q.OnChapterQueued(info)
+		}
+	}
+}
+
+func (o MultiObserver) OnPagesQueued(info Metadata, pages int) {
+	for _, x := range o {
+		if q, ok := x.(QueueObserver); ok {
+			q.OnPagesQueued(info, pages)
+		}
+	}
+}
+
 type domainRule struct {
+	pattern     string
 	domain      glob.Glob
-	semaphore   chan empty
+	conns       *connLimit
+	perSecond   int
 	rateLimiter <-chan time.Time
+	bandwidth   *Throttle // or nil, for no limit but the overall one
+}
+
+// DomainLimit is how hard a site, or a few, may be hit; anything left at zero
+// is as for every other site.
+type DomainLimit struct {
+	Domain      string `json:"domain"` // a glob, e.g. "*.example.com"
+	Connections int    `json:"connections,omitempty"`
+	PerSecond   int    `json:"perSecond,omitempty"`
+	Bandwidth   string `json:"bandwidth,omitempty"` // e.g. "200K"
 }
 
 type Fetcher struct {
 	client      *http.Client
 	domainRules []domainRule
+	metrics     *Metrics
+	throttle    *Throttle
 }
 
 func NewFetcher(maxConnections, perSecond int) Fetcher {
 	f := Fetcher{client: client}
-	f.Limit("*", maxConnections, perSecond)
+	f.Limit("*", maxConnections, perSecond, 0)
 	return f
 }
 
-func (f *Fetcher) Limit(domainGlob string, maxConnections, perSecond int) {
+// Limit sets how many connections there may be to the domains matching
+// domainGlob, how many requests a second and, unless it's 0, how many bytes a
+// second.  Of the limits whose globs match a domain, the last one set applies.
+func (f *Fetcher) Limit(domainGlob string, maxConnections, perSecond int, bandwidth byteSize) {
+	var throttle *Throttle
+	if bandwidth > 0 {
+		throttle = &Throttle{windows: []bandwidthWindow{{allDay: true, limit: float64(bandwidth)}}}
+	}
 	f.domainRules = append(f.domainRules, domainRule{
-		glob.MustCompile(domainGlob),
-		make(chan empty, maxConnections),
-		time.Tick(time.Second / time.Duration(perSecond)),
+		pattern:     domainGlob,
+		domain:      glob.MustCompile(domainGlob),
+		conns:       newConnLimit(maxConnections),
+		perSecond:   perSecond,
+		rateLimiter: time.Tick(time.Second / time.Duration(perSecond)),
+		bandwidth:   throttle,
 	})
 }
 
+// LimitDomain sets the limits for d, the rest as they are for every site.
+func (f *Fetcher) LimitDomain(d DomainLimit) error {
+	if _, err := glob.Compile(d.Domain); err != nil {
+		return fmt.Errorf("invalid domain %q: %v", d.Domain, err)
+	}
+	// the ones for every site come first
+	all := f.domainRules[0]
+	connections, perSecond := d.Connections, d.PerSecond
+	if connections <= 0 {
+		connections = all.conns.max
+	}
+	if perSecond <= 0 {
+		perSecond = all.perSecond
+	}
+	var bandwidth byteSize
+	if d.Bandwidth != "" {
+		if err := bandwidth.Set(d.Bandwidth); err != nil {
+			return err
+		}
+	}
+	f.Limit(d.Domain, connections, perSecond, bandwidth)
+	return nil
+}
+
 func (f Fetcher) Get(u *url.URL) (*http.Response, error) {
-	for _, r := range f.domainRules {
-		if r.domain.Match(u.Hostname()) {
-			r.semaphore <- empty{}
-			defer func() { <-r.semaphore }()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.Do(req)
+}
+
+// Do makes any request the way Get does, within the limits.
+func (f Fetcher) Do(req *http.Request) (*http.Response, error) {
+	u := req.URL
+	var rule *domainRule
+	for i := len(f.domainRules) - 1; i >= 0; i-- {
+		if r := f.domainRules[i]; r.domain.Match(u.Hostname()) {
+			rule = &f.domainRules[i]
+			dequeue := f.metrics.queue()
+			r.conns.acquire()
+			dequeue()
 			<-r.rateLimiter
 			break
 		}
 	}
 
-	log.Println("GET", u)
-	r, err := f.client.Get(u.String())
+	log.Println(req.Method, u.Redacted())
+	done := f.metrics.request()
+	r, err := f.client.Do(req)
+	if rule != nil {
+		// too many requests, or too much for the server
+		healthy := err == nil && r.StatusCode != http.StatusTooManyRequests && r.StatusCode < 500
+		if limit, changed := rule.conns.release(healthy); changed && !healthy {
+			log.Printf("%s: server struggling, down to %d connections", rule.pattern, limit)
+		}
+	}
 	if err == nil && r.StatusCode != 200 {
 		// XXX: find a nicer way to do error codes
-		return nil, fmt.Errorf("GET %s: %d", u.String(), r.StatusCode)
+		r.Body.Close()
+		err = fmt.Errorf("%s %s: %d", req.Method, u.Redacted(), r.StatusCode)
+	}
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	body := f.metrics.countBody(r.Body)
+	if rule != nil {
+		body = rule.bandwidth.Wrap(body)
 	}
-	return r, err
+	r.Body = f.throttle.Wrap(body)
+	return r, nil
 }
 
 func (f Fetcher) GetHTML(u *url.URL) (*goquery.Document, error) {
@@ -115,13 +252,19 @@ func (f Fetcher) GetHTML(u *url.URL) (*goquery.Document, error) {
 }
 
 type PageSaver struct {
-	progressBar *ProgressBar
+	progress ProgressSink
+	naming   NameTemplate
+	dropped  *droppedPages
+	staging  string
+	store    *PageStore
 }
 
 func (s PageSaver) name(info Metadata) (dirname, basename string) {
-	if chapters, ok := info["chapters"].(int); ok {
-		dirname = fmt.Sprintf("%s/%0*d", info["manga"],
-			len(strconv.Itoa(chapters)), info["chapter"])
+	if _, ok := info["chapters"].(int); ok {
+		var err error
+		if dirname, err = s.naming.Name(info); err != nil {
+			fatalln("cannot name chapter:", err)
+		}
 	}
 	if pages, ok := info["pages"].(int); ok {
 		basename = fmt.Sprintf("%0*d.%s",
@@ -132,7 +275,7 @@ func (s PageSaver) name(info Metadata) (dirname, basename string) {
 
 func (s PageSaver) Save(info Metadata, size int64) (io.WriteCloser, error) {
 	dirname, basename := s.name(info)
-	tmpdirname, tmpbasename := dirname+".part", basename+".part"
+	tmpdirname, tmpbasename := stagingName(s.staging, dirname), basename+".part"
 
 	os.MkdirAll(tmpdirname, os.ModeDir|0770)
 
@@ -142,23 +285,30 @@ func (s PageSaver) Save(info Metadata, size int64) (io.WriteCloser, error) {
 		return nil, err
 	}
 
-	task := s.progressBar.NewTask()
+	task := s.progress.NewTask()
 	return &ProgressWriter{
 		Writer: file,
 		Size:   size,
 		Callback: func(sofar, total int64) {
-			s.progressBar.TickTask(task, sofar, total)
+			s.progress.Tick(task, sofar, total)
+		},
+		Done: func() {
+			s.progress.Done(task)
 		},
 	}, nil
 }
 
 func (s PageSaver) OnPageEnd(info Metadata) {
 	dirname, basename := s.name(info)
-	tmpdirname, tmpbasename := dirname+".part", basename+".part"
+	if info["blacklisted"] == true || info["failed"] == true {
+		s.dropped.Add(dirname)
+	}
+	tmpdirname, tmpbasename := stagingName(s.staging, dirname), basename+".part"
 
 	tmpname := filepath.Join(tmpdirname, tmpbasename)
 	if isFile(tmpname) {
 		os.Rename(tmpname, filepath.Join(tmpdirname, basename))
+		s.store.dedupe(filepath.Join(tmpdirname, basename))
 	} else {
 		// shouldn't happen
 	}
@@ -166,10 +316,17 @@ func (s PageSaver) OnPageEnd(info Metadata) {
 
 func (s PageSaver) OnChapterEnd(info Metadata) {
 	dirname, _ := s.name(info)
-	tmpdirname := dirname + ".part"
+	tmpdirname := stagingName(s.staging, dirname)
 
+	if !checkPageCount(info, tmpdirname, s.dropped.Take(dirname)) {
+		return
+	}
 	if isDir(tmpdirname) {
-		os.Rename(tmpdirname, dirname)
+		os.MkdirAll(filepath.Dir(dirname), os.ModeDir|0770)
+		if err := moveDir(tmpdirname, dirname); err != nil {
+			fatal(err)
+		}
+		info["path"] = dirname
 	} else {
 		// shouldn't happen
 	}
@@ -180,14 +337,38 @@ func (s PageSaver) Block(r Resource) bool {
 	return isDir(dirname)
 }
 
+func (s PageSaver) HasPage(info Metadata) bool {
+	dirname, _ := s.name(info)
+	return hasCompletedPage(stagingName(s.staging, dirname), info)
+}
+
 type CBZSaver struct {
-	progressBar *ProgressBar
+	progress ProgressSink
+	naming   NameTemplate
+	dropped  *droppedPages
+	staging  string
+	store    *PageStore
+	// what to make of the pages, a CBZ unless said otherwise
+	format *archiveFormat
+	// whether to go through chapters that were downloaded already, for
+	// pages that changed
+	refresh bool
+}
+
+func (s CBZSaver) archiveFormat() archiveFormat {
+	if s.format == nil {
+		return archiveFormats["cbz"]
+	}
+	return *s.format
 }
 
 func (s CBZSaver) name(info Metadata) (archivename, imagename string) {
-	if chapters, ok := info["chapters"].(int); ok {
-		archivename = fmt.Sprintf("%s/%0*d.cbz",
-			info["manga"], len(strconv.Itoa(chapters)), info["chapter"])
+	if _, ok := info["chapters"].(int); ok {
+		name, err := s.naming.Name(info)
+		if err != nil {
+			fatalln("cannot name chapter:", err)
+		}
+		archivename = name + s.archiveFormat().ext
 	}
 	if pages, ok := info["pages"].(int); ok {
 		imagename = fmt.Sprintf("%0*d.%s",
@@ -197,30 +378,34 @@ func (s CBZSaver) name(info Metadata) (archivename, imagename string) {
 }
 
 func (s CBZSaver) addMetadataFiles(info Metadata, tmparchivename string) {
+	sources, _ := info["pageURLs"].(map[int]string)
+	withPages := Metadata{"pageInfo": comicPages(tmparchivename, sources)}
+	withPages.Update(info)
+
 	comicInfoXML, err := os.Create(filepath.Join(tmparchivename, "ComicInfo.xml"))
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	defer comicInfoXML.Close()
 	enc := xml.NewEncoder(comicInfoXML)
-	if err := enc.Encode(comicInfo(info)); err != nil {
-		log.Fatal(err)
+	if err := enc.Encode(comicInfo(withPages)); err != nil {
+		fatal(err)
 	}
 
 	coMetXML, err := os.Create(filepath.Join(tmparchivename, "CoMet.xml"))
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	defer coMetXML.Close()
 	enc = xml.NewEncoder(coMetXML)
 	if err := enc.Encode(coMet(info)); err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 }
 
 func (s CBZSaver) Save(info Metadata, size int64) (io.WriteCloser, error) {
 	archivename, imagename := s.name(info)
-	tmparchivename, tmpimagename := archivename+".part", imagename+".part"
+	tmparchivename, tmpimagename := stagingName(s.staging, archivename), imagename+".part"
 
 	os.MkdirAll(tmparchivename, os.ModeDir|0770)
 
@@ -230,23 +415,30 @@ func (s CBZSaver) Save(info Metadata, size int64) (io.WriteCloser, error) {
 		return nil, err
 	}
 
-	task := s.progressBar.NewTask()
+	task := s.progress.NewTask()
 	return &ProgressWriter{
 		Writer: file,
 		Size:   size,
 		Callback: func(sofar, total int64) {
-			s.progressBar.TickTask(task, sofar, total)
+			s.progress.Tick(task, sofar, total)
+		},
+		Done: func() {
+			s.progress.Done(task)
 		},
 	}, nil
 }
 
 func (s CBZSaver) OnPageEnd(info Metadata) {
 	archivename, imagename := s.name(info)
-	tmparchivename, tmpimagename := archivename+".part", imagename+".part"
+	if info["blacklisted"] == true || info["failed"] == true {
+		s.dropped.Add(archivename)
+	}
+	tmparchivename, tmpimagename := stagingName(s.staging, archivename), imagename+".part"
 
 	tmpname := filepath.Join(tmparchivename, tmpimagename)
 	if isFile(tmpname) {
 		os.Rename(tmpname, filepath.Join(tmparchivename, imagename))
+		s.store.dedupe(filepath.Join(tmparchivename, imagename))
 	} else {
 		// shouldn't happen
 	}
@@ -254,24 +446,56 @@ func (s CBZSaver) OnPageEnd(info Metadata) {
 
 func (s CBZSaver) OnChapterEnd(info Metadata) {
 	archivename, _ := s.name(info)
-	tmparchivename := archivename + ".part"
+	tmparchivename := stagingName(s.staging, archivename)
 
-	s.addMetadataFiles(info, tmparchivename)
+	if !checkPageCount(info, tmparchivename, s.dropped.Take(archivename)) {
+		return
+	}
+	if s.refresh && unchangedChapter(info, archivename) {
+		os.RemoveAll(tmparchivename)
+		return
+	}
 
-	zipfile, err := os.Create(archivename)
+	// The archive is built next to the pages and only moved in place once
+	// it's complete, so that no one ever sees half of it.
+	os.MkdirAll(filepath.Dir(archivename), os.ModeDir|0770)
+	tmpzip, err := os.CreateTemp(filepath.Dir(tmparchivename), ".mango-*.tmp")
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	tmpzip.Chmod(0660)
+	tmpzipname := tmpzip.Name()
+	if err := s.archiveFormat().write(tmparchivename, info, tmpzip); err != nil {
+		os.Remove(tmpzipname)
+		fatalln("cannot build archive:", err)
 	}
+	if err := moveFile(tmpzipname, archivename); err != nil {
+		fatal(err)
+	}
+	os.RemoveAll(tmparchivename)
+	info["path"] = archivename
+}
+
+// writeArchive zips up the contents of dir into zipfile.
+func (s CBZSaver) writeArchive(dir string, zipfile *os.File) error {
 	defer zipfile.Close()
 
+	// the pages go in in order, by name
+	if err := padPageNames(dir); err != nil {
+		return err
+	}
 	archive := zip.NewWriter(zipfile)
-	defer archive.Close()
-
-	filepath.Walk(tmparchivename, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		} else if info.IsDir() {
-			// this shouldn't happen but whatever
+			// only the pages, and their HTML, belong to the chapter
+			if path != dir && filepath.Dir(path) == dir && info.Name() != htmlDir {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if strings.HasSuffix(path, ".part") {
+			// left over from an interrupted run
 			return nil
 		}
 
@@ -280,7 +504,7 @@ func (s CBZSaver) OnChapterEnd(info Metadata) {
 			return err
 		}
 
-		header.Name = strings.TrimPrefix(path, tmparchivename+"/")
+		header.Name = strings.TrimPrefix(path, dir+"/")
 		header.Method = zip.Deflate
 
 		writer, err := archive.CreateHeader(header)
@@ -296,50 +520,685 @@ func (s CBZSaver) OnChapterEnd(info Metadata) {
 		_, err = io.Copy(writer, file)
 		return err
 	})
+	if err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return zipfile.Sync()
 }
 
 func (s CBZSaver) Block(r Resource) bool {
 	archivename, _ := s.name(r.info)
-	return isFile(archivename)
+	return isFile(archivename) && !s.refresh
+}
+
+func (s CBZSaver) HasPage(info Metadata) bool {
+	archivename, _ := s.name(info)
+	return hasCompletedPage(stagingName(s.staging, archivename), info)
+}
+
+// hasCompletedPage tells whether a page was already downloaded into the
+// staging directory dir, by an earlier run that didn't get to finish the
+// chapter.  The page's type isn't known until it's downloaded, so any
+// extension will do.
+func hasCompletedPage(dir string, info Metadata) bool {
+	pages, ok := info["pages"].(int)
+	if !ok {
+		return false
+	}
+	prefix := fmt.Sprintf("%0*d.", len(strconv.Itoa(pages)), info["pageIndex"])
+
+	matches, _ := filepath.Glob(filepath.Join(dir, prefix+"*"))
+	for _, m := range matches {
+		if !strings.HasSuffix(m, ".part") {
+			return true
+		}
+	}
+	return false
+}
+
+// stagingName returns where a chapter is put together before being moved to
+// name: next to it, unless there's a staging directory.
+func stagingName(staging, name string) string {
+	if staging == "" {
+		return name + ".part"
+	}
+	return filepath.Join(staging, name+".part")
+}
+
+// droppedPages counts, per chapter, the pages that were left out, either on
+// purpose or because they failed to download.
+type droppedPages struct {
+	mu       sync.Mutex
+	chapters map[string]int
 }
 
-func handler(u *url.URL, fetcher Fetcher, saver Saver, rule Rule, obs Observer) Handler {
+func (d *droppedPages) Add(chapter string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.chapters == nil {
+		d.chapters = map[string]int{}
+	}
+	d.chapters[chapter]++
+}
+
+// Take returns how many pages of chapter were dropped and forgets about it.
+func (d *droppedPages) Take(chapter string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := d.chapters[chapter]
+	delete(d.chapters, chapter)
+	return n
+}
+
+// errIncomplete is what a chapter that was left in staging failed with.
+var errIncomplete = errors.New("not all pages downloaded, left for a later run to finish")
+
+// checkPageCount makes sure that all of a chapter's pages made it to its
+// staging directory, besides the dropped ones.  If they didn't, the chapter
+// is marked incomplete and left where it is, for a later run to finish.
+func checkPageCount(info Metadata, dir string, dropped int) bool {
+	pages, ok := info["pages"].(int)
+	if !ok {
+		return true
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	completed := 0
+	for _, f := range files {
+		if !f.IsDir() && isPageEntry(f.Name()) && !strings.HasSuffix(f.Name(), ".part") {
+			completed++
+		}
+	}
+
+	info["savedPages"] = completed
+	if completed+dropped != pages {
+		log.Printf("%s %v: only %d of %d pages downloaded, leaving it in %s",
+			info["manga"], info["chapter"], completed, pages-dropped, dir)
+		info["incomplete"] = true
+		return false
+	}
+	return true
+}
+
+// handler picks the crawler for the site u is on, or for the engine the site
+// runs, if it has none of its own; it's nil if there's neither.
+func handler(u *url.URL, common CommonSimpleCrawler) Handler {
 	switch {
+	case isSuwayomi(u):
+		return NewSuwayomiCrawler(common)
 	case strings.HasSuffix(u.Hostname(), "mangareader.net"):
-		return NewMangaReaderCrawler(fetcher, saver, rule, obs)
+		return NewMangaReaderCrawler(common)
 	case strings.HasSuffix(u.Hostname(), "mangaeden.com"):
-		return NewMangaEdenCrawler(fetcher, saver, rule, obs)
+		return NewMangaEdenCrawler(common)
 	case strings.HasSuffix(u.Hostname(), "readms.net"):
-		return NewMangaStreamerCrawler(fetcher, saver, rule, obs)
+		return NewMangaStreamerCrawler(common)
+	case u.Hostname() == "mangadex.org" || u.Hostname() == "www.mangadex.org":
+		return NewMangaDexCrawler(common)
+	}
+	e, doc, err := detectEngine(common.client, u)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	if e == nil {
+		log.Println("no scraper for", u)
+		return nil
+	}
+	return NewEngineCrawler(common, e, doc)
+}
+
+// scrapers are all there are, Scrapers and APIScrapers, for what they have to
+// say about their sites.
+var scrapers = []interface{}{MangaReaderScraper{}, MangaEdenScraper{}, MangaStreamerScraper{}, MangaDexScraper{}}
+
+// scraperFor returns the scraper for the site u is on, if there's one.
+func scraperFor(u *url.URL) Scraper {
+	switch {
+	case strings.HasSuffix(u.Hostname(), "mangareader.net"):
+		return MangaReaderScraper{}
+	case strings.HasSuffix(u.Hostname(), "mangaeden.com"):
+		return MangaEdenScraper{}
+	case strings.HasSuffix(u.Hostname(), "readms.net"):
+		return MangaStreamerScraper{}
 	}
 	return nil
 }
 
+// Subcommands, by name.  Anything else on the command line is taken to be a
+// URL to download.
+var commands = map[string]func(args []string){}
+
 func main() {
-	progressBar := NewProgressBar()
-	defer progressBar.Stop()
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
+	// set to fail the whole thing once everything else has been cleaned up
+	exitStatus := 0
+	defer func() {
+		if exitStatus != 0 {
+			os.Exit(exitStatus)
+		}
+	}()
+
+	ascii := flag.Bool("ascii", false, "use ASCII characters for the progress bar")
+	convertTo := flag.String("convert", "", "convert WebP and AVIF pages to `FORMAT` (jpg or png)")
+	quality := flag.Int("jpeg-quality", 0, "re-encode pages as JPEGs of the given `QUALITY` (1-100)")
+	keepLarger := flag.Bool("keep-larger", false, "keep re-encoded pages even when they end up larger")
+	jpegXL := flag.Bool("jxl", false, "transcode pages to JPEG XL, losslessly, with cjxl (from libjxl), for readers that support it (CBZ only)")
+	jpegXLFallback := flag.String("jxl-fallback", "", "convert pages that cannot be transcoded to JPEG XL to `FORMAT` (jpg or png) instead of keeping them as they are")
+	stripEXIF := flag.Bool("strip-exif", false, "remove EXIF metadata from pages, rotating them upright first")
+	autoCrop := flag.Bool("crop", false, "trim uniform white or black borders off pages")
+	manifestFormat, manifestPath := manifestFlags(flag.CommandLine)
+	noAdult := flag.Bool("no-adult", false, "skip series marked as being for adults only")
+	dedupe := flag.Bool("dedupe", true, "skip chapters the manifest has, even if they came from another site")
+	nameTemplate := flag.String("template", defaultNameTemplate, "where to put chapters, as a Go `TEMPLATE`")
+	sanitizeStyle := flag.String("sanitize", "replace", "make names filesystem-safe by replacing unsafe characters with underscores, unicode lookalikes or stripping them")
+	grayscale := flag.Bool("grayscale", false, "convert pages to 8-bit grayscale")
+	deviceName := flag.String("device", "", "prepare pages for the screen of `DEVICE` (e.g. kobo-clara)")
+	refresh := flag.Bool("refresh", false, "go through chapters that were downloaded already and update the pages the site added or replaced since")
+	formatName := flag.String("format", "cbz", "save chapters as `FORMAT` (cbz, epub or pdf)")
+	imageQuality := flag.String("quality", "", "where a site has images in more than one quality, get them in `QUALITY`: best (the default), smallest, or as the site names it (mangadex: original, data-saver)")
+	languages := flag.String("lang", "", "get chapters in these comma-separated `LANGUAGES` (e.g. en,es), where a site has several (mangadex); with more than one, each gets a series of its own, like \"One Piece [es]\"")
+	preferVersions := flag.String("prefer-version", "", "when a chapter comes in several versions, download only the first of these comma-separated `VERSIONS` (colored, official, fan, raw or none for the usual one) there is")
+	profileName := flag.String("profile", "", "set up the device, page processing and naming for reading on `PROFILE` (e.g. kobo-libra) all at once")
+	overrides := Metadata{}
+	flag.Var(overrideFlag(overrides), "set", "override the scraped metadata with `KEY=VALUE` (may be repeated)")
+	record := flag.String("record", "", "save every request and response to `FILE`, in HAR format")
+	replay := flag.String("replay", "", "answer requests from the HAR `FILE` instead of the network")
+	ipfsAPI := flag.String("ipfs", "", "add the archives to the IPFS node whose HTTP API is at `URL` (e.g. http://127.0.0.1:5001), keeping their CIDs in the manifest")
+	keepHTML := flag.Bool("keep-html", false, "keep the HTML of every page in the archives too, with any notes and such in it")
+	cookiesFrom := flag.String("cookies-from", "", "use the cookies `BROWSER` (firefox, chrome or chromium, optionally :PROFILE_DIR) has, for sites that need you logged in")
+	cookiesDomain := flag.String("cookies-domain", "", "the `DOMAINS` (comma-separated) to take the browser's cookies for; by default, those of the series being downloaded")
+	insecureTLS := flag.Bool("insecure", false, "don't check servers' TLS certificates (only for servers you trust, on networks you trust)")
+	warcPath := flag.String("warc", "", "also keep every request and response in the WARC `FILE`, for archival (.gz to compress it)")
+	debugAddr := flag.String("debug-addr", "", "serve pprof and runtime metrics on `ADDRESS` (e.g. localhost:6060)")
+	watchInterval := flag.Duration("watch", 0, "keep running, downloading new chapters every `INTERVAL` (e.g. 6h)")
+	metricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics on `ADDRESS` (e.g. :9090)")
+	eventsPath := flag.String("events", "", "append what happens to `FILE`, as JSON Lines")
+	progressSocket := flag.String("progress-socket", "", "tell programs connected to the Unix socket at `PATH` what happens, as JSON Lines")
+	statusEvery := flag.Duration("status-every", 0, "instead of progress bars, log a line on how the run is going every `INTERVAL` (e.g. 30s), for cron and CI")
+	tuiMode := flag.Bool("tui", false, "take over the terminal to show the downloads, with keys to pause and skip chapters (implies -continue-on-error)")
+	dashboardAddr := flag.String("dashboard-addr", "", "serve a status page on `ADDRESS` (e.g. :8081)")
+	staging := flag.String("staging", "", "put chapters together in `DIR` (e.g. on a fast local disk) and only move the finished ones to the output")
+	pageStore := flag.String("page-store", "", "keep a single copy of identical pages in `DIR`, hardlinked to from chapters")
+	saveReport := flag.Bool("save-report", false, "save a report of every run, in JSON, to the output directory")
+	bell := flag.Bool("bell", false, "ring the terminal bell when a run is over, and show the chapters that failed in red")
+	continueOnError := flag.Bool("continue-on-error", false, "skip chapters that fail instead of stopping, and list them at the end")
+	maxBytes := byteSize(0)
+	flag.Var(&maxBytes, "max-bytes", "don't start any more chapters once a run has downloaded `SIZE`")
+	maxPages := flag.Int("max-pages", 0, "download at most `N` pages per run")
+	maxFailedPages := flag.Int("max-failed-pages", 0, "save chapters with up to `N` pages that failed to download, instead of giving up")
+	archiveWorkers := flag.Int("archive-workers", runtime.NumCPU(), "build up to `N` archives at the same time")
+	minFree := byteSize(lowDiskSpace)
+	flag.Var(&minFree, "min-free", "pause new downloads while less than `SIZE` would be left free (0 to disable)")
+	logFile := flag.String("log-file", "", "also write the log to `FILE`, rotating it as it grows")
+	logMaxSize := byteSize(10 << 20)
+	flag.Var(&logMaxSize, "log-max-size", "rotate the log file once it's bigger than `SIZE` (0 for no limit)")
+	logMaxAge := flag.Duration("log-max-age", 7*24*time.Hour, "rotate the log file once it's older than `AGE` (0 for no limit)")
+	logKeep := flag.Int("log-keep", 5, "keep `N` rotated log files")
+	flag.Parse()
+
+	if *profileName != "" {
+		profile, err := lookupProfile(*profileName)
+		if err != nil {
+			fatal(err)
+		}
+		applyProfile(flag.CommandLine, profile)
+	}
+
+	var logFileOut io.Writer = io.Discard
+	if *logFile != "" {
+		file, err := OpenRotatingFile(*logFile, int64(logMaxSize), *logMaxAge, *logKeep)
+		if err != nil {
+			fatalln("cannot open log file:", err)
+		}
+		defer file.Close()
+		logFileOut = file
+		log.SetOutput(io.MultiWriter(log.Writer(), file))
+	}
+	// where the log goes once the TUI is gone
+	plainLog := log.Writer()
+	if *tuiMode {
+		// it's on the screen instead
+		log.SetOutput(logFileOut)
+		// and so are the failures, which shouldn't end it all
+		*continueOnError = true
+	}
+	exitOnSignal()
+
+	config, err := loadConfig(configPath())
+	if err != nil {
+		fatalln("cannot load config:", err)
+	}
+	if config.Komga != nil || config.Kavita != nil || config.Calibre != nil || config.Suwayomi != nil {
+		store, err := openCredentialStore(config.CredentialStore)
+		if err != nil {
+			fatalln("cannot load config:", err)
+		}
+		fillCredentials(&config, store)
+	}
+	suwayomi = config.Suwayomi
+
+	chars := blockChars
+	if *ascii {
+		chars = asciiChars
+	}
 
+	gradient := defaultGradient
+	if len(config.Gradient) > 0 {
+		if gradient, err = ParseLinearGradient(config.Gradient); err != nil {
+			fatalln("cannot load config:", err)
+		}
+	}
+
+	if *convertTo != "" && *convertTo != "jpg" && *convertTo != "png" {
+		fatalln("cannot convert to", *convertTo)
+	}
+	if *jpegXLFallback != "" && *jpegXLFallback != "jpg" && *jpegXLFallback != "png" {
+		fatalln("cannot fall back to", *jpegXLFallback)
+	}
+	if *jpegXL && *formatName != "cbz" {
+		// nothing reads JPEG XL to measure or embed the pages
+		fatalln("JPEG XL pages can only be saved in CBZs, not", *formatName)
+	}
+	if *jpegXL && !haveCJXL() {
+		log.Println("cjxl not found, pages will not be transcoded to JPEG XL")
+	}
+	if *quality < 0 || *quality > 100 {
+		fatalln("invalid JPEG quality", *quality)
+	}
+
+	var filters []Filter
+	if *grayscale {
+		filters = append(filters, GrayscaleFilter{})
+	}
+	if *autoCrop {
+		filters = append(filters, CropFilter{Tolerance: 16})
+	}
+	if *deviceName != "" {
+		device, err := lookupDevice(*deviceName)
+		if err != nil {
+			fatal(err)
+		}
+		filters = append(filters, device.Filters()...)
+	}
+
+	blacklist, err := NewBlacklist(config.Blacklist, config.BlacklistDistance)
+	if err != nil {
+		fatalln("cannot load config:", err)
+	}
+
+	var progress interface {
+		ProgressSink
+		QueueDisplay
+	}
+	var screen *TUI
+	var statusLine *StatusLine
+	if *tuiMode {
+		screen = NewTUI(chars)
+		progress = screen
+	} else if *statusEvery > 0 {
+		statusLine = NewStatusLine(*statusEvery)
+		defer statusLine.Stop()
+		progress = statusLine
+	} else {
+		progressBar := NewProgressBar(chars, gradient)
+		defer progressBar.Stop()
+		progress = progressBar
+	}
+
+	if config.TLS != nil || *insecureTLS {
+		var tlsConfig TLSConfig
+		if config.TLS != nil {
+			tlsConfig = *config.TLS
+		}
+		transport, err := tlsTransport(tlsConfig, *insecureTLS)
+		if err != nil {
+			fatalln("cannot load config:", err)
+		}
+		client = &http.Client{Transport: transport}
+	}
 	fetcher := NewFetcher(50, 10)
-	saver := CBZSaver{progressBar: progressBar}
-	rule := saver
+	if *replay != "" {
+		replayer, err := LoadReplayer(*replay)
+		if err != nil {
+			fatalln("cannot load recording:", err)
+		}
+		fetcher.client = &http.Client{Transport: replayer}
+	}
+	if fetcher.throttle, err = NewThrottle(config.Bandwidth); err != nil {
+		fatalln("cannot load config:", err)
+	}
+	// the config comes last, to have the final say
+	var limits []DomainLimit
+	for _, s := range scrapers {
+		if l, ok := s.(LimitedScraper); ok {
+			limits = append(limits, l.Limits()...)
+		}
+	}
+	for _, d := range append(limits, config.Domains...) {
+		if err := fetcher.LimitDomain(d); err != nil {
+			fatalln("cannot load config:", err)
+		}
+	}
+	if *debugAddr != "" {
+		serveDebug(*debugAddr, fetcher)
+	}
+	if *metricsAddr != "" {
+		fetcher.metrics = &Metrics{}
+		serveMetrics(*metricsAddr, fetcher.metrics)
+	}
+	if *record != "" {
+		recorder, err := OpenRecorder(*record, fetcher.client.Transport)
+		if err != nil {
+			fatalln("cannot open recording:", err)
+		}
+		defer recorder.Close()
+		fetcher.client = &http.Client{Transport: recorder}
+	}
+	if *warcPath != "" {
+		warc, err := OpenWARCWriter(*warcPath, fetcher.client.Transport)
+		if err != nil {
+			fatalln("cannot open WARC file:", err)
+		}
+		defer warc.Close()
+		fetcher.client = &http.Client{Transport: warc}
+	}
+	if *cookiesFrom != "" {
+		var domains []string
+		if *cookiesDomain != "" {
+			domains = strings.Split(*cookiesDomain, ",")
+		} else {
+			for _, t := range watchTargets(flag.Args()) {
+				if u, err := url.Parse(t.URL); err == nil {
+					domains = append(domains, u.Hostname())
+				}
+			}
+		}
+		jar, err := browserCookieJar(*cookiesFrom, domains)
+		if err != nil {
+			fatalln("cannot get cookies:", err)
+		}
+		// whichever client it ends up with, after recording and such
+		fetcher.client = &http.Client{Transport: fetcher.client.Transport, Jar: jar}
+	}
+	naming, err := ParseNameTemplate(*nameTemplate)
+	if err != nil {
+		fatalln("invalid template:", err)
+	}
+	naming.Sanitizer = Sanitizer{*sanitizeStyle}
+	if err := naming.Sanitizer.Validate(); err != nil {
+		fatal(err)
+	}
+
+	format, err := lookupArchiveFormat(*formatName)
+	if err != nil {
+		fatal(err)
+	}
+	saver := CBZSaver{
+		progress: progress,
+		naming:   naming,
+		dropped:  &droppedPages{},
+		staging:  *staging,
+		format:   &format,
+		refresh:  *refresh,
+	}
+	if *pageStore != "" {
+		saver.store = &PageStore{Dir: *pageStore}
+	}
+	pipeline := Pipeline{
+		Saver:         saver,
+		Filters:       filters,
+		ConvertTo:     *convertTo,
+		Recompress:    *quality > 0,
+		Quality:       *quality,
+		OnlyIfSmaller: !*keepLarger,
+		Blacklist:     blacklist,
+		StripEXIF:     *stripEXIF,
+
+		JPEGXL:         *jpegXL,
+		JPEGXLFallback: *jpegXLFallback,
+	}
+	var pageSaver Saver = pipeline
+	var rule Rule = saver
+
+	// everything that needs the finished archive
+	var obs MultiObserver
+	if *ipfsAPI != "" {
+		// before the manifest, which keeps the CIDs
+		obs = append(obs, NewIPFSObserver(*ipfsAPI))
+	}
+	manifest, err := openManifest(*manifestFormat, *manifestPath, manifestCreate)
+	if err != nil {
+		fatalln("cannot open manifest:", err)
+	}
+	if manifest != nil {
+		defer manifest.Close()
+		obs = append(obs, ManifestObserver{manifest})
+		if *dedupe && !*refresh {
+			rule = AndRule{rule, NewManifestRule(manifest)}
+		}
+	}
 	// rule := AndRule{saver, LastChapterRule{}}
+	if *noAdult {
+		rule = AndRule{rule, AdultRule{}}
+	}
+	if config.Hooks.Filter != "" {
+		rule = AndRule{rule, NewFilterHook(config.Hooks.Filter)}
+	}
+	var gate *ChapterGate
+	if screen != nil {
+		gate = NewChapterGate()
+		rule = AndRule{rule, gate}
+	}
+	// two mangos working on the same series would trip over each other
+	locks := NewSeriesLocks(naming)
+	rule = locks.Rule(rule)
+	if minFree > 0 {
+		dirs := []string{"."}
+		if *staging != "" {
+			// it has to be there for its free space to be known
+			os.MkdirAll(*staging, os.ModeDir|0770)
+			dirs = append(dirs, *staging)
+		}
+		guard := &SpaceGuard{Saver: pipeline, Dirs: dirs, MinFree: uint64(minFree), Interval: time.Minute}
+		pageSaver = guard
+		// last, so that only chapters that will be downloaded wait
+		rule = AndRule{rule, guard}
+	}
+	if fetcher.metrics != nil {
+		obs = append(obs, fetcher.metrics)
+	}
+	if statusLine != nil {
+		obs = append(obs, statusLine)
+	}
+	if *dashboardAddr != "" || screen != nil {
+		dashboard := NewDashboard()
+		log.SetOutput(dashboard.LogWriter(log.Writer()))
+		if *dashboardAddr != "" {
+			serveDashboard(*dashboardAddr, dashboard)
+		}
+		if screen != nil {
+			screen.Start(dashboard, gate)
+			defer screen.Stop()
+			onExit(func() {
+				screen.Stop()
+				log.SetOutput(plainLog)
+			})
+		}
+		obs = append(obs, dashboard)
+	}
 
-	wg := sync.WaitGroup{}
+	if *eventsPath != "" || *progressSocket != "" {
+		stream := NewEventStream()
+		obs = append(obs, stream)
+		if *eventsPath != "" {
+			events, err := OpenEventLog(*eventsPath, stream)
+			if err != nil {
+				fatalln("cannot open event log:", err)
+			}
+			defer events.Close()
+		}
+		if *progressSocket != "" {
+			socket, err := ListenProgressSocket(*progressSocket, stream)
+			if err != nil {
+				fatalln("cannot open progress socket:", err)
+			}
+			defer socket.Close()
+		}
+	}
 
-	chapters := os.Args[1:]
-	for _, c := range chapters {
+	var mediaServers []MediaServer
+	if config.Komga != nil {
+		mediaServers = append(mediaServers, Komga{*config.Komga})
+	}
+	if config.Kavita != nil {
+		mediaServers = append(mediaServers, &Kavita{MediaServerConfig: *config.Kavita})
+	}
+	if len(mediaServers) > 0 {
+		notifier := NewMediaServerObserver(mediaServers...)
+		defer notifier.Flush()
+		obs = append(obs, notifier)
+	}
+	if config.Calibre != nil {
+		obs = append(obs, CalibreObserver{*config.Calibre})
+	}
+
+	var passes MetadataPasses
+	if len(config.MetadataProviders) > 0 {
+		providers, err := lookupProviders(config.MetadataProviders)
+		if err != nil {
+			fatalln("cannot load config:", err)
+		}
+		passes = append(passes, NewProviderPass(fetcher, providers))
+	}
+	passes = append(passes, NewOverridePass(overrides, overridesDir()))
+	// This one has to come last, it cleans up after everything else
+	passes = append(passes, NormalizePass{})
+
+	if *archiveWorkers < 1 {
+		fatalln("invalid number of archive workers", *archiveWorkers)
+	}
+	hooks := NewHookObserver(config.Hooks)
+	report := NewReport()
+	report.Highlight = *bell
+	obs = append(obs, hooks, locks, report, NewWorkQueue(progress))
+	archiver := NewArchivePool(saver, obs, *archiveWorkers)
+
+	common := CommonSimpleCrawler{
+		client:   fetcher,
+		saver:    pageSaver,
+		rule:     rule,
+		obs:      archiver,
+		passes:   passes,
+		havePage: saver.HasPage,
+
+		preferGroups:    config.PreferGroups,
+		maxFailedPages:  *maxFailedPages,
+		continueOnError: *continueOnError,
+	}
+	if *keepHTML {
+		common.saveHTML = saver.SaveHTML
+	}
+	if *refresh {
+		common.reusePage = saver.ReusePage
+	}
+	common.quality = *imageQuality
+	if *languages != "" {
+		common.languages = strings.Split(*languages, ",")
+	}
+	if *preferVersions != "" {
+		common.preferVersions = strings.Split(*preferVersions, ",")
+	}
+	var schedule Schedule
+	if config.Schedule != "" {
+		if schedule, err = ParseCronSchedule(config.Schedule); err != nil {
+			fatalln("cannot load config:", err)
+		}
+	} else if *watchInterval > 0 {
+		schedule = everySchedule(*watchInterval)
+	}
+	run := func(urls []string) {
+		// a new run might find new chapters
+		common.index = NewMangaIndex()
+		report.Reset()
+		common.limit = NewRunLimit(int64(maxBytes), *maxPages)
+		download(urls, common)
+		archiver.Wait()
+		hooks.Flush()
+		reportOut := io.Writer(os.Stderr)
+		if screen != nil && schedule == nil {
+			// there's nothing more to show, and the report has to
+			// stay where it can be read
+			screen.Stop()
+			log.SetOutput(plainLog)
+		} else if screen != nil {
+			reportOut = log.Writer()
+		}
+		report.Print(reportOut)
+		if *bell {
+			fmt.Fprint(os.Stderr, "\a")
+		}
+		if *saveReport {
+			if path, err := report.Save("."); err != nil {
+				log.Println("cannot save report:", err)
+			} else {
+				log.Println("report saved to", path)
+			}
+		}
+		if fetcher.metrics != nil {
+			fetcher.metrics.runs.Add(1)
+		}
+	}
+
+	if schedule == nil {
+		var urls []string
+		for _, s := range watchTargets(flag.Args()) {
+			urls = append(urls, s.URL)
+		}
+		run(urls)
+		if report.Failed() > 0 {
+			// even if they didn't stop the run
+			exitStatus = 1
+		}
+		return
+	}
+	watch(flag.Args(), schedule, run)
+}
+
+// download gets each of urls, all at the same time.
+func download(urls []string, common CommonSimpleCrawler) {
+	var parsed []*url.URL
+	for _, c := range urls {
 		u, err := url.Parse(c)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
+		parsed = append(parsed, u)
+	}
 
-		h := handler(u, fetcher, saver, rule, saver)
+	wg := sync.WaitGroup{}
+	for _, u := range dedupeURLs(parsed) {
+		h := handler(u, common)
+		if h == nil {
+			continue
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			h.Handle(u)
 		}()
 	}
-
 	wg.Wait()
 }
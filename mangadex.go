@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	syncers["mangadex"] = mangadexEntries
+}
+
+const mangadexAPI = "https://api.mangadex.org"
+
+// mangadexEntries gets the series a MangaDex user follows, when list is
+// "follows", or the ones on a custom list (an MDList), by its ID.  Follows
+// need the user's login and a personal API client, set up with "mango login
+// mangadex"; public lists don't.  MangaDex has no statuses to go by, so
+// everything on the list is taken.
+func mangadexEntries(f Fetcher, list string, statuses []string) ([]listEntry, error) {
+	var ids []string
+	var token string
+	if list == "follows" {
+		var err error
+		if token, err = mangadexToken(f); err != nil {
+			return nil, err
+		}
+		err = mangadexPages(f, "/user/follows/manga", token, func(data []mangadexManga) {
+			for _, m := range data {
+				ids = append(ids, m.ID)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		req, _ := http.NewRequest("GET", mangadexAPI+"/list/"+url.PathEscape(list), nil)
+		var resp struct {
+			Data struct {
+				Relationships []struct {
+					ID   string `json:"id"`
+					Type string `json:"type"`
+				} `json:"relationships"`
+			} `json:"data"`
+		}
+		if err := fetchJSON(f, req, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Data.Relationships {
+			if r.Type == "manga" {
+				ids = append(ids, r.ID)
+			}
+		}
+	}
+
+	// the follows have the titles already, but lists don't, so both go
+	// the same way
+	var entries []listEntry
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), 100)]
+		ids = ids[len(batch):]
+		query := url.Values{"limit": {"100"}}
+		for _, id := range batch {
+			query.Add("ids[]", id)
+		}
+		req, _ := http.NewRequest("GET", mangadexAPI+"/manga?"+query.Encode(), nil)
+		var resp struct {
+			Data []mangadexManga `json:"data"`
+		}
+		if err := fetchJSON(f, req, &resp); err != nil {
+			return nil, err
+		}
+		for _, m := range resp.Data {
+			if titles := m.titles(); len(titles) > 0 {
+				entries = append(entries, listEntry{titles})
+			}
+		}
+	}
+	return entries, nil
+}
+
+type mangadexManga struct {
+	ID         string `json:"id"`
+	Attributes struct {
+		Title            map[string]string   `json:"title"`
+		AltTitles        []map[string]string `json:"altTitles"`
+		Description      map[string]string   `json:"description"`
+		Status           string              `json:"status"`
+		OriginalLanguage string              `json:"originalLanguage"`
+		ContentRating    string              `json:"contentRating"`
+		Tags             []struct {
+			Attributes struct {
+				Name  map[string]string `json:"name"`
+				Group string            `json:"group"`
+			} `json:"attributes"`
+		} `json:"tags"`
+	} `json:"attributes"`
+	Relationships []mangadexRelationship `json:"relationships"`
+}
+
+// mangadexRelationship is something related to what the API returned, with
+// its attributes if they were asked to be included.
+type mangadexRelationship struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Attributes struct {
+		Name     string `json:"name"`
+		FileName string `json:"fileName"`
+	} `json:"attributes"`
+}
+
+// related is the names of the relationships of type typ.
+func related(relationships []mangadexRelationship, typ string) string {
+	var names []string
+	for _, r := range relationships {
+		if r.Type == typ && r.Attributes.Name != "" {
+			names = append(names, r.Attributes.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// titles are the ones in English, or romanized Japanese, first.
+func (m mangadexManga) titles() []string {
+	all := append([]map[string]string{m.Attributes.Title}, m.Attributes.AltTitles...)
+	var titles []string
+	seen := map[string]bool{}
+	for _, lang := range []string{"en", "ja-ro", ""} {
+		for _, t := range all {
+			for l, title := range t {
+				if (lang == "" || l == lang) && !seen[title] {
+					seen[title] = true
+					titles = append(titles, title)
+				}
+			}
+		}
+	}
+	return titles
+}
+
+// mangadexPages goes through the pages of a list the API returns.
+func mangadexPages(f Fetcher, path, token string, each func([]mangadexManga)) error {
+	for offset := 0; ; offset += 100 {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s%s?limit=100&offset=%d", mangadexAPI, path, offset), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		var resp struct {
+			Data  []mangadexManga `json:"data"`
+			Total int             `json:"total"`
+		}
+		if err := fetchJSON(f, req, &resp); err != nil {
+			return err
+		}
+		each(resp.Data)
+		if offset+100 >= resp.Total {
+			return nil
+		}
+	}
+}
+
+// mangadexToken logs in with the stored credentials.
+func mangadexToken(f Fetcher) (string, error) {
+	config, err := loadConfig(configPath())
+	if err != nil {
+		return "", err
+	}
+	store, err := openCredentialStore(config.CredentialStore)
+	if err != nil {
+		return "", err
+	}
+	c, err := store.Get("mangadex")
+	if errors.Is(err, errNoCredentials) {
+		return "", errors.New(`not logged in, run "mango login mangadex" first`)
+	} else if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"password"},
+		"username":      {c.Username},
+		"password":      {c.Password},
+		"client_id":     {c.ClientID},
+		"client_secret": {c.ClientSecret},
+	}
+	req, _ := http.NewRequest("POST", "https://auth.mangadex.org/realms/mangadex/protocol/openid-connect/token",
+		strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := fetchJSON(f, req, &resp); err != nil {
+		return "", fmt.Errorf("cannot log in: %v", err)
+	}
+	return resp.AccessToken, nil
+}
+
+// MangaDexScraper goes by the API, for series at mangadex.org/title/ID and
+// chapters at mangadex.org/chapter/ID.
+type MangaDexScraper struct {
+	// Quality is "original" or "data-saver", for smaller images.
+	Quality string
+	// Languages are the ones to get chapters in, English by default.  With
+	// more than one, each is a series of its own, e.g. "One Piece [es]".
+	Languages []string
+}
+
+func (m MangaDexScraper) Limits() []DomainLimit {
+	return []DomainLimit{{Domain: "api.mangadex.org", Connections: 4, PerSecond: 4}}
+}
+
+func (m MangaDexScraper) Qualities() []string {
+	return []string{"original", "data-saver"}
+}
+
+func (m MangaDexScraper) get(f Fetcher, path string, query url.Values, v interface{}) error {
+	u, _ := url.Parse(mangadexAPI + path)
+	u.RawQuery = query.Encode()
+	resp, err := f.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// mangadexID is the ID in a URL of the site, after kind ("title" or
+// "chapter").
+func mangadexID(u *url.URL, kind string) (string, bool) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != kind {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func (m MangaDexScraper) Chapters(f Fetcher, mangaURL *url.URL) ([]Resource, error) {
+	id, ok := mangadexID(mangaURL, "title")
+	if !ok {
+		return nil, fmt.Errorf("mangadex: not a series: %s", mangaURL)
+	}
+	var manga struct {
+		Data mangadexManga `json:"data"`
+	}
+	query := url.Values{"includes[]": {"author", "artist", "cover_art"}}
+	if err := m.get(f, "/manga/"+id, query, &manga); err != nil {
+		return nil, err
+	}
+	attrs := manga.Data.Attributes
+	titles := manga.Data.titles()
+	if len(titles) == 0 {
+		return nil, fmt.Errorf("mangadex: no title for %s", mangaURL)
+	}
+	var genres []string
+	for _, t := range attrs.Tags {
+		if t.Attributes.Group == "genre" {
+			genres = append(genres, t.Attributes.Name["en"])
+		}
+	}
+	readingDirection := "ltr"
+	if attrs.OriginalLanguage == "ja" {
+		readingDirection = "rtl"
+	}
+	mangainfo := Metadata{
+		"manga":            titles[0],
+		"author":           related(manga.Data.Relationships, "author"),
+		"artist":           related(manga.Data.Relationships, "artist"),
+		"status":           attrs.Status,
+		"readingDirection": readingDirection,
+		"genres":           genres,
+		"description":      attrs.Description["en"],
+	}
+	if attrs.ContentRating == "erotica" || attrs.ContentRating == "pornographic" {
+		mangainfo["adult"] = true
+	}
+	for _, r := range manga.Data.Relationships {
+		if r.Type == "cover_art" && r.Attributes.FileName != "" {
+			mangainfo["coverImage"] = "https://uploads.mangadex.org/covers/" + id + "/" + r.Attributes.FileName
+		}
+	}
+
+	languages := m.Languages
+	if len(languages) == 0 {
+		languages = []string{"en"}
+	}
+	var chapters []Resource
+	// the chapters are numbered, and counted, in each language apart
+	count := map[string]int{}
+	for offset := 0; ; offset += 500 {
+		query := url.Values{
+			"translatedLanguage[]": languages,
+			"includes[]":           {"scanlation_group"},
+			"contentRating[]":      {"safe", "suggestive", "erotica", "pornographic"},
+			"order[volume]":        {"asc"},
+			"order[chapter]":       {"asc"},
+			"limit":                {"500"},
+			"offset":               {fmt.Sprint(offset)},
+		}
+		var feed struct {
+			Data []struct {
+				ID         string `json:"id"`
+				Attributes struct {
+					Chapter            *string   `json:"chapter"`
+					Title              string    `json:"title"`
+					TranslatedLanguage string    `json:"translatedLanguage"`
+					ExternalURL        *string   `json:"externalUrl"`
+					PublishAt          time.Time `json:"publishAt"`
+					Pages              int       `json:"pages"`
+				} `json:"attributes"`
+				Relationships []mangadexRelationship `json:"relationships"`
+			} `json:"data"`
+			Total int `json:"total"`
+		}
+		if err := m.get(f, "/manga/"+id+"/feed", query, &feed); err != nil {
+			return nil, err
+		}
+		for _, c := range feed.Data {
+			// those hosted elsewhere have no pages here
+			if c.Attributes.ExternalURL != nil || c.Attributes.Pages == 0 {
+				continue
+			}
+			u, _ := url.Parse("https://mangadex.org/chapter/" + c.ID)
+			number := c.Attributes.Title
+			if c.Attributes.Chapter != nil {
+				number = *c.Attributes.Chapter
+			}
+			language := c.Attributes.TranslatedLanguage
+			count[language]++
+			chapterinfo := Metadata{
+				"chapterIndex": count[language],
+				"chapter":      chapterMetadata(number),
+				"chapterName":  c.Attributes.Title,
+				"language":     language,
+				"uploaded":     c.Attributes.PublishAt,
+				"url":          u.String(),
+			}
+			if group := related(c.Relationships, "scanlation_group"); group != "" {
+				chapterinfo["group"] = group
+			}
+			chapterinfo.Update(mangainfo)
+			if len(languages) > 1 {
+				chapterinfo["manga"] = fmt.Sprintf("%s [%s]", titles[0], language)
+			}
+			chapters = append(chapters, Resource{u, chapterinfo})
+		}
+		if offset+500 >= feed.Total {
+			break
+		}
+	}
+	for _, c := range chapters {
+		c.info["chapters"] = count[c.info["language"].(string)]
+	}
+	if len(chapters) < 1 {
+		return nil, fmt.Errorf("mangadex: no chapters for %s", mangaURL)
+	}
+	return chapters, nil
+}
+
+// Pages come from whichever MangaDex@Home server the API hands out.
+func (m MangaDexScraper) Pages(f Fetcher, chapter Resource) ([]Resource, error) {
+	id, ok := mangadexID(chapter.url, "chapter")
+	if !ok {
+		return nil, fmt.Errorf("mangadex: not a chapter: %s", chapter.url)
+	}
+	var server struct {
+		BaseURL string `json:"baseUrl"`
+		Chapter struct {
+			Hash      string   `json:"hash"`
+			Data      []string `json:"data"`
+			DataSaver []string `json:"dataSaver"`
+		} `json:"chapter"`
+	}
+	if err := m.get(f, "/at-home/server/"+id, nil, &server); err != nil {
+		return nil, err
+	}
+	files, dir := server.Chapter.Data, "data"
+	if m.Quality == "data-saver" {
+		files, dir = server.Chapter.DataSaver, "data-saver"
+	}
+	var images []Resource
+	for i, file := range files {
+		u, err := url.Parse(server.BaseURL + "/" + dir + "/" + server.Chapter.Hash + "/" + file)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, Resource{u, Metadata{
+			"pages":          len(files),
+			"pageIndex":      i + 1,
+			"imageExtension": "jpg", // the real type is sniffed on download
+		}})
+	}
+	return images, nil
+}
+
+type MangaDexCrawler struct {
+	CommonSimpleCrawler
+}
+
+func NewMangaDexCrawler(common CommonSimpleCrawler) *MangaDexCrawler {
+	scraper := MangaDexScraper{Languages: common.languages}
+	scraper.Quality = pickQuality(scraper, common.quality)
+	common.api = scraper
+	return &MangaDexCrawler{common}
+}
+
+func (m *MangaDexCrawler) Handle(u *url.URL) {
+	if id, ok := mangadexID(u, "title"); ok {
+		// manga url (/title/ID/slug)
+		mangaURL, _ := u.Parse("/title/" + id)
+		m.handleManga(mangaURL)
+		return
+	}
+	id, ok := mangadexID(u, "chapter")
+	if !ok {
+		fatalln("mangadex: cannot handle", u)
+	}
+	// chapter url (/chapter/ID/page); the series is only to be had from
+	// the API
+	var chapter struct {
+		Data struct {
+			Relationships []mangadexRelationship `json:"relationships"`
+		} `json:"data"`
+	}
+	if err := (MangaDexScraper{}).get(m.client, "/chapter/"+id, nil, &chapter); err != nil {
+		m.failed(Metadata{"url": u.String()}, err)
+		return
+	}
+	for _, r := range chapter.Data.Relationships {
+		if r.Type == "manga" {
+			chapterPath := "/chapter/" + id
+			whitelistRule := funcRule(func(r Resource) bool {
+				return r.url.Path != chapterPath
+			})
+			m.rule = AndRule{whitelistRule, m.rule}
+			mangaURL, _ := u.Parse("/title/" + r.ID)
+			m.handleManga(mangaURL)
+			return
+		}
+	}
+	m.failed(Metadata{"url": u.String()}, errors.New("mangadex: chapter of no series"))
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"log"
 	"net/url"
 	"path"
 	"regexp"
@@ -12,6 +11,11 @@ import (
 
 type MangaEdenScraper struct{}
 
+// Limits covers the CDN the images are on, too.
+func (m MangaEdenScraper) Limits() []DomainLimit {
+	return []DomainLimit{{Domain: "*mangaeden.com", Connections: 10, PerSecond: 5}}
+}
+
 func (m MangaEdenScraper) GetChapters(doc *goquery.Document) (chapters []Resource) {
 	comicType := nextTextNode(doc.Find("#rightContent h4:contains('Type')")).Text()
 	comicType = strings.ToLower(strings.TrimSpace(comicType))
@@ -36,7 +40,7 @@ func (m MangaEdenScraper) GetChapters(doc *goquery.Document) (chapters []Resourc
 
 	mangaName := mangainfo["manga"].(string)
 	if len(mangaName) < 1 {
-		log.Fatal("cannot extract chapters: no manga name")
+		fatal("cannot extract chapters: no manga name")
 	}
 
 	chapterLinks := doc.Find(".chapterLink")
@@ -44,37 +48,41 @@ func (m MangaEdenScraper) GetChapters(doc *goquery.Document) (chapters []Resourc
 
 	chapterLinks.Each(func(i int, s *goquery.Selection) {
 		if goquery.NodeName(s) != "a" {
-			log.Fatal("cannot extract chapters: no link")
+			fatal("cannot extract chapters: no link")
 		}
 		link, ok := s.Attr("href")
 		if !ok {
-			log.Fatal("cannot extract chapters: no link")
+			fatal("cannot extract chapters: no link")
 		}
 
 		re := regexp.MustCompile(`(?P<num>[^:]+)(?:: (?P<name>.*))?`)
 		// match := re.FindStringSubmatch(strings.TrimLeftFunc(s.Text(), unicode.IsSpace))
 		match := re.FindStringSubmatch(s.Find("b").Text())
 		if len(match) < 1 {
-			log.Fatal("cannot extract chapters: no number")
+			fatal("cannot extract chapters: no number")
+		}
+
+		u, err := doc.Url.Parse(link)
+		if err != nil {
+			fatalln("cannot extract chapters:", err)
 		}
 
 		chapterinfo := Metadata{
 			"chapterIndex": i + 1,
-			"chapter":      match[1],
+			"chapter":      chapterMetadata(match[1]),
 			"chapterName":  match[2],
-			// "dateAdded":    s.Parent().Parent().Find("td.chapterDate").Text(),
+			"url":          u.String(),
+		}
+		if uploaded, ok := parseDate(s.Parent().Parent().Find("td.chapterDate").Text()); ok {
+			chapterinfo["uploaded"] = uploaded
 		}
 		chapterinfo.Update(mangainfo)
 
-		u, err := doc.Url.Parse(link)
-		if err != nil {
-			log.Fatalln("cannot extract chapters:", err)
-		}
 		chapters = append(chapters, Resource{u, chapterinfo})
 	})
 
 	if len(chapters) < 1 {
-		log.Fatal("cannot extract chapters: none found")
+		fatal("cannot extract chapters: none found")
 	}
 	return
 }
@@ -84,7 +92,7 @@ func (m MangaEdenScraper) GetPages(doc *goquery.Document) (pages []Resource, ima
 	options.Each(func(i int, s *goquery.Selection) {
 		value, ok := s.Attr("value")
 		if !ok {
-			log.Fatal("cannot extract pages: no link")
+			fatal("cannot extract pages: no link")
 		}
 
 		info := Metadata{
@@ -94,7 +102,7 @@ func (m MangaEdenScraper) GetPages(doc *goquery.Document) (pages []Resource, ima
 
 		u, err := doc.Url.Parse(value)
 		if err != nil {
-			log.Fatalln("cannot extract pages:", err)
+			fatalln("cannot extract pages:", err)
 		}
 		if _, selected := s.Attr("selected"); selected {
 			img := m.GetImage(doc)
@@ -111,29 +119,24 @@ func (m MangaEdenScraper) GetPages(doc *goquery.Document) (pages []Resource, ima
 func (m MangaEdenScraper) GetImage(page *goquery.Document) (img Resource) {
 	imgSrc, ok := page.Find("#mainImg").Attr("src")
 	if !ok {
-		log.Fatal("cannot extract image: no #img or @src")
+		fatal("cannot extract image: no #img or @src")
 	}
 
 	imgURL, err := page.Url.Parse(imgSrc)
 	if err != nil {
-		log.Fatalln("cannot extract image:", err)
+		fatalln("cannot extract image:", err)
 	}
-	return Resource{imgURL, Metadata{"imageExtension": "jpg"}} // XXX: are all images jpgs
+	return Resource{imgURL, Metadata{"imageExtension": "jpg"}} // the real type is sniffed on download
 }
 
 type MangaEdenCrawler struct {
 	CommonSimpleCrawler
 }
 
-func NewMangaEdenCrawler(fetcher Fetcher, saver Saver, rule Rule, obs Observer) *MangaEdenCrawler {
+func NewMangaEdenCrawler(common CommonSimpleCrawler) *MangaEdenCrawler {
+	common.scraper = MangaEdenScraper{}
 	crawler := &MangaEdenCrawler{
-		CommonSimpleCrawler{
-			scraper: MangaEdenScraper{},
-			client:  fetcher,
-			saver:   saver,
-			rule:    rule,
-			obs:     obs,
-		},
+		common,
 	}
 
 	return crawler
@@ -165,6 +168,6 @@ func (m *MangaEdenCrawler) Handle(u *url.URL) {
 		m.handleManga(mangaURL)
 
 	default:
-		log.Fatalln("mangaeden: cannot handle", u)
+		fatalln("mangaeden: cannot handle", u)
 	}
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/url"
+	"sync"
+)
+
+// MangaIndex remembers the chapters of every series seen during a run, so
+// that asking for several chapters of the same series only fetches and
+// scrapes its page once, and only downloads each chapter once.  A nil
+// *MangaIndex remembers nothing.
+type MangaIndex struct {
+	mu       sync.Mutex
+	series   map[string]*mangaIndexEntry
+	chapters map[string]*chapterClaim
+}
+
+type mangaIndexEntry struct {
+	once     sync.Once
+	chapters []Resource
+	err      error
+}
+
+type chapterClaim struct {
+	mu    sync.Mutex
+	taken bool
+}
+
+func NewMangaIndex() *MangaIndex {
+	return &MangaIndex{
+		series:   map[string]*mangaIndexEntry{},
+		chapters: map[string]*chapterClaim{},
+	}
+}
+
+// Claim tells whether the chapter should be downloaded: if rule lets it
+// through and no one else has claimed it yet during this run, for example
+// because both its URL and its series' were given.
+func (idx *MangaIndex) Claim(chapter Resource, rule Rule) bool {
+	if idx == nil {
+		return !rule.Block(chapter)
+	}
+
+	key := urlKey(normalizeURL(chapter.url))
+	idx.mu.Lock()
+	c, ok := idx.chapters[key]
+	if !ok {
+		c = &chapterClaim{}
+		idx.chapters[key] = c
+	}
+	idx.mu.Unlock()
+
+	// if the first one's rule blocks it, the next one gets to ask its own
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.taken {
+		return false
+	}
+	c.taken = !rule.Block(chapter)
+	return c.taken
+}
+
+// Chapters returns the chapters of the series at mangaURL, fetching them
+// with m if they aren't known yet.  Every caller gets its own copy of their
+// metadata, to change as it likes.
+func (idx *MangaIndex) Chapters(m *CommonSimpleCrawler, mangaURL *url.URL) ([]Resource, error) {
+	if idx == nil {
+		return fetchChapters(m, mangaURL)
+	}
+
+	idx.mu.Lock()
+	e, ok := idx.series[mangaURL.String()]
+	if !ok {
+		e = &mangaIndexEntry{}
+		idx.series[mangaURL.String()] = e
+	}
+	idx.mu.Unlock()
+
+	// whoever comes second waits for the first to finish
+	e.once.Do(func() {
+		e.chapters, e.err = fetchChapters(m, mangaURL)
+	})
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	chapters := make([]Resource, len(e.chapters))
+	for i, c := range e.chapters {
+		info := Metadata{}
+		info.Update(c.info)
+		chapters[i] = Resource{c.url, info}
+	}
+	return chapters, nil
+}
+
+func fetchChapters(m *CommonSimpleCrawler, mangaURL *url.URL) ([]Resource, error) {
+	if m.api != nil {
+		return m.api.Chapters(m.client, mangaURL)
+	}
+	mangaDoc, err := m.client.GetHTML(mangaURL)
+	if err != nil {
+		return nil, err
+	}
+	return m.scraper.GetChapters(mangaDoc), nil
+}
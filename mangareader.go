@@ -14,6 +14,10 @@ import (
 
 type MangaReaderScraper struct{}
 
+func (m MangaReaderScraper) Limits() []DomainLimit {
+	return []DomainLimit{{Domain: "*mangareader.net", Connections: 8, PerSecond: 4}}
+}
+
 func mapSelectionText(i int, s *goquery.Selection) string {
 	return s.Text()
 }
@@ -32,7 +36,7 @@ func (m MangaReaderScraper) GetChapters(doc *goquery.Document) (chapters []Resou
 
 	mangaName := mangainfo["manga"].(string)
 	if len(mangaName) < 1 {
-		log.Fatal("cannot extract chapters: no manga name")
+		fatal("cannot extract chapters: no manga name")
 	}
 
 	readingDirection := mangainfo["readingDirection"].(string)
@@ -48,38 +52,49 @@ func (m MangaReaderScraper) GetChapters(doc *goquery.Document) (chapters []Resou
 	listings.Each(func(i int, s *goquery.Selection) {
 		links := s.Find("a[href]")
 		if links.Length() != 1 {
-			log.Fatal("cannot extract chapters: no link")
+			fatal("cannot extract chapters: no link")
 		}
 		link, ok := links.Attr("href")
 		if !ok {
-			log.Fatal("cannot extract chapters: no link")
+			fatal("cannot extract chapters: no link")
 		}
 
 		re := regexp.MustCompile(regexp.QuoteMeta(mangaName) + ` (?P<num>\d+) : (?P<name>.*)`)
 		// match := re.FindStringSubmatch(strings.TrimLeftFunc(s.Text(), unicode.IsSpace))
 		match := re.FindStringSubmatch(s.Text())
-		if len(match) < 1 {
-			log.Fatal("cannot extract chapters: no number")
+		var chapter interface{}
+		if len(match) >= 1 {
+			num, _ := strconv.Atoi(match[1])
+			chapter = ChapterNumber{Major: num}
+		} else if listings.Length() == 1 {
+			// a one-shot, most likely
+			match = []string{"", "", strings.TrimSpace(s.Find("a").Text())}
+			chapter = ""
+		} else {
+			fatal("cannot extract chapters: no number")
+		}
+
+		u, err := doc.Url.Parse(link)
+		if err != nil {
+			fatalln("cannot extract chapters:", err)
 		}
-		num, _ := strconv.Atoi(match[1])
 
 		chapterinfo := Metadata{
 			"chapterIndex": i + 1,
-			"chapter":      num,
+			"chapter":      chapter,
 			"chapterName":  match[2],
-			// "dateAdded":    s.Next().Text(),
+			"url":          u.String(),
+		}
+		if uploaded, ok := parseDate(s.Next().Text()); ok {
+			chapterinfo["uploaded"] = uploaded
 		}
 		chapterinfo.Update(mangainfo)
 
-		u, err := doc.Url.Parse(link)
-		if err != nil {
-			log.Fatalln("cannot extract chapters:", err)
-		}
 		chapters = append(chapters, Resource{u, chapterinfo})
 	})
 
 	if len(chapters) < 1 {
-		log.Fatal("cannot extract chapters: none found")
+		fatal("cannot extract chapters: none found")
 	}
 	return
 }
@@ -89,7 +104,7 @@ func (m MangaReaderScraper) GetPages(doc *goquery.Document) (pages []Resource, i
 	options.Each(func(i int, s *goquery.Selection) {
 		value, ok := s.Attr("value")
 		if !ok {
-			log.Fatal("cannot extract pages: no link")
+			fatal("cannot extract pages: no link")
 		}
 
 		info := Metadata{
@@ -99,7 +114,7 @@ func (m MangaReaderScraper) GetPages(doc *goquery.Document) (pages []Resource, i
 
 		u, err := doc.Url.Parse(value)
 		if err != nil {
-			log.Fatalln("cannot extract pages:", err)
+			fatalln("cannot extract pages:", err)
 		}
 		if _, selected := s.Attr("selected"); selected {
 			img := m.GetImage(doc)
@@ -115,14 +130,14 @@ func (m MangaReaderScraper) GetPages(doc *goquery.Document) (pages []Resource, i
 func (m MangaReaderScraper) GetImage(doc *goquery.Document) Resource {
 	imgSrc, ok := doc.Find("#img").Attr("src")
 	if !ok {
-		log.Fatal("cannot extract image: no #img or @src")
+		fatal("cannot extract image: no #img or @src")
 	}
 
 	imgURL, err := url.Parse(imgSrc)
 	if err != nil {
-		log.Fatalln("cannot extract image:", err)
+		fatalln("cannot extract image:", err)
 	}
-	return Resource{imgURL, Metadata{"imageExtension": "jpg"}} // XXX: are all images jpgs
+	return Resource{imgURL, Metadata{"imageExtension": "jpg"}} // the real type is sniffed on download
 }
 
 type MangaReaderCrawler struct {
@@ -139,12 +154,12 @@ func (m *MangaReaderCrawler) parseImageNumber(u *url.URL) (number int, pathFmt s
 
 	match := IMAGE_NAME_RE.FindStringSubmatch(basename)
 	if len(match) < 1 {
-		log.Fatal("cannot guess images: cannot extract file id")
+		fatal("cannot guess images: cannot extract file id")
 	}
 
 	var err error
 	if number, err = strconv.Atoi(match[2]); err != nil {
-		log.Fatalln("cannot guess images:", err)
+		fatalln("cannot guess images:", err)
 	}
 
 	pathFmt = fmt.Sprintf("./%s-%%d.%s",
@@ -168,7 +183,7 @@ func (m *MangaReaderCrawler) parseImageNumber(u *url.URL) (number int, pathFmt s
 // chapter.  To guess them then, requires that another image be downloaded.
 func (m *MangaReaderCrawler) guessImages(pages []Resource, images []Resource) (pagesRem []Resource, guesses []*url.URL) {
 	if len(images) == 0 {
-		log.Fatal("cannot guess images: no images given")
+		fatal("cannot guess images: no images given")
 	}
 	if len(pages) == 0 {
 		// wow, single page chapter
@@ -176,7 +191,11 @@ func (m *MangaReaderCrawler) guessImages(pages []Resource, images []Resource) (p
 	}
 
 	thisImageRes := images[0]
-	lastImageRes := m.handlePage(pages[len(pages)-1])
+	failed := &failedPages{}
+	lastImageRes := m.handlePage(pages[len(pages)-1], failed, &pageSources{})
+	if failed.err != nil {
+		fatalln("cannot guess images:", failed.err)
+	}
 	pages = pages[:len(pages)-1]
 
 	thisPage := thisImageRes.info["page"].(int)
@@ -194,7 +213,7 @@ func (m *MangaReaderCrawler) guessImages(pages []Resource, images []Resource) (p
 	delta := (lastImage - thisImage) / (lastPage - thisPage)
 	start := thisImage - thisPage*delta
 
-	log.Printf("%s@%d this:%d last:%d delta:%d",
+	log.Printf("%s@%v this:%d last:%d delta:%d",
 		thisImageRes.info["manga"], thisImageRes.info["chapter"],
 		thisImage, lastImage, delta)
 
@@ -208,16 +227,11 @@ func (m *MangaReaderCrawler) guessImages(pages []Resource, images []Resource) (p
 	return
 }
 
-func NewMangaReaderCrawler(fetcher Fetcher, saver Saver, rule Rule, obs Observer) *MangaReaderCrawler {
+func NewMangaReaderCrawler(common CommonSimpleCrawler) *MangaReaderCrawler {
+	common.scraper = MangaReaderScraper{}
 	crawler := &MangaReaderCrawler{
 		false,
-		CommonSimpleCrawler{
-			scraper: MangaReaderScraper{},
-			client:  fetcher,
-			saver:   saver,
-			rule:    rule,
-			obs:     obs,
-		},
+		common,
 	}
 
 	return crawler
@@ -249,6 +263,6 @@ func (m *MangaReaderCrawler) Handle(u *url.URL) {
 		m.handleManga(mangaURL)
 
 	default:
-		log.Fatalln("mangareader: cannot handle", u)
+		fatalln("mangareader: cannot handle", u)
 	}
 }
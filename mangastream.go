@@ -1,11 +1,9 @@
 package main
 
 import (
-	"log"
 	"net/url"
 	"path"
 	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
@@ -13,6 +11,10 @@ import (
 
 type MangaStreamerScraper struct{}
 
+func (m MangaStreamerScraper) Limits() []DomainLimit {
+	return []DomainLimit{{Domain: "*readms.net", Connections: 6, PerSecond: 3}}
+}
+
 func (m MangaStreamerScraper) GetChapters(doc *goquery.Document) (chapters []Resource) {
 	mangainfo := Metadata{
 		"manga":            doc.Find("h1").Text(),
@@ -21,7 +23,7 @@ func (m MangaStreamerScraper) GetChapters(doc *goquery.Document) (chapters []Res
 
 	mangaName := mangainfo["manga"].(string)
 	if len(mangaName) < 1 {
-		log.Fatal("cannot extract chapters: no manga name")
+		fatal("cannot extract chapters: no manga name")
 	}
 
 	links := doc.Find("table a")
@@ -30,40 +32,36 @@ func (m MangaStreamerScraper) GetChapters(doc *goquery.Document) (chapters []Res
 	links.Each(func(i int, s *goquery.Selection) {
 		href, ok := s.Attr("href")
 		if !ok {
-			log.Fatal("cannot extract chapters: no link")
+			fatal("cannot extract chapters: no link")
 		}
 
 		re := regexp.MustCompile(`(?P<num>[^-]*)(?: - (?P<name>.*))?`)
 		match := re.FindStringSubmatch(s.Text())
 		if len(match) < 1 {
-			log.Fatal("cannot extract chapters: no number")
+			fatal("cannot extract chapters: no number")
+		}
+
+		u, err := doc.Url.Parse(href)
+		if err != nil {
+			fatalln("cannot extract chapters:", err)
 		}
 
 		chapterinfo := Metadata{
 			"chapterIndex": i + 1,
-			"chapter":      match[1],
+			"chapter":      chapterMetadata(match[1]),
 			"chapterName":  match[2],
-			// "dateAdded":    s.Next().Text(),
+			"url":          u.String(),
 		}
-		chapterinfo.Update(mangainfo)
-
-		allDigits := false
-		for _, c := range chapterinfo["chapter"].(string) {
-			allDigits = '0' <= c && c <= '9'
-		}
-		if allDigits {
-			chapterinfo["chapter"], _ = strconv.Atoi(chapterinfo["chapter"].(string))
+		if uploaded, ok := parseDate(s.Parent().Next().Text()); ok {
+			chapterinfo["uploaded"] = uploaded
 		}
+		chapterinfo.Update(mangainfo)
 
-		u, err := doc.Url.Parse(href)
-		if err != nil {
-			log.Fatalln("cannot extract chapters:", err)
-		}
 		chapters = append(chapters, Resource{u, chapterinfo})
 	})
 
 	if len(chapters) < 1 {
-		log.Fatal("cannot extract chapters: none found")
+		fatal("cannot extract chapters: none found")
 	}
 	return
 }
@@ -73,7 +71,7 @@ func (m MangaStreamerScraper) isSamePage(a, fromUser *url.URL) bool {
 	userPath := strings.TrimRight(fromUser.EscapedPath(), "/")
 
 	if ok, err := path.Match("/r*/*/*/*/[0-9]*", aPath); !ok || err != nil {
-		log.Fatalln("invalid page url")
+		fatalln("invalid page url")
 	}
 
 	switch strings.Count(userPath, "/") {
@@ -100,7 +98,7 @@ func (m MangaStreamerScraper) GetPages(doc *goquery.Document) (pages []Resource,
 	links.Each(func(i int, s *goquery.Selection) {
 		href, ok := s.Attr("href")
 		if !ok {
-			log.Fatal("cannot extract pages: no link")
+			fatal("cannot extract pages: no link")
 		}
 
 		info := Metadata{
@@ -110,7 +108,7 @@ func (m MangaStreamerScraper) GetPages(doc *goquery.Document) (pages []Resource,
 
 		u, err := doc.Url.Parse(href)
 		if err != nil {
-			log.Fatalln("cannot extract pages:", err)
+			fatalln("cannot extract pages:", err)
 		}
 		if m.isSamePage(u, doc.Url) {
 			img := m.GetImage(doc)
@@ -126,12 +124,12 @@ func (m MangaStreamerScraper) GetPages(doc *goquery.Document) (pages []Resource,
 func (m MangaStreamerScraper) GetImage(doc *goquery.Document) Resource {
 	imgSrc, ok := doc.Find("#manga-page").Attr("src")
 	if !ok {
-		log.Fatal("cannot extract image: no #img or @src")
+		fatal("cannot extract image: no #img or @src")
 	}
 
 	imgURL, err := doc.Url.Parse(imgSrc)
 	if err != nil {
-		log.Fatalln("cannot extract image:", err)
+		fatalln("cannot extract image:", err)
 	}
 	return Resource{imgURL, Metadata{
 		"imageExtension": path.Ext(imgURL.EscapedPath())[1:],
@@ -142,15 +140,10 @@ type MangaStreamerCrawler struct {
 	CommonSimpleCrawler
 }
 
-func NewMangaStreamerCrawler(fetcher Fetcher, saver Saver, rule Rule, obs Observer) *MangaStreamerCrawler {
+func NewMangaStreamerCrawler(common CommonSimpleCrawler) *MangaStreamerCrawler {
+	common.scraper = MangaStreamerScraper{}
 	crawler := &MangaStreamerCrawler{
-		CommonSimpleCrawler{
-			scraper: MangaStreamerScraper{},
-			client:  fetcher,
-			saver:   saver,
-			rule:    rule,
-			obs:     obs,
-		},
+		common,
 	}
 
 	return crawler
@@ -193,6 +186,6 @@ func (m *MangaStreamerCrawler) Handle(u *url.URL) {
 		m.handleManga(mangaURL)
 
 	default:
-		log.Fatalln("mangastream: cannot handle", u)
+		fatalln("mangastream: cannot handle", u)
 	}
 }
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	_ "modernc.org/sqlite"
+)
+
+// ManifestEntry records a single downloaded chapter.
+type ManifestEntry struct {
+	Source     string    `json:"source"` // the chapter's URL
+	Series     string    `json:"series"`
+	Chapter    string    `json:"chapter"`
+	Title      string    `json:"title,omitempty"`   // the chapter's
+	Variant    string    `json:"variant,omitempty"` // which of several releases of the chapter
+	Oneshot    bool      `json:"oneshot,omitempty"`
+	Language   string    `json:"language,omitempty"`
+	Path       string    `json:"path"`
+	Hash       string    `json:"hash,omitempty"` // SHA-256 of the archive, if it is one
+	Pages      int       `json:"pages"`
+	Saved      int       `json:"saved,omitempty"` // pages in the archive, without the dropped ones
+	Group      string    `json:"group,omitempty"` // the scanlation group
+	Uploaded   time.Time `json:"uploaded,omitzero"`
+	Downloaded time.Time `json:"downloaded"`
+	Missing    []int     `json:"missing,omitempty"` // pages that failed to download
+	CID        string    `json:"cid,omitempty"`     // the archive's, if it was added to IPFS
+}
+
+// A Manifest keeps track of everything that has been downloaded.
+type Manifest interface {
+	Add(ManifestEntry) error
+	// Entries returns the chapters of the given series, or of every series
+	// if it's empty.
+	Entries(series string) ([]ManifestEntry, error)
+	// Remove forgets the chapter at path.
+	Remove(path string) error
+	Close() error
+}
+
+// manifestFlags adds the flags that choose the manifest to fs.
+func manifestFlags(fs *flag.FlagSet) (format, path *string) {
+	format = fs.String("manifest-format", "sqlite", "keep track of downloads in an sqlite database, per-series json files or none")
+	path = fs.String("manifest", "", "the SQLite database or the root of the JSON manifests (default mango.db or the working directory)")
+	return
+}
+
+// How a manifest is opened: only downloads create it, the rest would only
+// find an empty one where they should have found a library.
+type manifestMode int
+
+const (
+	manifestRead   manifestMode = iota // and never write to it
+	manifestUpdate                     // what's there already
+	manifestCreate                     // if it's not there
+)
+
+// openManifest opens the manifest of the given format ("sqlite", "json" or
+// "none"); path is the database for SQLite and the library root for JSON.
+func openManifest(format, path string, mode manifestMode) (Manifest, error) {
+	switch format {
+	case "sqlite":
+		if path == "" {
+			path = "mango.db"
+		}
+		return OpenSQLiteManifest(path, mode)
+	case "json":
+		if path == "" {
+			// the series directories are created in the working directory
+			path = "."
+		}
+		return &JSONManifest{Root: path}, nil
+	case "none", "":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown manifest format %q", format)
+}
+
+// manifestRoot is where the manifest's relative paths start from: where the
+// downloads ran, which is where the manifest is unless it was put elsewhere.
+func manifestRoot(format, path string) string {
+	switch {
+	case path == "":
+		return "."
+	case format == "sqlite":
+		return filepath.Dir(path)
+	}
+	return path
+}
+
+// manifestPaths indexes entries by their chapters' absolute paths, so that
+// they can be found from any working directory.
+func manifestPaths(entries []ManifestEntry, root string) map[string]ManifestEntry {
+	byPath := map[string]ManifestEntry{}
+	for _, e := range entries {
+		byPath[absPath(root, e.Path)] = e
+	}
+	return byPath
+}
+
+// absPath is path made absolute, if it's relative, from dir.
+func absPath(dir, path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// ManifestObserver adds every finished chapter to a Manifest.  It has to come
+// after the Saver, which is what decides where the chapter ends up.
+type ManifestObserver struct {
+	Manifest Manifest
+}
+
+func (o ManifestObserver) OnPageEnd(info Metadata) {}
+
+func (o ManifestObserver) OnChapterEnd(info Metadata) {
+	path, _ := info["path"].(string)
+	if path == "" {
+		// the saver didn't produce anything
+		return
+	}
+
+	entry := ManifestEntry{
+		Series:     fmt.Sprint(info["manga"]),
+		Chapter:    fmt.Sprint(info["chapter"]),
+		Path:       path,
+		Downloaded: time.Now(),
+	}
+	entry.Pages, _ = info["pages"].(int)
+	entry.Saved, _ = info["savedPages"].(int)
+	entry.Title, _ = info["chapterName"].(string)
+	entry.Variant, _ = info["variant"].(string)
+	entry.Oneshot, _ = info["oneshot"].(bool)
+	entry.Language, _ = info["language"].(string)
+	entry.Group, _ = info["group"].(string)
+	entry.Uploaded, _ = info["uploaded"].(time.Time)
+	entry.Source, _ = info["url"].(string)
+	entry.Missing, _ = info["missingPages"].([]int)
+	entry.CID, _ = info["cid"].(string)
+	if isFile(path) {
+		hash, err := hashFile(path)
+		if err != nil {
+			log.Println("cannot hash chapter:", err)
+		}
+		entry.Hash = hash
+	}
+
+	if err := o.Manifest.Add(entry); err != nil {
+		log.Println("cannot update manifest:", err)
+	}
+}
+
+// ManifestRule blocks chapters the manifest already has, no matter which site
+// they came from, so that a series followed on two sites is only downloaded
+// once.  A chapter that was deleted is downloaded again from where it came
+// from, the way it would be without the manifest.
+type ManifestRule struct {
+	manifest Manifest
+
+	mu sync.Mutex
+	// what was downloaded of each chapter of each series
+	series map[string]map[string][]ManifestEntry
+}
+
+func NewManifestRule(manifest Manifest) *ManifestRule {
+	return &ManifestRule{manifest: manifest, series: map[string]map[string][]ManifestEntry{}}
+}
+
+// seriesKey makes the names different sites give the same series compare
+// equal; "One Piece", "one piece" and "One-Piece" are all the same.
+func seriesKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+func (r *ManifestRule) Block(resrc Resource) bool {
+	key := seriesKey(fmt.Sprint(resrc.info["manga"]))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.series[key] == nil {
+		entries, err := r.manifest.Entries("")
+		if err != nil {
+			log.Println("cannot read manifest:", err)
+			return false
+		}
+		for _, e := range entries {
+			k := seriesKey(e.Series)
+			if r.series[k] == nil {
+				r.series[k] = map[string][]ManifestEntry{}
+			}
+			r.series[k][e.Chapter] = append(r.series[k][e.Chapter], e)
+		}
+		if r.series[key] == nil {
+			// don't go through the manifest again for this one
+			r.series[key] = map[string][]ManifestEntry{}
+		}
+	}
+
+	group, _ := resrc.info["group"].(string)
+	source, _ := resrc.info["url"].(string)
+	for _, e := range r.series[key][fmt.Sprint(resrc.info["chapter"])] {
+		// another group's release of it is something else, unless it's
+		// not known who released either
+		if e.Group != "" && group != "" && !strings.EqualFold(e.Group, group) {
+			continue
+		}
+		if _, err := os.Stat(e.Path); os.IsNotExist(err) && e.Source == source {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// The schema of the SQLite manifest; the database's user_version is the number
+// of these that have been applied.
+var manifestMigrations = []string{
+	`CREATE TABLE chapters (
+		source     TEXT NOT NULL,
+		series     TEXT NOT NULL,
+		chapter    TEXT NOT NULL,
+		path       TEXT NOT NULL PRIMARY KEY,
+		hash       TEXT NOT NULL,
+		downloaded TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX chapters_series ON chapters (series, chapter)`,
+	`ALTER TABLE chapters ADD COLUMN pages INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE chapters ADD COLUMN scanlator TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE chapters ADD COLUMN uploaded TIMESTAMP`,
+	`ALTER TABLE chapters ADD COLUMN missing TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE chapters ADD COLUMN cid TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE chapters ADD COLUMN saved INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE chapters ADD COLUMN title TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE chapters ADD COLUMN variant TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE chapters ADD COLUMN oneshot BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE chapters ADD COLUMN language TEXT NOT NULL DEFAULT ''`,
+}
+
+// SQLiteManifest is a Manifest kept in an SQLite database.
+type SQLiteManifest struct {
+	db *sql.DB
+}
+
+func OpenSQLiteManifest(path string, mode manifestMode) (*SQLiteManifest, error) {
+	if mode != manifestCreate && !isFile(path) {
+		return nil, fmt.Errorf("%s not found (use -manifest to point to it, or -manifest-format none)", path)
+	}
+	m, err := openSQLite(path, "rwc")
+	if err != nil {
+		return nil, err
+	}
+	// even readers need the tables as this version has them
+	if err := m.migrate(); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if mode != manifestRead {
+		return m, nil
+	}
+	m.Close()
+	return openSQLite(path, "ro")
+}
+
+// openSQLite opens the database at path in one of SQLite's URI modes.
+func openSQLite(path, mode string) (*SQLiteManifest, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?mode=" + mode
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer anyway and we'd rather queue up than
+	// get SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	return &SQLiteManifest{db}, nil
+}
+
+func (m *SQLiteManifest) migrate() error {
+	var version int
+	if err := m.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(manifestMigrations) {
+		return fmt.Errorf("manifest is from a newer version of mango")
+	}
+
+	for ; version < len(manifestMigrations); version++ {
+		tx, err := m.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(manifestMigrations[version]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		// PRAGMA doesn't take placeholders
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *SQLiteManifest) Add(e ManifestEntry) error {
+	_, err := m.db.Exec(`INSERT OR REPLACE INTO chapters
+		(source, series, chapter, path, hash, pages, scanlator, uploaded, downloaded, missing, cid,
+			saved, title, variant, oneshot, language)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Source, e.Series, e.Chapter, e.Path, e.Hash, e.Pages, e.Group,
+		sql.NullTime{Time: e.Uploaded, Valid: !e.Uploaded.IsZero()}, e.Downloaded.UTC(),
+		joinInts(e.Missing), e.CID, e.Saved, e.Title, e.Variant, e.Oneshot, e.Language)
+	return err
+}
+
+func (m *SQLiteManifest) Entries(series string) ([]ManifestEntry, error) {
+	query := `SELECT source, series, chapter, path, hash, pages, scanlator, uploaded, downloaded,
+		missing, cid, saved, title, variant, oneshot, language FROM chapters`
+	args := []interface{}{}
+	if series != "" {
+		query += ` WHERE series = ?`
+		args = append(args, series)
+	}
+	query += ` ORDER BY series, chapter`
+
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ManifestEntry
+	for rows.Next() {
+		var e ManifestEntry
+		var uploaded sql.NullTime
+		var missing string
+		if err := rows.Scan(&e.Source, &e.Series, &e.Chapter, &e.Path, &e.Hash, &e.Pages,
+			&e.Group, &uploaded, &e.Downloaded, &missing, &e.CID, &e.Saved,
+			&e.Title, &e.Variant, &e.Oneshot, &e.Language); err != nil {
+			return nil, err
+		}
+		e.Uploaded = uploaded.Time
+		e.Missing = splitInts(missing)
+		entries = append(entries, e)
+	}
+	// SQLite can only sort the chapters as strings
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Series != entries[j].Series {
+			return entries[i].Series < entries[j].Series
+		}
+		return chapterLess(entries[i].Chapter, entries[j].Chapter)
+	})
+	return entries, rows.Err()
+}
+
+func (m *SQLiteManifest) Remove(path string) error {
+	_, err := m.db.Exec(`DELETE FROM chapters WHERE path = ?`, path)
+	return err
+}
+
+func (m *SQLiteManifest) Close() error {
+	return m.db.Close()
+}
+
+// joinInts and splitInts store lists of page numbers in a column.
+func joinInts(ns []int) string {
+	s := make([]string, len(ns))
+	for i, n := range ns {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+func splitInts(s string) []int {
+	var ns []int
+	for _, x := range strings.Split(s, ",") {
+		if n, err := strconv.Atoi(x); err == nil {
+			ns = append(ns, n)
+		}
+	}
+	return ns
+}
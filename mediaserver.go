@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A MediaServer is a library manager (Komga, Kavita and the like) that wants
+// to hear about new downloads.
+type MediaServer interface {
+	Name() string
+	// Scan makes the server look for new files; dirs are the series
+	// directories that have changed, for servers that can scan just those.
+	Scan(dirs []string) error
+	// UpdateSeries pushes the metadata of a series to the server; it fails
+	// with errNotScanned if the server doesn't have the series yet.
+	UpdateSeries(info Metadata) error
+}
+
+var errNotScanned = errors.New("not in the library yet")
+
+// MediaServerConfig is how a MediaServer is set up in the config.
+type MediaServerConfig struct {
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	APIKey   string `json:"apiKey,omitempty"`
+	// Library is the ID of the server's library the output directory is in.
+	Library string `json:"library"`
+	// Root is where the output directory is as the server sees it, if
+	// that's different.
+	Root string `json:"root,omitempty"`
+	// Metadata is whether to push series metadata as well.
+	Metadata bool `json:"metadata,omitempty"`
+}
+
+// remotePath translates a local path in the output directory to the server's
+// view of it.
+func (c MediaServerConfig) remotePath(local string) string {
+	if c.Root == "" {
+		abs, _ := filepath.Abs(local)
+		return abs
+	}
+	rel, err := filepath.Rel(".", local)
+	if err != nil {
+		return local
+	}
+	return path.Join(c.Root, filepath.ToSlash(rel))
+}
+
+// request makes an API call to the server at path, sending body and decoding
+// the response into v, if they're not nil; auth adds the login to it.
+func (c MediaServerConfig) request(method, path string, body, v interface{}, auth func(*http.Request)) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(c.URL, "/")+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth != nil {
+		auth(req)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		// without the query, which may have an API key in it
+		return fmt.Errorf("%s %s: %d", method, req.URL.Path, resp.StatusCode)
+	}
+	if v != nil {
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+	return nil
+}
+
+// mediaServerDelay is how long MediaServerObserver waits for more chapters
+// before asking for a scan, so that a batch of downloads causes only one.
+const mediaServerDelay = 30 * time.Second
+
+// The scans are done in the background, so new series are looked for every
+// so often for a while after asking for one, to push their metadata.
+const (
+	mediaServerScanWait = 2 * time.Minute
+	mediaServerRetry    = 5 * time.Second
+)
+
+// MediaServerObserver tells MediaServers about finished chapters.
+type MediaServerObserver struct {
+	servers []MediaServer
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending map[string]Metadata // by series
+	dirs    map[string]bool
+}
+
+func NewMediaServerObserver(servers ...MediaServer) *MediaServerObserver {
+	return &MediaServerObserver{
+		servers: servers,
+		pending: map[string]Metadata{},
+		dirs:    map[string]bool{},
+	}
+}
+
+func (o *MediaServerObserver) OnPageEnd(info Metadata) {}
+
+func (o *MediaServerObserver) OnChapterEnd(info Metadata) {
+	path, ok := info["path"].(string)
+	if !ok {
+		// nothing was saved
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if manga, ok := info["manga"].(string); ok {
+		o.pending[manga] = info
+	}
+	o.dirs[filepath.Dir(path)] = true
+	if o.timer == nil {
+		o.timer = time.AfterFunc(mediaServerDelay, o.Flush)
+	}
+}
+
+// Flush notifies the servers right away of whatever is pending.
+func (o *MediaServerObserver) Flush() {
+	o.mu.Lock()
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	pending := o.pending
+	o.pending = map[string]Metadata{}
+	var dirs []string
+	for dir := range o.dirs {
+		dirs = append(dirs, dir)
+	}
+	o.dirs = map[string]bool{}
+	o.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	for _, s := range o.servers {
+		if err := s.Scan(dirs); err != nil {
+			log.Printf("%s: cannot scan library: %v", s.Name(), err)
+			continue
+		}
+		waiting := map[string]Metadata{}
+		for series, info := range pending {
+			waiting[series] = info
+		}
+		deadline := time.Now().Add(mediaServerScanWait)
+		for {
+			for series, info := range waiting {
+				err := s.UpdateSeries(info)
+				if errors.Is(err, errNotScanned) {
+					continue
+				} else if err != nil {
+					log.Printf("%s: cannot update %q: %v", s.Name(), series, err)
+				}
+				delete(waiting, series)
+			}
+			if len(waiting) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(mediaServerRetry)
+		}
+		for series := range waiting {
+			log.Printf("%s: cannot update %q: %v", s.Name(), series, errNotScanned)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["meta"] = metaCommand
+}
+
+// metaCommand works on the metadata files in existing archives.
+func metaCommand(args []string) {
+	if len(args) < 1 || args[0] != "fix" {
+		fmt.Fprintln(os.Stderr, "usage: mango meta fix [flags] [LIBRARY|ARCHIVE...]")
+		os.Exit(2)
+	}
+	metaFix(args[1:])
+}
+
+// metaFix rewrites the ComicInfo.xml and CoMet.xml of archives from what
+// they already say, the manifest and the metadata providers, leaving the
+// pages alone.
+func metaFix(args []string) {
+	fs := flag.NewFlagSet("meta fix", flag.ExitOnError)
+	manifestFormat, manifestPath := manifestFlags(fs)
+	providers := fs.String("providers", "", "look series up in these comma-separated `PROVIDERS` (default the configured ones)")
+	regenerate := fs.Bool("regenerate", false, "replace what the archives say instead of only filling in what they're missing")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango meta fix [flags] [LIBRARY|ARCHIVE...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	config, err := loadConfig(configPath())
+	if err != nil {
+		fatalln("cannot load config:", err)
+	}
+	names := config.MetadataProviders
+	if *providers != "" {
+		names = strings.Split(*providers, ",")
+	}
+	var passes MetadataPasses
+	if len(names) > 0 {
+		found, err := lookupProviders(names)
+		if err != nil {
+			fatal(err)
+		}
+		passes = append(passes, NewProviderPass(NewFetcher(4, 2), found))
+	}
+	passes = append(passes, NewOverridePass(Metadata{}, overridesDir()), NormalizePass{})
+
+	manifest, err := openManifest(*manifestFormat, *manifestPath, manifestUpdate)
+	if err != nil {
+		fatalln("cannot open manifest:", err)
+	}
+	entries := map[string]ManifestEntry{}
+	chapters := map[string]int{}
+	if manifest != nil {
+		defer manifest.Close()
+		all, err := manifest.Entries("")
+		if err != nil {
+			fatalln("cannot read manifest:", err)
+		}
+		for _, e := range all {
+			entries[filepath.Clean(e.Path)] = e
+			chapters[e.Series]++
+		}
+	}
+
+	roots := fs.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	fixed := 0
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() || !strings.EqualFold(filepath.Ext(path), ".cbz") {
+				return nil
+			}
+
+			entry, inManifest := entries[filepath.Clean(path)]
+			existing, err := archiveMetadata(path)
+			if err != nil {
+				log.Printf("%s: %s", path, err)
+				return nil
+			}
+
+			fresh := Metadata{}
+			if inManifest {
+				fresh = manifestMetadata(entry, chapters[entry.Series])
+			} else if manga, ok := existing["manga"]; ok {
+				fresh["manga"] = manga
+			}
+			passes.Apply(fresh)
+
+			info := Metadata{}
+			if *regenerate {
+				info.Update(existing)
+				info.Update(fresh)
+			} else {
+				info.Update(fresh)
+				info.Update(existing)
+			}
+			// the pages haven't changed, whatever anyone says
+			if pages, ok := existing["pageInfo"]; ok {
+				info["pageInfo"] = pages
+			}
+
+			if err := rewriteMetadata(path, info); err != nil {
+				log.Printf("%s: %s", path, err)
+				return nil
+			}
+			fixed++
+
+			// the archive isn't what was downloaded anymore
+			if inManifest && (entry.Hash != "" || entry.CID != "") {
+				// nor what was added to IPFS
+				entry.CID = ""
+				if entry.Hash, err = hashFile(path); err != nil {
+					log.Println("cannot hash chapter:", err)
+				} else if err := manifest.Add(entry); err != nil {
+					log.Println("cannot update manifest:", err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			fatal(err)
+		}
+	}
+	fmt.Printf("fixed %d archives\n", fixed)
+}
+
+// archiveMetadata reads the ComicInfo.xml in an archive, and the reading
+// direction, which only CoMet.xml has; an archive without them has nothing
+// to say.
+func archiveMetadata(path string) (Metadata, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	info := Metadata{}
+	for _, f := range archive.File {
+		switch f.Name {
+		case "ComicInfo.xml":
+			r, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			comicInfo, err := parseComicInfo(r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+			info.Update(comicInfo)
+		case "CoMet.xml":
+			r, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			var comet struct {
+				ReadingDirection string `xml:"readingDirection"`
+			}
+			err = xml.NewDecoder(r).Decode(&comet)
+			r.Close()
+			if err == nil && comet.ReadingDirection != "" {
+				info["readingDirection"] = comet.ReadingDirection
+			}
+		}
+	}
+	return info, nil
+}
+
+// rewriteMetadata replaces the metadata files of the archive at path with
+// ones made from info.  The pages are copied over as they are, without
+// compressing them again.
+func rewriteMetadata(path string, info Metadata) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	tmpzip, err := os.CreateTemp(filepath.Dir(path), ".mango-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpzip.Chmod(0660)
+	fail := func(err error) error {
+		tmpzip.Close()
+		os.Remove(tmpzip.Name())
+		return err
+	}
+
+	w := zip.NewWriter(tmpzip)
+	for _, f := range archive.File {
+		if f.Name == "ComicInfo.xml" || f.Name == "CoMet.xml" {
+			continue
+		}
+		if err := w.Copy(f); err != nil {
+			return fail(err)
+		}
+	}
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"ComicInfo.xml", comicInfo(info)},
+		{"CoMet.xml", coMet(info)},
+	}
+	for _, f := range files {
+		out, err := w.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return fail(err)
+		}
+		if err := xml.NewEncoder(out).Encode(f.v); err != nil {
+			return fail(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fail(err)
+	}
+	if err := tmpzip.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tmpzip.Close(); err != nil {
+		os.Remove(tmpzip.Name())
+		return err
+	}
+	return moveFile(tmpzip.Name(), path)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// A MetadataPass adjusts the metadata of a chapter after it's been scraped and
+// before anything is downloaded or saved.
+type MetadataPass interface {
+	Apply(info Metadata)
+}
+
+// MetadataPasses applies each of its passes in order.
+type MetadataPasses []MetadataPass
+
+func (p MetadataPasses) Apply(info Metadata) {
+	for _, x := range p {
+		x.Apply(info)
+	}
+}
+
+// NormalizePass maps the different vocabularies sites (and metadata
+// providers) use for status, genres and reading direction onto one canonical
+// set, so that metadata files and rules don't have to know them all.
+type NormalizePass struct{}
+
+// Lowercased status, as found in the wild, to the canonical one.
+var canonicalStatus = map[string]string{
+	"ongoing":      "Ongoing",
+	"publishing":   "Ongoing",
+	"releasing":    "Ongoing",
+	"in corso":     "Ongoing", // mangaeden.com/it
+	"completed":    "Completed",
+	"complete":     "Completed",
+	"finished":     "Completed",
+	"ended":        "Completed",
+	"completato":   "Completed",
+	"concluso":     "Completed",
+	"hiatus":       "Hiatus",
+	"on hiatus":    "Hiatus",
+	"in pausa":     "Hiatus",
+	"sospeso":      "Hiatus",
+	"cancelled":    "Cancelled",
+	"canceled":     "Cancelled",
+	"discontinued": "Cancelled",
+}
+
+// Lowercased genre to the canonical one; anything not in here is just
+// title-cased.
+var canonicalGenres = map[string]string{
+	"sci-fi":           "Sci-Fi",
+	"sci fi":           "Sci-Fi",
+	"scifi":            "Sci-Fi",
+	"science fiction":  "Sci-Fi",
+	"shonen":           "Shounen",
+	"shojo":            "Shoujo",
+	"slice of life":    "Slice of Life",
+	"one shot":         "One-Shot",
+	"oneshot":          "One-Shot",
+	"one-shot":         "One-Shot",
+	"azione":           "Action", // mangaeden.com/it
+	"avventura":        "Adventure",
+	"commedia":         "Comedy",
+	"drammatico":       "Drama",
+	"fantascienza":     "Sci-Fi",
+	"sentimentale":     "Romance",
+	"romantico":        "Romance",
+	"scolastico":       "School Life",
+	"soprannaturale":   "Supernatural",
+	"storico":          "Historical",
+	"psicologico":      "Psychological",
+	"mistero":          "Mystery",
+	"sportivo":         "Sports",
+	"vita quotidiana":  "Slice of Life",
+	"arti marziali":    "Martial Arts",
+	"tragico":          "Tragedy",
+	"demenziale":       "Comedy",
+	"maturo":           "Mature",
+	"per adulti":       "Adult",
+	"webtoons":         "Webtoon",
+	"boys love":        "Boys' Love",
+	"girls love":       "Girls' Love",
+	"4-koma":           "4-Koma",
+	"yonkoma":          "4-Koma",
+	"4 koma":           "4-Koma",
+	"self-published":   "Self-Published",
+	"post-apocalyptic": "Post-Apocalyptic",
+}
+
+// Genres that mark a series as being for adults only.
+var adultGenres = map[string]bool{
+	"Adult":  true,
+	"Hentai": true,
+	"Mature": true,
+	"Smut":   true,
+}
+
+// Lowercased reading directions to "ltr" or "rtl".
+var canonicalReadingDirection = map[string]string{
+	"rtl":           "rtl",
+	"r2l":           "rtl",
+	"right to left": "rtl",
+	"right-to-left": "rtl",
+	"ltr":           "ltr",
+	"l2r":           "ltr",
+	"left to right": "ltr",
+	"left-to-right": "ltr",
+}
+
+func (NormalizePass) Apply(info Metadata) {
+	if status, ok := info["status"].(string); ok {
+		key := strings.ToLower(strings.TrimSpace(status))
+		if canonical, ok := canonicalStatus[key]; ok {
+			info["status"] = canonical
+		} else {
+			info["status"] = strings.TrimSpace(status)
+		}
+	}
+
+	if genres, ok := info["genres"].([]string); ok {
+		seen := map[string]bool{}
+		normalized := []string{}
+		for _, g := range genres {
+			g = normalizeGenre(g)
+			if g != "" && !seen[g] {
+				seen[g] = true
+				normalized = append(normalized, g)
+			}
+		}
+		info["genres"] = normalized
+
+		// sites rarely say so any other way
+		if _, ok := info["adult"]; !ok {
+			for _, g := range normalized {
+				if adultGenres[g] {
+					info["adult"] = true
+					break
+				}
+			}
+		}
+	}
+
+	if dir, ok := info["readingDirection"].(string); ok {
+		key := strings.ToLower(strings.TrimSpace(dir))
+		if canonical, ok := canonicalReadingDirection[key]; ok {
+			info["readingDirection"] = canonical
+		} else {
+			info["readingDirection"] = "ltr"
+		}
+	}
+}
+
+func normalizeGenre(g string) string {
+	g = strings.Join(strings.Fields(g), " ")
+	key := strings.ToLower(strings.Replace(g, "_", " ", -1))
+	if canonical, ok := canonicalGenres[key]; ok {
+		return canonical
+	}
+
+	words := strings.Fields(key)
+	for i, w := range words {
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		words[i] = string(r)
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// Metrics counts what mango's been doing, for Prometheus to scrape when it
+// runs unattended.  A nil *Metrics counts nothing.
+type Metrics struct {
+	requests      atomic.Int64
+	requestErrors atomic.Int64
+	bytes         atomic.Int64
+	queued        atomic.Int64
+	inFlight      atomic.Int64
+	pages         atomic.Int64
+	chapters      atomic.Int64
+	runs          atomic.Int64
+}
+
+func (m *Metrics) OnChapterEnd(info Metadata) {
+	if m != nil {
+		m.chapters.Add(1)
+	}
+}
+
+func (m *Metrics) OnPageEnd(info Metadata) {
+	if m != nil {
+		m.pages.Add(1)
+	}
+}
+
+// queue is called when a request starts waiting for a connection; the
+// function it returns when it gets one.
+func (m *Metrics) queue() (dequeue func()) {
+	if m == nil {
+		return func() {}
+	}
+	m.queued.Add(1)
+	return func() { m.queued.Add(-1) }
+}
+
+// request tracks a request from when it's sent; done is called with its
+// outcome.
+func (m *Metrics) request() (done func(err error)) {
+	if m == nil {
+		return func(error) {}
+	}
+	m.requests.Add(1)
+	m.inFlight.Add(1)
+	return func(err error) {
+		m.inFlight.Add(-1)
+		if err != nil {
+			m.requestErrors.Add(1)
+		}
+	}
+}
+
+// countBody counts the bytes of a response body as they're read.
+func (m *Metrics) countBody(body io.ReadCloser) io.ReadCloser {
+	if m == nil {
+		return body
+	}
+	return countingReader{body, &m.bytes}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// ServeHTTP writes the metrics in Prometheus' text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	metrics := []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"mango_http_requests_total", "counter", "HTTP requests made.", m.requests.Load()},
+		{"mango_http_request_errors_total", "counter", "HTTP requests that failed or got an error status.", m.requestErrors.Load()},
+		{"mango_http_response_bytes_total", "counter", "Bytes of response bodies downloaded.", m.bytes.Load()},
+		{"mango_http_requests_queued", "gauge", "Requests waiting for a free connection.", m.queued.Load()},
+		{"mango_http_requests_in_flight", "gauge", "Requests currently in progress.", m.inFlight.Load()},
+		{"mango_pages_downloaded_total", "counter", "Pages downloaded.", m.pages.Load()},
+		{"mango_chapters_downloaded_total", "counter", "Chapters downloaded.", m.chapters.Load()},
+		{"mango_runs_total", "counter", "Times the tracked series were checked.", m.runs.Load()},
+	}
+	for _, x := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", x.name, x.help, x.name, x.kind, x.name, x.value)
+	}
+}
+
+// serveMetrics exposes m under /metrics.
+func serveMetrics(addr string, m *Metrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() {
+		log.Println("metrics server:", http.ListenAndServe(addr, mux))
+	}()
+}
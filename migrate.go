@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	commands["migrate"] = migrateCommand
+}
+
+// migrateCommand moves every chapter in the manifest to where a new naming
+// template would have put it, updating the manifest as it goes.
+func migrateCommand(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	manifestFormat, manifestPath := manifestFlags(fs)
+	nameTemplate := fs.String("template", "", "the new naming `TEMPLATE`")
+	sanitizeStyle := fs.String("sanitize", "replace", "make names filesystem-safe by replacing unsafe characters with underscores, unicode lookalikes or stripping them")
+	dryRun := fs.Bool("dry-run", false, "only show what would be renamed")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango migrate -template TEMPLATE [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *nameTemplate == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	naming, err := ParseNameTemplate(*nameTemplate)
+	if err != nil {
+		fatalln("invalid template:", err)
+	}
+	naming.Sanitizer = Sanitizer{*sanitizeStyle}
+	if err := naming.Sanitizer.Validate(); err != nil {
+		fatal(err)
+	}
+
+	manifest, err := openManifest(*manifestFormat, *manifestPath, manifestUpdate)
+	if err != nil {
+		fatalln("cannot open manifest:", err)
+	} else if manifest == nil {
+		fatal("cannot migrate without a manifest")
+	}
+	defer manifest.Close()
+
+	entries, err := manifest.Entries("")
+	if err != nil {
+		fatalln("cannot read manifest:", err)
+	}
+
+	chapters := map[string]int{}
+	numbers := map[string][]string{}
+	for _, e := range entries {
+		chapters[e.Series]++
+		numbers[e.Series] = append(numbers[e.Series], e.Chapter)
+	}
+
+	failed := 0
+	for _, e := range entries {
+		info := manifestMetadata(e, chapters[e.Series])
+		info["maxChapter"] = maxChapter(numbers[e.Series])
+		newPath, err := naming.Name(info)
+		if err != nil {
+			fatalln("cannot name chapter:", err)
+		}
+		if !isDir(e.Path) {
+			newPath += filepath.Ext(e.Path)
+		}
+		if newPath == e.Path {
+			continue
+		}
+
+		fmt.Printf("%s -> %s\n", e.Path, newPath)
+		if *dryRun {
+			continue
+		}
+
+		if isFile(newPath) || isDir(newPath) {
+			log.Printf("not overwriting %s", newPath)
+			continue
+		}
+		os.MkdirAll(filepath.Dir(newPath), os.ModeDir|0770)
+		move := moveFile
+		if isDir(e.Path) {
+			move = moveDir
+		}
+		// the rest of the library can still be moved
+		if err := move(e.Path, newPath); err != nil {
+			log.Printf("cannot move %s: %s", e.Path, err)
+			failed++
+			continue
+		}
+
+		oldPath := e.Path
+		if err := manifest.Remove(oldPath); err != nil {
+			fatalln("cannot update manifest:", err)
+		}
+		e.Path = newPath
+		if err := manifest.Add(e); err != nil {
+			fatalln("cannot update manifest:", err)
+		}
+
+		// Get rid of the old series directory if that was the last of it
+		leaveSeriesDir(filepath.Dir(oldPath), filepath.Dir(newPath))
+	}
+
+	if failed > 0 {
+		log.Printf("%d chapters could not be moved", failed)
+		os.Exit(1)
+	}
+}
+
+// isSeriesFile tells what mango keeps in a series' directory besides the
+// chapters.
+func isSeriesFile(name string) bool {
+	return name == "series.json" || name == ".mango.lock" || strings.HasPrefix(name, "cover.")
+}
+
+// leaveSeriesDir removes dir once there are no chapters left in it, taking
+// series.json and the cover along to newDir, unless it has its own.
+func leaveSeriesDir(dir, newDir string) {
+	if dir == newDir {
+		return
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if !isSeriesFile(f.Name()) {
+			return
+		}
+	}
+
+	for _, f := range files {
+		path := filepath.Join(dir, f.Name())
+		if f.Name() == ".mango.lock" {
+			// it may be another mango's, downloading more of the
+			// series right now
+			if lock, err := lockFile(path); err == nil {
+				os.Remove(path)
+				lock.Close()
+			}
+			continue
+		}
+		dst := filepath.Join(newDir, f.Name())
+		if isFile(dst) {
+			os.Remove(path)
+		} else if err := moveFile(path, dst); err != nil {
+			log.Printf("cannot move %s: %s", path, err)
+		}
+	}
+	os.Remove(dir)
+}
+
+// manifestMetadata recovers as much Metadata as the manifest knows about a
+// chapter; chapters is the number of chapters in its series.
+func manifestMetadata(e ManifestEntry, chapters int) Metadata {
+	info := Metadata{
+		"manga":    e.Series,
+		"chapter":  e.Chapter,
+		"chapters": chapters,
+		"pages":    e.Pages,
+		"url":      e.Source,
+	}
+	info["chapter"] = chapterMetadata(e.Chapter)
+	if e.Title != "" {
+		info["chapterName"] = e.Title
+	}
+	if e.Variant != "" {
+		info["variant"] = e.Variant
+	}
+	if e.Oneshot {
+		info["oneshot"] = true
+	}
+	if e.Language != "" {
+		info["language"] = e.Language
+	}
+	if e.Group != "" {
+		info["group"] = e.Group
+	}
+	if !e.Uploaded.IsZero() {
+		info["uploaded"] = e.Uploaded
+	}
+	if len(e.Missing) > 0 {
+		info["missingPages"] = e.Missing
+	}
+	return info
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// The default layout of the library; one directory per series and one
+// archive (or directory) per chapter, named after its zero-padded number and
+// its title, if it has one.
+const defaultNameTemplate = "{{.manga}}/{{.number}}{{with .title}} - {{.}}{{end}}"
+
+// Titles longer than this are cut short; some chapters have a paragraph for
+// a title and paths can only be so long.
+const maxTitleLength = 80
+
+var defaultNaming = MustParseNameTemplate(defaultNameTemplate)
+
+// NameTemplate decides where a chapter goes, relative to the library.  It's a
+// text/template executed on the chapter's Metadata, plus a few extras (see
+// nameData); savers add their own extension.  The values are sanitized before
+// they go in, so a "/" in a title doesn't turn into a directory.
+type NameTemplate struct {
+	tmpl      *template.Template
+	Sanitizer Sanitizer
+}
+
+func ParseNameTemplate(s string) (NameTemplate, error) {
+	tmpl, err := template.New("name").Option("missingkey=zero").Funcs(template.FuncMap{
+		"truncate": truncate,
+	}).Parse(s)
+	return NameTemplate{tmpl: tmpl}, err
+}
+
+func MustParseNameTemplate(s string) NameTemplate {
+	t, err := ParseNameTemplate(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func (t NameTemplate) Name(info Metadata) (string, error) {
+	tmpl := t.tmpl
+	if tmpl == nil {
+		tmpl = defaultNaming.tmpl
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, t.sanitize(nameData(info))); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (t NameTemplate) sanitize(data Metadata) Metadata {
+	for k, v := range data {
+		if s, ok := v.(string); ok && s != "" {
+			data[k] = t.Sanitizer.Sanitize(s)
+		}
+	}
+	return data
+}
+
+// truncate cuts s down to n characters, on a word boundary if there's one
+// close enough.
+func truncate(n int, s string) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+
+	cut := string(r[:n])
+	if i := strings.LastIndex(cut, " "); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimSpace(cut) + "…"
+}
+
+// nameData adds the variables that only make sense in names to info:
+//
+//	number: the chapter number, zero-padded to the width of the highest one
+//	        (or of the chapter count, if that's not known), followed by the
+//	        group in brackets if the chapter has more than one release
+//	title:  the chapter's name, cut short if it's too long
+//
+// One-shots have no number and are named "<series> (Oneshot)" instead.
+func nameData(info Metadata) Metadata {
+	data := Metadata{}
+	data.Update(info)
+
+	title, _ := info["chapterName"].(string)
+	data["title"] = truncate(maxTitleLength, strings.TrimSpace(title))
+
+	width := 0
+	if highest, ok := info["maxChapter"].(int); ok && highest > 0 {
+		width = len(strconv.Itoa(highest))
+	} else if chapters, ok := info["chapters"].(int); ok {
+		width = len(strconv.Itoa(chapters))
+	}
+	switch chapter := info["chapter"].(type) {
+	case ChapterNumber:
+		data["number"] = chapter.Pad(width)
+	case nil:
+		data["number"] = ""
+	default:
+		data["number"] = fmt.Sprint(chapter)
+	}
+	if oneshot, _ := info["oneshot"].(bool); oneshot {
+		data["number"] = fmt.Sprintf("%v (Oneshot)", info["manga"])
+		data["title"] = ""
+	}
+	if variant, ok := info["variant"].(string); ok && variant != "" {
+		data["number"] = fmt.Sprintf("%s [%s]", data["number"], variant)
+	}
+	return data
+}
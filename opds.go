@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OPDSServer serves a library as an OPDS catalog, with OPDS-PSE page streaming
+// for readers that would rather not download whole archives.
+//
+//	/opds                the series
+//	/opds/series/NAME    the chapters of a series
+//	/opds/files/PATH     an archive
+//	/opds/pse/PATH?page= a page of an archive
+type OPDSServer struct {
+	Root string
+}
+
+type atomLink struct {
+	Rel      string `xml:"rel,attr,omitempty"`
+	Type     string `xml:"type,attr,omitempty"`
+	Href     string `xml:"href,attr"`
+	PSECount int    `xml:"http://vaemendis.net/opds-pse/ns count,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Content string     `xml:"content,omitempty"`
+	Links   []atomLink `xml:"link"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+const (
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	cbzType             = "application/vnd.comicbook+zip"
+)
+
+func (s OPDSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/opds")
+	switch {
+	case p == "" || p == "/":
+		s.serveRoot(w, r)
+	case strings.HasPrefix(p, "/series/"):
+		s.serveSeries(w, r, strings.TrimPrefix(p, "/series/"))
+	case strings.HasPrefix(p, "/files/"):
+		w.Header().Set("Content-Type", cbzType)
+		http.ServeFile(w, r, libraryPath(s.Root, strings.TrimPrefix(p, "/files/")))
+	case strings.HasPrefix(p, "/pse/"):
+		s.servePage(w, r, strings.TrimPrefix(p, "/pse/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s OPDSServer) writeFeed(w http.ResponseWriter, contentType string, feed atomFeed) {
+	w.Header().Set("Content-Type", contentType)
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(feed)
+}
+
+func (s OPDSServer) serveRoot(w http.ResponseWriter, r *http.Request) {
+	library, err := scanLibrary(s.Root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	feed := atomFeed{
+		ID:      "urn:mango:root",
+		Title:   "mango",
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: opdsNavigationType, Href: "/opds"},
+			{Rel: "start", Type: opdsNavigationType, Href: "/opds"},
+		},
+	}
+	for _, series := range library {
+		updated := series.Chapters[len(series.Chapters)-1].ModTime
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   series.Name,
+			ID:      "urn:mango:series:" + url.PathEscape(series.Name),
+			Updated: updated.UTC().Format(time.RFC3339),
+			Content: fmt.Sprintf("%d chapters", len(series.Chapters)),
+			Links: []atomLink{{
+				Rel:  "subsection",
+				Type: opdsAcquisitionType,
+				Href: "/opds/series/" + url.PathEscape(series.Name),
+			}},
+		})
+	}
+	s.writeFeed(w, opdsNavigationType, feed)
+}
+
+func (s OPDSServer) serveSeries(w http.ResponseWriter, r *http.Request, name string) {
+	chapters, err := scanSeries(s.Root, path.Base(path.Clean("/"+name)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	feed := atomFeed{
+		ID:      "urn:mango:series:" + url.PathEscape(name),
+		Title:   name,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: opdsAcquisitionType, Href: r.URL.EscapedPath()},
+			{Rel: "start", Type: opdsNavigationType, Href: "/opds"},
+			{Rel: "up", Type: opdsNavigationType, Href: "/opds"},
+		},
+	}
+	for _, c := range chapters {
+		escaped := (&url.URL{Path: c.Path}).EscapedPath()
+		entry := atomEntry{
+			Title:   c.Name,
+			ID:      "urn:mango:chapter:" + escaped,
+			Updated: c.ModTime.UTC().Format(time.RFC3339),
+			Links: []atomLink{{
+				Rel:  "http://opds-spec.org/acquisition",
+				Type: cbzType,
+				Href: "/opds/files/" + escaped,
+			}},
+		}
+		if archive, err := zip.OpenReader(libraryPath(s.Root, c.Path)); err == nil {
+			entry.Links = append(entry.Links, atomLink{
+				Rel:      "http://vaemendis.net/opds-pse/stream",
+				Type:     "image/jpeg",
+				Href:     "/opds/pse/" + escaped + "?page={pageNumber}",
+				PSECount: len(archivePages(&archive.Reader)),
+			})
+			archive.Close()
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	s.writeFeed(w, opdsAcquisitionType, feed)
+}
+
+func (s OPDSServer) servePage(w http.ResponseWriter, r *http.Request, p string) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		http.Error(w, "bad page number", http.StatusBadRequest)
+		return
+	}
+	serveArchivePage(w, r, libraryPath(s.Root, p), page)
+}
+
+// serveArchivePage serves the n-th (zero-based) page of an archive.
+func serveArchivePage(w http.ResponseWriter, r *http.Request, archivename string, n int) {
+	archive, err := zip.OpenReader(archivename)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer archive.Close()
+
+	pages := archivePages(&archive.Reader)
+	if n < 0 || n >= len(pages) {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := pages[n].Open()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(pages[n].Name)))
+	w.Header().Set("Content-Length", strconv.FormatUint(pages[n].UncompressedSize64, 10))
+	io.Copy(w, f)
+}
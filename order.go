@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// naturalLess orders names the way people would, with the numbers in them
+// compared as numbers: "2.jpg" comes before "10.jpg".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := isDigit(a[0]), isDigit(b[0])
+		if da != db || !da {
+			if a[0] != b[0] {
+				return a[0] < b[0]
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		na, nb := leadingDigits(a), leadingDigits(b)
+		ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+		if len(ta) != len(tb) {
+			return len(ta) < len(tb)
+		}
+		if ta != tb {
+			return ta < tb
+		}
+		// the same number, the fewer zeros first
+		if len(na) != len(nb) {
+			return len(na) < len(nb)
+		}
+		a, b = a[len(na):], b[len(nb):]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+// sortNatural sorts names in natural order.
+func sortNatural(names []string) {
+	sort.SliceStable(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+}
+
+// pageOrderProblems tells what's wrong with the order of the pages named, as
+// they're stored in an archive: readers go by either that or the names sorted
+// as text, and both should agree with the natural order.
+func pageOrderProblems(names []string) (problems []string) {
+	natural := append([]string(nil), names...)
+	sortNatural(natural)
+	lexical := append([]string(nil), names...)
+	sort.Strings(lexical)
+	for i := range natural {
+		if lexical[i] != natural[i] {
+			problems = append(problems, fmt.Sprintf("pages out of order for readers that sort them by name (%s before %s)",
+				lexical[i], natural[i]))
+			break
+		}
+	}
+	for i := range natural {
+		if names[i] != natural[i] {
+			problems = append(problems, fmt.Sprintf("pages stored out of order (%s before %s)", names[i], natural[i]))
+			break
+		}
+	}
+	return
+}
+
+// the last number in a page's name, which is what it's numbered by
+var pageNumberRe = regexp.MustCompile(`^(.*?)([0-9]+)([^0-9]*)$`)
+
+// padPageNames zero-pads the numbers in the names of the pages in dir to the
+// same width, so that sorting them as text puts them in order too.
+func padPageNames(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	width := 0
+	for _, f := range files {
+		if f.IsDir() || !isPageEntry(f.Name()) || strings.HasSuffix(f.Name(), ".part") {
+			continue
+		}
+		stem := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		if m := pageNumberRe.FindStringSubmatch(stem); m != nil {
+			names = append(names, f.Name())
+			width = max(width, len(m[2]))
+		}
+	}
+	for _, name := range names {
+		ext := filepath.Ext(name)
+		m := pageNumberRe.FindStringSubmatch(strings.TrimSuffix(name, ext))
+		if len(m[2]) == width {
+			continue
+		}
+		padded := m[1] + strings.Repeat("0", width-len(m[2])) + m[2] + m[3] + ext
+		if _, err := os.Stat(filepath.Join(dir, padded)); err == nil {
+			return fmt.Errorf("cannot rename %s to %s, which is there already", name, padded)
+		}
+		if err := os.Rename(filepath.Join(dir, name), filepath.Join(dir, padded)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OverridePass replaces scraped metadata with the user's own.  Overrides come
+// from the command line, for everything being downloaded, and from per-series
+// files in Dir; the latter win.
+//
+// A series' override file is a JSON object with the same keys the scrapers
+// use (author, artist, language, readingDirection, genres, ...), named after
+// the series' title lowercased and with everything but letters and digits
+// removed, e.g. "onepiece.json" for "One Piece".
+type OverridePass struct {
+	Flags Metadata
+	Dir   string
+
+	mu     sync.Mutex
+	series map[string]Metadata
+}
+
+func NewOverridePass(flags Metadata, dir string) *OverridePass {
+	return &OverridePass{Flags: flags, Dir: dir, series: map[string]Metadata{}}
+}
+
+func (p *OverridePass) Apply(info Metadata) {
+	info.Update(p.Flags)
+	if overrides := p.lookup(fmt.Sprint(info["manga"])); overrides != nil {
+		info.Update(overrides)
+	}
+}
+
+func (p *OverridePass) lookup(manga string) Metadata {
+	if p.Dir == "" {
+		return nil
+	}
+	key := seriesKey(manga)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if overrides, ok := p.series[key]; ok {
+		return overrides
+	}
+	overrides, err := loadOverrides(filepath.Join(p.Dir, key+".json"))
+	if err != nil {
+		log.Printf("cannot load overrides for %s: %s", manga, err)
+	}
+	p.series[key] = overrides
+	return overrides
+}
+
+// overridesDir returns where the per-series override files go, next to the
+// configuration file.
+func overridesDir() string {
+	path := configPath()
+	if path == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(path), "overrides")
+}
+
+func loadOverrides(path string) (Metadata, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	overrides := Metadata{}
+	for k, v := range raw {
+		if overrides[k], err = overrideValue(k, v); err != nil {
+			return nil, err
+		}
+	}
+	return overrides, nil
+}
+
+// overrideValue turns a value decoded from JSON into the type the rest of
+// mango expects for key.
+func overrideValue(key string, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case float64:
+		if v != float64(int(v)) {
+			return nil, fmt.Errorf("%s: %v is not a whole number", key, v)
+		}
+		return int(v), nil
+	case []interface{}:
+		list := make([]string, len(v))
+		for i, x := range v {
+			s, ok := x.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: %v is not a string", key, x)
+			}
+			list[i] = s
+		}
+		return list, nil
+	case string, bool:
+		return v, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported value %v", key, v)
+}
+
+// overrideFlag collects -set KEY=VALUE flags into a Metadata.
+type overrideFlag Metadata
+
+func (f overrideFlag) String() string {
+	return fmt.Sprint(Metadata(f))
+}
+
+func (f overrideFlag) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected KEY=VALUE, got %q", s)
+	}
+
+	switch key {
+	case "genres":
+		var genres []string
+		for _, g := range strings.Split(value, ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				genres = append(genres, g)
+			}
+		}
+		f[key] = genres
+	case "year", "pages":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", key, value)
+		}
+		f[key] = n
+	case "adult":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not true or false", key, value)
+		}
+		f[key] = b
+	default:
+		f[key] = value
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	commands["pack"] = packCommand
+}
+
+// packCommand turns directories of pages, like the ones PageSaver leaves, into
+// archives named and tagged the way a download would have made them.
+func packCommand(args []string) {
+	fs := flag.NewFlagSet("pack", flag.ExitOnError)
+	nameTemplate := fs.String("template", defaultNameTemplate, "where to put chapters, as a Go `TEMPLATE`")
+	sanitizeStyle := fs.String("sanitize", "replace", "make names filesystem-safe by replacing unsafe characters with underscores, unicode lookalikes or stripping them")
+	output := fs.String("o", ".", "put the archives in the library at `DIR`")
+	series := fs.String("series", "", "the `NAME` of the series, instead of the name of the directory the chapters are in")
+	remove := fs.Bool("remove", false, "remove the page directories once they're packed")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango pack [flags] DIR...")
+		fmt.Fprintln(os.Stderr, "Every directory of images under DIR becomes a chapter.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	naming, err := ParseNameTemplate(*nameTemplate)
+	if err != nil {
+		fatalln("invalid template:", err)
+	}
+	naming.Sanitizer = Sanitizer{*sanitizeStyle}
+	if err := naming.Sanitizer.Validate(); err != nil {
+		fatal(err)
+	}
+
+	var dirs []string
+	for _, root := range fs.Args() {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// a series directory can have pages of its own, like a cover,
+			// but it's not a chapter
+			if info.IsDir() && hasPages(path) && !hasChapters(path) {
+				dirs = append(dirs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	// the chapters in the same directory are a series; their numbers decide
+	// the padding
+	infos := make([]Metadata, len(dirs))
+	numbers := map[string][]string{}
+	for i, dir := range dirs {
+		infos[i] = packMetadata(dir)
+		parent := filepath.Dir(filepath.Clean(dir))
+		numbers[parent] = append(numbers[parent], fmt.Sprint(infos[i]["chapter"]))
+	}
+
+	packed := 0
+	for i, dir := range dirs {
+		info := infos[i]
+		if *series != "" {
+			info["manga"] = *series
+		}
+		parent := filepath.Dir(filepath.Clean(dir))
+		info["chapters"] = len(numbers[parent])
+		info["maxChapter"] = maxChapter(numbers[parent])
+
+		name, err := naming.Name(info)
+		if err != nil {
+			fatalln("cannot name chapter:", err)
+		}
+		archivename := filepath.Join(*output, name+".cbz")
+		if isFile(archivename) {
+			log.Printf("%s: %s already exists, skipping it", dir, archivename)
+			continue
+		}
+		if err := packDir(filepath.Clean(dir), archivename, info); err != nil {
+			fatalf("%s: %s", dir, err)
+		}
+		fmt.Printf("%s -> %s\n", dir, archivename)
+		packed++
+
+		if *remove {
+			os.RemoveAll(dir)
+		}
+	}
+	fmt.Printf("packed %d chapters\n", packed)
+}
+
+// hasPages tells whether there are any images directly in dir.
+func hasPages(dir string) bool {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		if !f.IsDir() && isImageName(f.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasChapters tells whether any directory directly in dir has pages.
+func hasChapters(dir string) bool {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		if f.IsDir() && f.Name() != htmlDir && hasPages(filepath.Join(dir, f.Name())) {
+			return true
+		}
+	}
+	return false
+}
+
+func isImageName(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".avif", ".jxl":
+		return true
+	}
+	return false
+}
+
+// Chapter directories are usually named like the default template does,
+// "012 - Some Title", or at least start with the number.
+var packDirRE = regexp.MustCompile(`^(?i:ch(?:apter)?\.?\s*)?(\d+(?:\.\d+)?[a-z]?)(?:\s*-\s*(.*))?$`)
+
+// packMetadata works out what it can about the chapter in dir: from the
+// ComicInfo.xml in it, if there's one, or else from the directory names.
+func packMetadata(dir string) Metadata {
+	info := Metadata{}
+	if file, err := os.Open(filepath.Join(dir, "ComicInfo.xml")); err == nil {
+		sidecar, err := parseComicInfo(file)
+		file.Close()
+		if err != nil {
+			log.Printf("%s: cannot read ComicInfo.xml: %s", dir, err)
+		}
+		info.Update(sidecar)
+		// the pages are looked at again when packing
+		delete(info, "pageInfo")
+	}
+
+	if _, ok := info["manga"]; !ok {
+		info["manga"] = filepath.Base(filepath.Dir(filepath.Clean(dir)))
+	}
+	base := filepath.Base(filepath.Clean(dir))
+	if match := packDirRE.FindStringSubmatch(base); match != nil {
+		if _, ok := info["chapter"]; !ok {
+			info["chapter"] = chapterMetadata(match[1])
+		}
+		if match[2] != "" {
+			info["chapterName"] = match[2]
+		}
+	} else if _, ok := info["chapter"]; !ok {
+		// no number to be found, so keep the whole name
+		info["chapter"] = base
+	}
+
+	pages := 0
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		if !f.IsDir() && isImageName(f.Name()) {
+			pages++
+		}
+	}
+	info["pages"] = pages
+	return info
+}
+
+// packDir archives the pages in dir, with fresh metadata files, at
+// archivename.  The pages are copied aside first, so that dir is left as it
+// was.
+func packDir(dir, archivename string, info Metadata) error {
+	staging, err := os.MkdirTemp("", "mango-pack-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	if err := stagePages(dir, staging); err != nil {
+		return err
+	}
+
+	var saver CBZSaver
+	saver.addMetadataFiles(info, staging)
+
+	os.MkdirAll(filepath.Dir(archivename), os.ModeDir|0770)
+	tmpzip, err := os.CreateTemp(filepath.Dir(archivename), ".mango-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpzip.Chmod(0660)
+	if err := saver.writeArchive(staging, tmpzip); err != nil {
+		os.Remove(tmpzip.Name())
+		return err
+	}
+	return moveFile(tmpzip.Name(), archivename)
+}
+
+// stagePages copies the pages directly in dir, and their HTML if it was kept,
+// into staging.
+func stagePages(dir, staging string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || !isImageName(f.Name()) {
+			continue
+		}
+		if err := copyFile(filepath.Join(dir, f.Name()), filepath.Join(staging, f.Name())); err != nil {
+			return err
+		}
+	}
+	if isDir(filepath.Join(dir, htmlDir)) {
+		return copyDir(filepath.Join(dir, htmlDir), filepath.Join(staging, htmlDir))
+	}
+	return nil
+}
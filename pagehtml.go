@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// The HTML of the pages, when kept, goes in a directory of its own next to
+// the images so that nothing mistakes it for a page.
+const htmlDir = "html"
+
+// writePageHTML puts the HTML a page was found in inside the chapter being
+// put together in dir.
+func writePageHTML(dir string, info Metadata, html []byte) error {
+	pages, ok := info["pages"].(int)
+	if !ok {
+		return fmt.Errorf("page %v: unknown number of pages", info["pageIndex"])
+	}
+	name := filepath.Join(dir, htmlDir,
+		fmt.Sprintf("%0*d.html", len(strconv.Itoa(pages)), info["pageIndex"]))
+	os.MkdirAll(filepath.Dir(name), os.ModeDir|0770)
+	return os.WriteFile(name, html, 0660)
+}
+
+// isHTMLEntry tells whether an archive entry is one of the kept pages' HTML.
+func isHTMLEntry(name string) bool {
+	return path.Dir(name) == htmlDir
+}
+
+func (s PageSaver) SaveHTML(info Metadata, html []byte) error {
+	dirname, _ := s.name(info)
+	return writePageHTML(stagingName(s.staging, dirname), info, html)
+}
+
+func (s CBZSaver) SaveHTML(info Metadata, html []byte) error {
+	archivename, _ := s.name(info)
+	return writePageHTML(stagingName(s.staging, archivename), info, html)
+}
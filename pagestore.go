@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// PageStore keeps one copy of every page ever downloaded, named after its
+// SHA-256, and hardlinks chapters' pages to it.  Identical pages (covers,
+// recaps, re-releases of a chapter) then only take up space once.
+type PageStore struct {
+	Dir string
+}
+
+// Dedupe replaces the page at path with a link to the store's copy of it,
+// adding it to the store if it's new.
+func (s *PageStore) Dedupe(path string) error {
+	hash, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	stored := filepath.Join(s.Dir, hash[:2], hash+filepath.Ext(path))
+
+	if !isFile(stored) {
+		os.MkdirAll(filepath.Dir(stored), os.ModeDir|0770)
+		err := os.Link(path, stored)
+		if err == nil {
+			return nil
+		} else if !os.IsExist(err) {
+			return err
+		}
+		// someone else stored it in the meantime
+	}
+
+	if a, err := os.Stat(path); err == nil {
+		if b, err := os.Stat(stored); err == nil && os.SameFile(a, b) {
+			return nil
+		}
+	}
+
+	// Link next to path and rename over it, so that path is never missing.
+	tmpname := path + ".link"
+	os.Remove(tmpname)
+	if err := os.Link(stored, tmpname); err != nil {
+		return err
+	}
+	return os.Rename(tmpname, path)
+}
+
+// dedupe is Dedupe for savers, which may not have a store.
+func (s *PageStore) dedupe(path string) {
+	if s == nil {
+		return
+	}
+	if err := s.Dedupe(path); err != nil {
+		log.Println("cannot deduplicate page:", err)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// A pdfWriter writes the objects of a PDF one after the other and remembers
+// where they are, for the cross-reference table at the end.
+type pdfWriter struct {
+	w       *bufio.Writer
+	offset  int64
+	objects []int64
+}
+
+func (p *pdfWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return n, err
+}
+
+// object writes object number n (they have to come in order, from 1).
+func (p *pdfWriter) object(n int, dict string, stream []byte) {
+	p.objects = append(p.objects, p.offset)
+	fmt.Fprintf(p, "%d 0 obj\n%s\n", n, dict)
+	if stream != nil {
+		io.WriteString(p, "stream\n")
+		p.Write(stream)
+		io.WriteString(p, "\nendstream\n")
+	}
+	io.WriteString(p, "endobj\n")
+}
+
+func (p *pdfWriter) finish(trailer string) error {
+	xref := p.offset
+	fmt.Fprintf(p, "xref\n0 %d\n0000000000 65535 f \n", len(p.objects)+1)
+	for _, off := range p.objects {
+		fmt.Fprintf(p, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(p, "trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", len(p.objects)+1, trailer, xref)
+	return p.w.Flush()
+}
+
+// writePDF makes a PDF out of the pages in dir, each image a page of its own
+// size.  JPEGs go in as they are, everything else is decoded first.  Viewers
+// showing two pages at once keep the cover on its own and, for manga, put the
+// pages right to left.
+func writePDF(dir string, info Metadata, out *os.File) error {
+	defer out.Close()
+
+	files, err := pageFiles(dir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no pages in %s", dir)
+	}
+
+	p := &pdfWriter{w: bufio.NewWriter(out)}
+	// the binary comment tells tools it's not a text file
+	io.WriteString(p, "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	var viewer string
+	if isRightToLeft(info) {
+		viewer = " /ViewerPreferences << /Direction /R2L >>"
+	}
+	var lang string
+	if language, _ := info["language"].(string); language != "" {
+		lang = " /Lang " + pdfString(language)
+	}
+	p.object(1, "<< /Type /Catalog /Pages 2 0 R /PageLayout /TwoPageRight"+viewer+lang+" >>", nil)
+	var kids []string
+	for i := range files {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 3+3*i))
+	}
+	p.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(files)), nil)
+
+	for i, file := range files {
+		page, content, img := 3+3*i, 4+3*i, 5+3*i
+		dict, data, width, height, err := pdfImage(file)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(file), err)
+		}
+		p.object(page, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+			"/Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>", width, height, img, content), nil)
+		draw := []byte(fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q", width, height))
+		p.object(content, fmt.Sprintf("<< /Length %d >>", len(draw)), draw)
+		p.object(img, dict, data)
+	}
+
+	// after the last page's objects
+	infoObject := 3 + 3*len(files)
+	p.object(infoObject, pdfInfo(info), nil)
+	if err := p.finish(fmt.Sprintf("/Root 1 0 R /Info %d 0 R", infoObject)); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// pdfImage turns an image file into the dictionary and data of a PDF image
+// XObject.
+func pdfImage(path string) (dict string, data []byte, width, height int, err error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, 0, 0, err
+	}
+
+	// JPEGs can be used as they are, as long as the color space is one
+	// PDF understands the same way
+	config, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return "", nil, 0, 0, err
+	}
+	if format == "jpeg" && (config.ColorModel == color.YCbCrModel || config.ColorModel == color.GrayModel) {
+		space := "/DeviceRGB"
+		if config.ColorModel == color.GrayModel {
+			space = "/DeviceGray"
+		}
+		dict = fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s "+
+			"/BitsPerComponent 8 /Filter /DCTDecode /Length %d >>", config.Width, config.Height, space, len(raw))
+		return dict, raw, config.Width, config.Height, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return "", nil, 0, 0, err
+	}
+	bounds := img.Bounds()
+	gray := img.ColorModel() == color.GrayModel
+	space, components := "/DeviceRGB", 3
+	if gray {
+		space, components = "/DeviceGray", 1
+	}
+
+	var buf bytes.Buffer
+	z := zlib.NewWriter(&buf)
+	row := make([]byte, bounds.Dx()*components)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := (x - bounds.Min.X) * components
+			if gray {
+				row[i] = color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+				continue
+			}
+			// there's no transparency in print; put it on white
+			r, g, b, a := img.At(x, y).RGBA()
+			row[i] = byte((r + 0xffff - a) >> 8)
+			row[i+1] = byte((g + 0xffff - a) >> 8)
+			row[i+2] = byte((b + 0xffff - a) >> 8)
+		}
+		z.Write(row)
+	}
+	if err := z.Close(); err != nil {
+		return "", nil, 0, 0, err
+	}
+	dict = fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s "+
+		"/BitsPerComponent 8 /Filter /FlateDecode /Length %d >>", bounds.Dx(), bounds.Dy(), space, buf.Len())
+	return dict, buf.Bytes(), bounds.Dx(), bounds.Dy(), nil
+}
+
+// pdfInfo makes the document information dictionary, what viewers show as
+// the document's properties.  PDF has no notion of series, so that's kept
+// under a key of its own, the way calibre does it.
+func pdfInfo(info Metadata) string {
+	fields := []string{
+		"/Title " + pdfString(bookTitle(info)),
+		"/Series " + pdfString(fmt.Sprint(info["manga"])),
+		"/Creator (mango)",
+		"/Producer (mango)",
+		"/CreationDate " + pdfString(time.Now().UTC().Format("D:20060102150405Z")),
+	}
+	if index := seriesIndex(info["chapter"]); index != "" {
+		fields = append(fields, "/SeriesIndex "+pdfString(index))
+	}
+	var creators []string
+	for _, key := range []string{"author", "artist"} {
+		if name, _ := info[key].(string); name != "" && (len(creators) == 0 || creators[0] != name) {
+			creators = append(creators, name)
+		}
+	}
+	if len(creators) > 0 {
+		fields = append(fields, "/Author "+pdfString(strings.Join(creators, ", ")))
+	}
+	if description, _ := info["description"].(string); description != "" {
+		fields = append(fields, "/Subject "+pdfString(description))
+	}
+	if genres, ok := info["genres"].([]string); ok && len(genres) > 0 {
+		fields = append(fields, "/Keywords "+pdfString(strings.Join(genres, ", ")))
+	}
+	return "<< " + strings.Join(fields, " ") + " >>"
+}
+
+// pdfString makes s a PDF text string; anything that isn't plain ASCII has
+// to be in UTF-16.
+func pdfString(s string) string {
+	ascii := true
+	for _, r := range s {
+		if r < 0x20 || r > 0x7e {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return "(" + strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(s) + ")"
+	}
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteString(">")
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+func init() {
+	commands["phash"] = phashCommand
+}
+
+// PHash is a perceptual hash of an image (a "difference hash"); visually
+// similar images have hashes that differ in only a few bits.
+type PHash uint64
+
+func perceptualHash(img image.Image) PHash {
+	// Shrink to 9×8 and compare each pixel to its right neighbour; this
+	// captures the gradients, which survive rescaling and recompression.
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var h PHash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if small.GrayAt(x, y).Y < small.GrayAt(x+1, y).Y {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+func ParsePHash(s string) (PHash, error) {
+	h, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid perceptual hash %q", s)
+	}
+	return PHash(h), nil
+}
+
+func (h PHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Distance is the number of bits that differ between two hashes.
+func (h PHash) Distance(other PHash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+// Blacklist holds the hashes of pages nobody wants to keep (credit pages,
+// recruitment ads and such).
+type Blacklist struct {
+	Hashes      []PHash
+	MaxDistance int
+}
+
+func NewBlacklist(hashes []string, maxDistance int) (Blacklist, error) {
+	b := Blacklist{MaxDistance: maxDistance}
+	for _, s := range hashes {
+		h, err := ParsePHash(s)
+		if err != nil {
+			return b, err
+		}
+		b.Hashes = append(b.Hashes, h)
+	}
+	return b, nil
+}
+
+func (b Blacklist) Match(img image.Image) bool {
+	if len(b.Hashes) == 0 {
+		return false
+	}
+
+	h := perceptualHash(img)
+	for _, x := range b.Hashes {
+		if h.Distance(x) <= b.MaxDistance {
+			return true
+		}
+	}
+	return false
+}
+
+// phashCommand prints the hashes of the given images, ready to be pasted into
+// the blacklist in the config.
+func phashCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: mango phash IMAGE...")
+		os.Exit(2)
+	}
+
+	for _, name := range args {
+		file, err := os.Open(name)
+		if err != nil {
+			fatal(err)
+		}
+		img, _, err := image.Decode(file)
+		file.Close()
+		if err != nil {
+			fatalln(name+":", err)
+		}
+		fmt.Printf("%s  %s\n", perceptualHash(img), name)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+
+	_ "github.com/gen2brain/avif"
+	_ "golang.org/x/image/webp"
+)
+
+var errBlacklisted = errors.New("page is blacklisted")
+
+// Formats that a lot of (older) CBZ readers cannot display.
+var modernImageFormats = map[string]bool{
+	"webp": true,
+	"avif": true,
+}
+
+// A Filter transforms a decoded page before it's saved.
+type Filter interface {
+	Filter(img image.Image, info Metadata) image.Image
+}
+
+// Pipeline is a Saver that decodes each page, runs it through its Filters,
+// re-encodes it and hands the result to the wrapped Saver.  Pages that need
+// no processing at all are passed through untouched.
+type Pipeline struct {
+	Saver   Saver
+	Filters []Filter
+
+	// ConvertTo is the format ("jpg" or "png") that WebP and AVIF pages are
+	// converted to; if empty they are kept as they are.
+	ConvertTo string
+
+	// Recompress re-encodes every page as a JPEG of the given Quality.  With
+	// OnlyIfSmaller, the original is kept when that doesn't save any space.
+	Recompress    bool
+	Quality       int
+	OnlyIfSmaller bool
+
+	// Pages that look like those in the Blacklist are dropped.
+	Blacklist Blacklist
+
+	// StripEXIF removes the EXIF metadata of JPEGs, applying any orientation
+	// it specifies first.
+	StripEXIF bool
+
+	// JPEGXL transcodes every page, after everything else, to JPEG XL;
+	// those that can't be are converted to JPEGXLFallback ("jpg" or "png"),
+	// if set, or kept as they are.
+	JPEGXL         bool
+	JPEGXLFallback string
+}
+
+func (p Pipeline) Save(info Metadata, size int64) (io.WriteCloser, error) {
+	ext, _ := info["imageExtension"].(string)
+	if !p.wants(ext) {
+		// Don't get in the way of the progress bar for nothing
+		return p.Saver.Save(info, size)
+	}
+	return &pipelineWriter{pipeline: p, info: info}, nil
+}
+
+// wants reports whether pages of type ext need to go through the pipeline.
+func (p Pipeline) wants(ext string) bool {
+	return p.ConvertTo != "" && modernImageFormats[ext] ||
+		p.Recompress || len(p.Filters) > 0 || len(p.Blacklist.Hashes) > 0 ||
+		p.StripEXIF && ext == "jpg" || p.JPEGXL
+}
+
+func (p Pipeline) process(info Metadata, data []byte) ([]byte, error) {
+	ext, _ := info["imageExtension"].(string)
+	convert := p.ConvertTo != "" && modernImageFormats[ext]
+	orientation := 0
+	if p.StripEXIF && ext == "jpg" {
+		orientation = jpegOrientation(data)
+		data = stripJPEGMetadata(data)
+	}
+
+	reencode := convert || p.Recompress || len(p.Filters) > 0 || orientation > 1
+	if !reencode && len(p.Blacklist.Hashes) == 0 {
+		return data, nil
+	}
+
+	format := ext
+	switch {
+	case p.Recompress:
+		format = "jpg"
+	case convert:
+		format = p.ConvertTo
+	case !canEncode(ext):
+		// We can decode it but not encode it back
+		format = "png"
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode %s image: %v", ext, err)
+	}
+	if p.Blacklist.Match(img) {
+		return nil, errBlacklisted
+	}
+	if !reencode {
+		return data, nil
+	}
+
+	img = orient(img, orientation)
+	for _, f := range p.Filters {
+		img = f.Filter(img, info)
+	}
+
+	var buf bytes.Buffer
+	if err := p.encode(&buf, img, format); err != nil {
+		return nil, fmt.Errorf("cannot encode %s image: %v", format, err)
+	}
+
+	// Conversions and filters are wanted regardless of the size, plain
+	// recompression is only worth it if it shrinks the page.
+	if p.OnlyIfSmaller && !convert && len(p.Filters) == 0 && orientation <= 1 &&
+		buf.Len() >= len(data) {
+		return data, nil
+	}
+
+	info["imageExtension"] = format
+	return buf.Bytes(), nil
+}
+
+func canEncode(format string) bool {
+	return format == "jpg" || format == "png" || format == "gif"
+}
+
+func (p Pipeline) encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpg":
+		quality := p.Quality
+		if quality <= 0 {
+			quality = 90
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	}
+	return fmt.Errorf("unsupported format %q", format)
+}
+
+// pipelineWriter buffers a whole page, since images can only be decoded once
+// they're complete, and processes it on Close.
+type pipelineWriter struct {
+	pipeline Pipeline
+	info     Metadata
+	buf      bytes.Buffer
+}
+
+func (w *pipelineWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *pipelineWriter) Close() error {
+	data, err := w.pipeline.process(w.info, w.buf.Bytes())
+	if err == nil && w.pipeline.JPEGXL {
+		data, err = w.pipeline.toJPEGXL(w.info, data)
+	}
+	if err == errBlacklisted {
+		log.Printf("%s@%v: dropping blacklisted page %v",
+			w.info["manga"], w.info["chapter"], w.info["pageIndex"])
+		w.info["blacklisted"] = true
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	out, err := w.pipeline.Saver.Save(w.info, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -3,10 +3,71 @@ package main
 import (
 	"fmt"
 	"image/color"
+	"sync"
 )
 
 type Task int64
 
+// A ProgressSink is told how the pages being downloaded are coming along; the
+// terminal's ProgressBar is one, anything that wants to show it some other
+// way can be another.
+type ProgressSink interface {
+	// NewTask starts keeping track of a page.
+	NewTask() Task
+	// Tick says how much of it has arrived; total is 0 or less if that's
+	// not known.
+	Tick(task Task, sofar, total int64)
+	// Done says it's all there, or as much as there's going to be.
+	Done(task Task)
+}
+
+// transfers is a ProgressSink that adds up how much has been downloaded, for
+// the ones that tell the speed.
+type transfers struct {
+	mu    sync.Mutex
+	next  Task
+	tasks map[Task]int64
+	bytes int64
+}
+
+func (t *transfers) NewTask() Task {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tasks == nil {
+		t.tasks = map[Task]int64{}
+	}
+	t.next++
+	t.tasks[t.next] = 0
+	return t.next
+}
+
+func (t *transfers) Tick(task Task, sofar, total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	last, ok := t.tasks[task]
+	if !ok {
+		return
+	}
+	// a retry starts over
+	if sofar > last {
+		t.bytes += sofar - last
+	}
+	t.tasks[task] = sofar
+}
+
+func (t *transfers) Done(task Task) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.tasks, task)
+}
+
+// counts is how many pages are being downloaded, and how much has been so far.
+func (t *transfers) counts() (active int, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.tasks), t.bytes
+}
+
 type progress struct {
 	task  Task
 	sofar int64
@@ -17,25 +78,42 @@ func (p *progress) Tick(currentProgress int64) {
 	p.sofar = currentProgress
 }
 
+var (
+	// Unicode block elements, from the lowest to the full block.
+	blockChars = []string{"▁", "▃", "▄", "▅", "▆", "▇", "█"}
+
+	// For terminals and fonts that render the block elements poorly.
+	asciiChars = []string{".", ":", "-", "=", "+", "*", "#"}
+
+	// red to yellow to green
+	defaultGradient = LinearGradient{
+		color.RGBA{192, 3, 20, 255},
+		color.RGBA{255, 255, 0, 255},
+		color.RGBA{3, 192, 20, 255},
+	}
+)
+
+// How much room the ProgressBar leaves at the start of the line, for what's
+// left of the queue.
+const queueWidth = 40
+
 type ProgressBar struct {
+	chars    []string
 	gradient LinearGradient
 	startCh  chan Task
 	tickCh   chan progress
+	queueCh  chan [2]int
 	stopCh   chan empty
 	stopped  chan empty
 }
 
-func NewProgressBar() *ProgressBar {
-	gradient := LinearGradient{
-		color.RGBA{192, 3, 20, 255},
-		color.RGBA{255, 255, 0, 255},
-		color.RGBA{3, 192, 20, 255},
-	}
-
+func NewProgressBar(chars []string, gradient LinearGradient) *ProgressBar {
 	p := &ProgressBar{
+		chars:    chars,
 		gradient: gradient,
 		startCh:  make(chan Task),
 		tickCh:   make(chan progress),
+		queueCh:  make(chan [2]int),
 		stopCh:   make(chan empty),
 		stopped:  make(chan empty),
 	}
@@ -45,24 +123,38 @@ func NewProgressBar() *ProgressBar {
 
 func (p ProgressBar) NewTask() Task {
 	newTask := <-p.startCh
-	p.TickTask(newTask, 0, 0)
+	p.Tick(newTask, 0, 0)
 	return newTask
 }
 
-func (p ProgressBar) TickTask(task Task, sofar, total int64) {
+func (p ProgressBar) Tick(task Task, sofar, total int64) {
 	p.tickCh <- progress{task, sofar, total}
 }
 
+// Done shows the task as complete, which it might not look like if its size
+// wasn't known.
+func (p ProgressBar) Done(task Task) {
+	p.tickCh <- progress{task, 1, 1}
+}
+
+// ShowQueue shows how many chapters, and pages, are left before the bar.
+func (p ProgressBar) ShowQueue(chapters, pages int) {
+	select {
+	case p.queueCh <- [2]int{chapters, pages}:
+	case <-p.stopped:
+	}
+}
+
 func (p ProgressBar) run() {
 	fmt.Print("\033[?25l")       // cursor off
 	defer fmt.Print("\033[?25h") // cursor on
 
 	// This is because the escape code that places the cursor, at least on my
 	// terminal, treats the zeroth and the first place as the same, so you'd
-	// have some overlapping tasks.
-	var nextPlace Task = 1
+	// have some overlapping tasks.  The queue goes before all of them.
+	var nextPlace Task = queueWidth + 1
 
-	chars := []string{"▁", "▃", "▄", "▅", "▆", "▇", "█"}
+	chars := p.chars
 
 loop:
 	for {
@@ -73,6 +165,10 @@ loop:
 		case p.startCh <- nextPlace:
 			nextPlace++
 
+		case queue := <-p.queueCh:
+			counts := fmt.Sprintf("%d chapters queued, %d pages pending", queue[0], queue[1])
+			fmt.Printf("\033[1G%-*s", queueWidth, counts)
+
 		case progress := <-p.tickCh:
 			var color int
 			var char string
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A MetadataProvider looks series up in some external database.
+type MetadataProvider interface {
+	Name() string
+	// Lookup returns whatever metadata it has on the series; the keys are
+	// the same the scrapers use.
+	Lookup(f Fetcher, title string) (Metadata, error)
+}
+
+var metadataProviders = map[string]MetadataProvider{
+	"mangaupdates": MangaUpdatesProvider{},
+	"myanimelist":  MyAnimeListProvider{},
+}
+
+func lookupProviders(names []string) ([]MetadataProvider, error) {
+	var providers []MetadataProvider
+	for _, name := range names {
+		p, ok := metadataProviders[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown metadata provider %q", name)
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// ProviderPass fills in metadata from a list of providers; for every key, the
+// first provider to have it wins.  Each series is only looked up once, and
+// different series at the same time.
+type ProviderPass struct {
+	fetcher   Fetcher
+	providers []MetadataProvider
+
+	mu    sync.Mutex
+	cache map[string]*providerLookup
+}
+
+type providerLookup struct {
+	once  sync.Once
+	found Metadata
+}
+
+func NewProviderPass(f Fetcher, providers []MetadataProvider) *ProviderPass {
+	return &ProviderPass{fetcher: f, providers: providers, cache: map[string]*providerLookup{}}
+}
+
+func (p *ProviderPass) Apply(info Metadata) {
+	title, _ := info["manga"].(string)
+	// series in several languages have theirs in the name
+	if language, _ := info["language"].(string); language != "" {
+		title = strings.TrimSuffix(title, " ["+language+"]")
+	}
+	if title == "" {
+		return
+	}
+
+	p.mu.Lock()
+	lookup, ok := p.cache[title]
+	if !ok {
+		lookup = &providerLookup{}
+		p.cache[title] = lookup
+	}
+	p.mu.Unlock()
+
+	lookup.once.Do(func() {
+		lookup.found = Metadata{}
+		// Go through them backwards so that the preferred ones overwrite
+		for i := len(p.providers) - 1; i >= 0; i-- {
+			m, err := p.providers[i].Lookup(p.fetcher, title)
+			if err != nil {
+				log.Printf("%s: cannot look up %q: %v", p.providers[i].Name(), title, err)
+				continue
+			}
+			lookup.found.Update(m)
+		}
+	})
+	info.Update(lookup.found)
+}
+
+// fetchJSON decodes the JSON the API answers req with.
+func fetchJSON(f Fetcher, req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := f.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// MangaUpdatesProvider uses the API of mangaupdates.com (Baka-Updates).
+type MangaUpdatesProvider struct{}
+
+func (MangaUpdatesProvider) Name() string { return "mangaupdates" }
+
+func (MangaUpdatesProvider) Lookup(f Fetcher, title string) (Metadata, error) {
+	const api = "https://api.mangaupdates.com/v1"
+
+	query, _ := json.Marshal(map[string]interface{}{"search": title, "perpage": 1})
+	req, _ := http.NewRequest("POST", api+"/series/search", bytes.NewReader(query))
+	req.Header.Set("Content-Type", "application/json")
+
+	var search struct {
+		Results []struct {
+			Record struct {
+				SeriesID int64 `json:"series_id"`
+			} `json:"record"`
+		} `json:"results"`
+	}
+	if err := fetchJSON(f, req, &search); err != nil {
+		return nil, err
+	}
+	if len(search.Results) < 1 {
+		return nil, fmt.Errorf("no such series")
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("%s/series/%d", api, search.Results[0].Record.SeriesID), nil)
+	var series struct {
+		URL         string `json:"url"`
+		Description string `json:"description"`
+		Year        string `json:"year"`
+		Status      string `json:"status"`
+		Completed   bool   `json:"completed"`
+		Genres      []struct {
+			Genre string `json:"genre"`
+		} `json:"genres"`
+		Authors []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"authors"`
+	}
+	if err := fetchJSON(f, req, &series); err != nil {
+		return nil, err
+	}
+
+	info := Metadata{}
+	if series.Description != "" {
+		info["description"] = series.Description
+	}
+	if year, err := strconv.Atoi(series.Year); err == nil {
+		info["year"] = year
+	}
+	if series.Completed {
+		info["status"] = "Completed"
+	} else if series.Status != "" {
+		info["status"] = "Ongoing"
+	}
+	if len(series.Genres) > 0 {
+		var genres []string
+		for _, g := range series.Genres {
+			genres = append(genres, g.Genre)
+		}
+		info["genres"] = genres
+	}
+	var authors, artists []string
+	for _, a := range series.Authors {
+		switch a.Type {
+		case "Author":
+			authors = append(authors, a.Name)
+		case "Artist":
+			artists = append(artists, a.Name)
+		}
+	}
+	if len(authors) > 0 {
+		info["author"] = strings.Join(authors, ", ")
+	}
+	if len(artists) > 0 {
+		info["artist"] = strings.Join(artists, ", ")
+	}
+	return info, nil
+}
+
+// MyAnimeListProvider uses Jikan, the unofficial MyAnimeList API, which
+// doesn't need an account.
+type MyAnimeListProvider struct{}
+
+func (MyAnimeListProvider) Name() string { return "myanimelist" }
+
+func (MyAnimeListProvider) Lookup(f Fetcher, title string) (Metadata, error) {
+	u := "https://api.jikan.moe/v4/manga?limit=1&q=" + url.QueryEscape(title)
+	req, _ := http.NewRequest("GET", u, nil)
+
+	var search struct {
+		Data []struct {
+			Synopsis  string `json:"synopsis"`
+			Status    string `json:"status"`
+			Published struct {
+				Prop struct {
+					From struct {
+						Year int `json:"year"`
+					} `json:"from"`
+				} `json:"prop"`
+			} `json:"published"`
+			Genres []struct {
+				Name string `json:"name"`
+			} `json:"genres"`
+			Authors []struct {
+				Name string `json:"name"`
+			} `json:"authors"`
+		} `json:"data"`
+	}
+	if err := fetchJSON(f, req, &search); err != nil {
+		return nil, err
+	}
+	if len(search.Data) < 1 {
+		return nil, fmt.Errorf("no such series")
+	}
+	manga := search.Data[0]
+
+	info := Metadata{}
+	if manga.Synopsis != "" {
+		info["description"] = manga.Synopsis
+	}
+	if manga.Status != "" {
+		info["status"] = manga.Status
+	}
+	if manga.Published.Prop.From.Year > 0 {
+		info["year"] = manga.Published.Prop.From.Year
+	}
+	if len(manga.Genres) > 0 {
+		var genres []string
+		for _, g := range manga.Genres {
+			genres = append(genres, g.Name)
+		}
+		info["genres"] = genres
+	}
+	if len(manga.Authors) > 0 {
+		var authors []string
+		for _, a := range manga.Authors {
+			authors = append(authors, a.Name)
+		}
+		info["author"] = strings.Join(authors, ", ")
+	}
+	return info, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import "sync"
+
+// A QueueDisplay shows how much work there's left.
+type QueueDisplay interface {
+	ShowQueue(chapters, pages int)
+}
+
+// WorkQueue keeps count of the chapters that have been taken on but aren't
+// done yet, and of the pages of theirs that are known of but haven't been
+// downloaded, for Display to show.  It counts a chapter as done once its
+// archive is, so it goes after the ArchivePool.
+type WorkQueue struct {
+	Display QueueDisplay
+
+	mu       sync.Mutex
+	chapters map[string]int // to the pages still to come
+	pages    int
+}
+
+func NewWorkQueue(display QueueDisplay) *WorkQueue {
+	return &WorkQueue{Display: display, chapters: map[string]int{}}
+}
+
+func (q *WorkQueue) OnChapterQueued(info Metadata) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.chapters[dashboardKey(info)] = 0
+	q.show()
+}
+
+func (q *WorkQueue) OnPagesQueued(info Metadata, pages int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := dashboardKey(info)
+	if _, ok := q.chapters[key]; !ok {
+		return
+	}
+	q.chapters[key] += pages
+	q.pages += pages
+	q.show()
+}
+
+func (q *WorkQueue) OnPageEnd(info Metadata) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := dashboardKey(info)
+	if q.chapters[key] <= 0 {
+		return
+	}
+	q.chapters[key]--
+	q.pages--
+	q.show()
+}
+
+func (q *WorkQueue) OnChapterEnd(info Metadata) {
+	q.done(info)
+}
+
+func (q *WorkQueue) OnFailure(info Metadata, err error) {
+	q.done(info)
+}
+
+// done forgets a chapter, and any of its pages that never came.
+func (q *WorkQueue) done(info Metadata) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := dashboardKey(info)
+	pages, ok := q.chapters[key]
+	if !ok {
+		return
+	}
+	delete(q.chapters, key)
+	q.pages -= pages
+	q.show()
+}
+
+func (q *WorkQueue) show() {
+	if q.Display != nil {
+		q.Display.ShowQueue(len(q.chapters), q.pages)
+	}
+}
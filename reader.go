@@ -0,0 +1,185 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ReaderServer is a minimal web reader over a library.
+//
+//	/               the series
+//	/series/NAME    the chapters of a series
+//	/read/PATH      reads an archive
+//	/page/PATH?n=   a page of an archive
+type ReaderServer struct {
+	Root string
+}
+
+var readerTemplates = template.Must(template.New("").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>
+body { font-family: sans-serif; margin: 0 auto; max-width: 40em; padding: 1em; }
+a { text-decoration: none; }
+li { padding: .3em 0; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "library"}}{{template "header" "mango"}}
+<h1>Library</h1>
+<ul>
+{{range .}}<li><a href="/series/{{.Name}}">{{.Name}}</a> ({{len .Chapters}})</li>
+{{end}}</ul>
+</body>
+</html>
+{{end}}
+
+{{define "series"}}{{template "header" .Name}}
+<p><a href="/">Library</a></p>
+<h1>{{.Name}}</h1>
+<ul>
+{{range .Chapters}}<li><a href="/read/{{.Path}}">{{.Name}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+{{end}}
+
+{{define "read"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Series}} {{.Name}}</title>
+<style>
+body { background: #222; color: #ccc; font-family: sans-serif; margin: 0; text-align: center; }
+img { max-height: 100vh; max-width: 100%; cursor: pointer; }
+a { color: #ccc; }
+</style>
+</head>
+<body>
+<img id="page" alt="">
+<p><a href="/series/{{.Series}}">{{.Series}}</a> {{.Name}} &middot; <span id="number"></span>/{{.Pages}}</p>
+<script>
+var pages = {{.Pages}}, rtl = {{.RTL}}, current = 0;
+var img = document.getElementById("page");
+var src = {{.PageURL}};
+
+function show(n) {
+	if (n < 0 || n >= pages) return;
+	current = n;
+	img.src = src + "?n=" + n;
+	document.getElementById("number").textContent = n + 1;
+	location.hash = n + 1;
+	window.scrollTo(0, 0);
+	if (n + 1 < pages) new Image().src = src + "?n=" + (n + 1);
+}
+// Going forwards is going left in right-to-left manga
+function left() { show(rtl ? current + 1 : current - 1); }
+function right() { show(rtl ? current - 1 : current + 1); }
+
+img.onclick = function(e) {
+	if (e.offsetX < img.width / 2) left(); else right();
+};
+document.onkeydown = function(e) {
+	if (e.key == "ArrowLeft") left();
+	if (e.key == "ArrowRight") right();
+};
+show(Math.max(0, parseInt(location.hash.slice(1) || "1") - 1));
+</script>
+</body>
+</html>
+{{end}}
+`))
+
+func (s ReaderServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch p := r.URL.Path; {
+	case p == "/":
+		library, err := scanLibrary(s.Root)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		readerTemplates.ExecuteTemplate(w, "library", library)
+
+	case strings.HasPrefix(p, "/series/"):
+		name := path.Base(path.Clean("/" + strings.TrimPrefix(p, "/series/")))
+		chapters, err := scanSeries(s.Root, name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		readerTemplates.ExecuteTemplate(w, "series", LibrarySeries{name, chapters})
+
+	case strings.HasPrefix(p, "/read/"):
+		s.serveReader(w, r, strings.TrimPrefix(p, "/read/"))
+
+	case strings.HasPrefix(p, "/page/"):
+		n, err := strconv.Atoi(r.URL.Query().Get("n"))
+		if err != nil {
+			http.Error(w, "bad page number", http.StatusBadRequest)
+			return
+		}
+		serveArchivePage(w, r, libraryPath(s.Root, strings.TrimPrefix(p, "/page/")), n)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s ReaderServer) serveReader(w http.ResponseWriter, r *http.Request, p string) {
+	archive, err := zip.OpenReader(libraryPath(s.Root, p))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer archive.Close()
+
+	clean := strings.TrimPrefix(path.Clean("/"+p), "/")
+	readerTemplates.ExecuteTemplate(w, "read", struct {
+		Series, Name string
+		Pages        int
+		RTL          bool
+		PageURL      string
+	}{
+		Series:  path.Dir(clean),
+		Name:    strings.TrimSuffix(path.Base(clean), path.Ext(clean)),
+		Pages:   len(archivePages(&archive.Reader)),
+		RTL:     archiveReadingDirection(&archive.Reader) == "rtl",
+		PageURL: (&url.URL{Path: "/page/" + clean}).EscapedPath(),
+	})
+}
+
+// archiveReadingDirection finds the reading direction in an archive's
+// CoMet.xml, defaulting to left-to-right like CoMet itself does.
+func archiveReadingDirection(archive *zip.Reader) string {
+	for _, f := range archive.File {
+		if f.Name != "CoMet.xml" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			break
+		}
+		defer r.Close()
+
+		var comet struct {
+			ReadingDirection string `xml:"readingDirection"`
+		}
+		if xml.NewDecoder(r).Decode(&comet) == nil && comet.ReadingDirection != "" {
+			return comet.ReadingDirection
+		}
+	}
+	return "ltr"
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Report collects what happened during a run, to tell the user about what
+// went wrong all at the end instead of having it scroll by, and to keep a
+// record of it.
+type Report struct {
+	// Highlight has Print show the failures in red, to stand out at the
+	// end of a long run.
+	Highlight bool
+
+	mu  sync.Mutex
+	run RunReport
+}
+
+// RunReport is what's saved of a run.
+type RunReport struct {
+	Started    time.Time       `json:"started"`
+	Finished   time.Time       `json:"finished"`
+	Downloaded []ReportChapter `json:"downloaded"`
+	Failed     []ReportFailure `json:"failed"`
+	// chapters that weren't started because of the run limit
+	NotStarted int `json:"notStarted,omitempty"`
+}
+
+// ReportChapter is a chapter that was saved.
+type ReportChapter struct {
+	Series   string  `json:"series"`
+	Chapter  string  `json:"chapter"`
+	URL      string  `json:"url"`
+	Path     string  `json:"path"`
+	Bytes    int64   `json:"bytes"`
+	Duration float64 `json:"duration"` // in seconds
+	Missing  []int   `json:"missing,omitempty"`
+}
+
+// ReportFailure is a chapter, or a whole series, that was skipped.
+type ReportFailure struct {
+	Series  string `json:"series,omitempty"`
+	Chapter string `json:"chapter,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Error   string `json:"error"`
+}
+
+func NewReport() *Report {
+	return &Report{run: RunReport{Started: time.Now()}}
+}
+
+func (r *Report) OnPageEnd(info Metadata) {}
+
+func (r *Report) OnChapterEnd(info Metadata) {
+	c := ReportChapter{
+		Series:  fmt.Sprint(info["manga"]),
+		Chapter: fmt.Sprint(info["chapter"]),
+	}
+	c.URL, _ = info["url"].(string)
+	c.Path, _ = info["path"].(string)
+	c.Missing, _ = info["missingPages"].([]int)
+	if c.Path == "" {
+		// the saver didn't produce anything
+		return
+	}
+	c.Bytes = pathSize(c.Path)
+	if started, ok := info["started"].(time.Time); ok {
+		c.Duration = time.Since(started).Seconds()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.Downloaded = append(r.run.Downloaded, c)
+}
+
+func (r *Report) OnFailure(info Metadata, err error) {
+	if err == errRunLimit {
+		r.mu.Lock()
+		r.run.NotStarted++
+		r.mu.Unlock()
+		return
+	}
+
+	f := ReportFailure{Error: err.Error()}
+	if manga, ok := info["manga"]; ok {
+		f.Series = fmt.Sprint(manga)
+		f.Chapter = fmt.Sprint(info["chapter"])
+	}
+	f.URL, _ = info["url"].(string)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.Failed = append(r.run.Failed, f)
+}
+
+// pathSize is the size of a file, or of everything in a directory.
+func pathSize(path string) (size int64) {
+	filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// Print writes out what went wrong, if anything did.
+func (r *Report) Print(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var missing []ReportChapter
+	for _, c := range r.run.Downloaded {
+		if len(c.Missing) > 0 {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Fprintln(w, "Chapters saved with missing pages:")
+		for _, c := range missing {
+			fmt.Fprintf(w, "  %s %s: pages %v\n", c.Series, c.Chapter, c.Missing)
+		}
+	}
+	if len(r.run.Failed) > 0 {
+		red, reset := "", ""
+		if r.Highlight {
+			red, reset = "\033[1;31m", "\033[0m"
+		}
+		fmt.Fprintf(w, "%sFailed:%s\n", red, reset)
+		for _, f := range r.run.Failed {
+			what := f.URL
+			if f.Series != "" {
+				what = f.Series + " " + f.Chapter
+			}
+			fmt.Fprintf(w, "%s  %s: %s%s\n", red, what, f.Error, reset)
+		}
+	}
+	if r.run.NotStarted > 0 {
+		fmt.Fprintf(w, "%d chapters not started, %s\n", r.run.NotStarted, errRunLimit)
+	}
+}
+
+// Save writes the whole report as JSON to a file in dir, named after when
+// the run started.
+func (r *Report) Save(dir string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.run.Finished = time.Now()
+	path := filepath.Join(dir, "mango-report-"+r.run.Started.Format("20060102-150405")+".json")
+	data, err := json.MarshalIndent(r.run, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, append(data, '\n'), 0660)
+}
+
+// Reset starts over for the next run.
+func (r *Report) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run = RunReport{Started: time.Now()}
+}
+
+// Failed tells how many chapters failed this run.
+func (r *Report) Failed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.run.Failed)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	commands["reprocess"] = reprocessCommand
+}
+
+// reprocessCommand runs the pages of the CBZs in a library through the image
+// processing again, with whatever options are given now, and rebuilds them;
+// nothing is downloaded again.  The originals are replaced, so there's no
+// going back to pages that were made smaller or grayscale.
+func reprocessCommand(args []string) {
+	fs := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	manifestFormat, manifestPath := manifestFlags(fs)
+	profileName := fs.String("profile", "", "process pages the way `PROFILE` says (e.g. kobo-clara)")
+	deviceName := fs.String("device", "", "prepare pages for the screen of `DEVICE` (e.g. kobo-clara)")
+	grayscale := fs.Bool("grayscale", false, "convert pages to 8-bit grayscale")
+	autoCrop := fs.Bool("crop", false, "trim uniform white or black borders off pages")
+	quality := fs.Int("jpeg-quality", 0, "re-encode pages as JPEGs of the given `QUALITY` (1-100)")
+	keepLarger := fs.Bool("keep-larger", false, "keep re-encoded pages even when they end up larger")
+	convertTo := fs.String("convert", "", "convert WebP and AVIF pages to `FORMAT` (jpg or png)")
+	stripEXIF := fs.Bool("strip-exif", false, "remove EXIF metadata from pages, rotating them upright first")
+	jpegXL := fs.Bool("jxl", false, "transcode pages to JPEG XL, losslessly, with cjxl (from libjxl)")
+	jpegXLFallback := fs.String("jxl-fallback", "", "convert pages that cannot be transcoded to JPEG XL to `FORMAT` (jpg or png)")
+	split := fs.Bool("split", false, "cut double-page spreads in two, in reading order")
+	dryRun := fs.Bool("n", false, "only list the archives that would be reprocessed")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango reprocess [flags] [LIBRARY|ARCHIVE...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *profileName != "" {
+		profile, err := lookupProfile(*profileName)
+		if err != nil {
+			fatal(err)
+		}
+		// the ones for the format and naming are for downloads only
+		applyProfile(fs, profile)
+	}
+	if *convertTo != "" && *convertTo != "jpg" && *convertTo != "png" {
+		fatalln("cannot convert to", *convertTo)
+	}
+	if *jpegXLFallback != "" && *jpegXLFallback != "jpg" && *jpegXLFallback != "png" {
+		fatalln("cannot fall back to", *jpegXLFallback)
+	}
+	if *quality < 0 || *quality > 100 {
+		fatalln("invalid JPEG quality", *quality)
+	}
+
+	var filters []Filter
+	if *grayscale {
+		filters = append(filters, GrayscaleFilter{})
+	}
+	if *autoCrop {
+		filters = append(filters, CropFilter{Tolerance: 16})
+	}
+	if *deviceName != "" {
+		device, err := lookupDevice(*deviceName)
+		if err != nil {
+			fatal(err)
+		}
+		filters = append(filters, device.Filters()...)
+	}
+	pipeline := Pipeline{
+		Filters:        filters,
+		ConvertTo:      *convertTo,
+		Recompress:     *quality > 0,
+		Quality:        *quality,
+		OnlyIfSmaller:  !*keepLarger,
+		StripEXIF:      *stripEXIF,
+		JPEGXL:         *jpegXL,
+		JPEGXLFallback: *jpegXLFallback,
+	}
+	if !pipeline.wants("jpg") && *convertTo == "" && !*split {
+		fatal("nothing to do, give some processing options")
+	}
+	if *jpegXL && !haveCJXL() {
+		log.Println("cjxl not found, pages will not be transcoded to JPEG XL")
+	}
+
+	manifest, err := openManifest(*manifestFormat, *manifestPath, manifestUpdate)
+	if err != nil {
+		fatalln("cannot open manifest:", err)
+	}
+	var entries map[string]ManifestEntry
+	if manifest != nil {
+		defer manifest.Close()
+		all, err := manifest.Entries("")
+		if err != nil {
+			fatalln("cannot read manifest:", err)
+		}
+		entries = manifestPaths(all, manifestRoot(*manifestFormat, *manifestPath))
+	}
+
+	reprocessed := 0
+	for _, archive := range findCBZs(fs.Args()) {
+		if *dryRun {
+			fmt.Println(archive)
+			continue
+		}
+		info, err := archiveMetadata(archive)
+		if err != nil {
+			log.Printf("%s: %s", archive, err)
+			continue
+		}
+		before, _ := os.Stat(archive)
+		if err := reprocessArchive(archive, pipeline, *split, info); err != nil {
+			fatalf("%s: %s", archive, err)
+		}
+		after, _ := os.Stat(archive)
+		fmt.Printf("%s: %s -> %s\n", archive, formatBytes(uint64(before.Size())), formatBytes(uint64(after.Size())))
+		reprocessed++
+
+		if entry, ok := entries[absPath(".", archive)]; ok {
+			entry.CID = ""
+			if entry.Hash, err = hashFile(archive); err != nil {
+				log.Println("cannot hash chapter:", err)
+			}
+			if err := manifest.Add(entry); err != nil {
+				log.Println("cannot update manifest:", err)
+			}
+		}
+	}
+	fmt.Printf("reprocessed %d chapters\n", reprocessed)
+}
+
+// reprocessArchive runs the pages of the CBZ archive through p, cutting the
+// spreads in two first if split is set, and puts it back together in its
+// place.  The metadata files are kept as they are, but for the pages.
+func reprocessArchive(archive string, p Pipeline, split bool, info Metadata) error {
+	work, err := os.MkdirTemp(filepath.Dir(archive), ".mango-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+
+	pages := filepath.Join(work, "pages")
+	if err := unpackArchive(archive, pages); err != nil {
+		return err
+	}
+	files, err := pageFiles(pages)
+	if err != nil {
+		return err
+	}
+	// where the pages came from, by their number, which they keep
+	sources := map[int]string{}
+	old, _ := info["pageInfo"].([]ComicPageInfo)
+	for i, file := range files {
+		n, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+		if err == nil && i < len(old) && old[i].Key != "" {
+			sources[n] = old[i].Key
+		}
+	}
+	for _, file := range files {
+		if err := reprocessPage(file, p, split, info); err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(file), err)
+		}
+	}
+
+	var saver CBZSaver
+	if isFile(filepath.Join(pages, "ComicInfo.xml")) {
+		if err := updatePageMetadata(pages, sources); err != nil {
+			return err
+		}
+	} else {
+		withSources := Metadata{"pageURLs": sources}
+		withSources.Update(info)
+		saver.addMetadataFiles(withSources, pages)
+	}
+	out, err := os.CreateTemp(work, "out-*")
+	if err != nil {
+		return err
+	}
+	out.Chmod(0660)
+	if err := saver.writeArchive(pages, out); err != nil {
+		return err
+	}
+	return moveFile(out.Name(), archive)
+}
+
+// reprocessPage replaces file with what p makes of it, under the extension of
+// whatever format it ends up in.  A spread that's split becomes two pages,
+// numbered like it with an "a" and a "b" after the number.
+func reprocessPage(file string, p Pipeline, split bool, info Metadata) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), ".")
+	if sniffed := sniffImageExtension(data, ""); sniffed != "" {
+		ext = sniffed
+	}
+	halves := [][]byte{data}
+	if split {
+		if halves, err = splitPage(p, data, ext, isRightToLeft(info)); err != nil {
+			return err
+		}
+	}
+
+	stem := strings.TrimSuffix(file, filepath.Ext(file))
+	var names []string
+	for i, data := range halves {
+		pageInfo := Metadata{}
+		pageInfo.Update(info)
+		pageInfo["imageExtension"] = ext
+		if len(halves) > 1 && !canEncode(ext) {
+			pageInfo["imageExtension"] = "png"
+		}
+		if p.wants(pageInfo["imageExtension"].(string)) {
+			if data, err = p.process(pageInfo, data); err != nil {
+				return err
+			}
+		}
+		if p.JPEGXL {
+			if data, err = p.toJPEGXL(pageInfo, data); err != nil {
+				return err
+			}
+		}
+
+		name := stem
+		if len(halves) > 1 {
+			name += string(rune('a' + i))
+		}
+		name += "." + pageInfo["imageExtension"].(string)
+		if err := os.WriteFile(name, data, 0660); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	if names[0] != file {
+		return os.Remove(file)
+	}
+	return nil
+}
+
+// splitPage cuts a page of type ext in two if it's a spread; the halves are
+// encoded the same way, or as PNGs if that can't be done.
+func splitPage(p Pipeline, data []byte, ext string, rtl bool) ([][]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= config.Height {
+		// whatever can't be read isn't cut either
+		return [][]byte{data}, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode %s image: %v", ext, err)
+	}
+	format := ext
+	if !canEncode(format) {
+		format = "png"
+	}
+	var halves [][]byte
+	for _, half := range splitSpread(img, rtl) {
+		var buf bytes.Buffer
+		if err := p.encode(&buf, half, format); err != nil {
+			return nil, fmt.Errorf("cannot encode %s image: %v", format, err)
+		}
+		halves = append(halves, buf.Bytes())
+	}
+	return halves, nil
+}
+
+// updatePageMetadata brings the pages listed, and counted, in the metadata
+// files in dir up to date, and leaves everything else in them alone.
+func updatePageMetadata(dir string, sources map[int]string) error {
+	pages := comicPages(dir, sources)
+	list, err := xml.MarshalIndent(struct {
+		XMLName xml.Name        `xml:"Pages"`
+		Pages   []ComicPageInfo `xml:"Page"`
+	}{Pages: pages}, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	if err := spliceXMLFile(filepath.Join(dir, "ComicInfo.xml"), "PageCount",
+		[]byte(fmt.Sprintf("<PageCount>%d</PageCount>", len(pages)))); err != nil {
+		return err
+	}
+	if err := spliceXMLFile(filepath.Join(dir, "ComicInfo.xml"), "Pages", bytes.TrimLeft(list, " ")); err != nil {
+		return err
+	}
+	if isFile(filepath.Join(dir, "CoMet.xml")) {
+		return spliceXMLFile(filepath.Join(dir, "CoMet.xml"), "pages",
+			[]byte(fmt.Sprintf("<pages>%d</pages>", len(pages))))
+	}
+	return nil
+}
+
+// spliceXMLFile replaces the element called name, right under the root of the
+// XML file at path, with element; if there's none, element is added last.
+// The rest of the file is kept byte for byte.
+func spliceXMLFile(path, name string, element []byte) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	depth, start := 0, int64(-1)
+	for {
+		offset := d.InputOffset()
+		tok, err := d.Token()
+		if err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 2 && start < 0 && t.Name.Local == name {
+				start = offset
+			}
+		case xml.EndElement:
+			depth--
+			var spliced []byte
+			switch {
+			case depth == 1 && start >= 0:
+				spliced = append(spliced, data[:start]...)
+				spliced = append(spliced, element...)
+				spliced = append(spliced, data[d.InputOffset():]...)
+			case depth == 0:
+				spliced = append(spliced, data[:offset]...)
+				spliced = append(spliced, "  "...)
+				spliced = append(spliced, element...)
+				spliced = append(spliced, '\n')
+				spliced = append(spliced, data[offset:]...)
+			default:
+				continue
+			}
+			return os.WriteFile(path, spliced, 0660)
+		}
+	}
+}
@@ -17,6 +17,14 @@ func (LastChapterRule) Block(r Resource) bool {
 	return r.info["chapterIndex"].(int) < r.info["chapters"].(int)
 }
 
+// AdultRule blocks whatever is marked as being for adults only.
+type AdultRule empty
+
+func (AdultRule) Block(r Resource) bool {
+	adult, _ := r.info["adult"].(bool)
+	return adult
+}
+
 type funcRule func(Resource) bool
 
 func (f funcRule) Block(r Resource) bool {
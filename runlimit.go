@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+var errRunLimit = errors.New("run limit reached")
+
+// RunLimit caps how much a single run downloads, so that asking for a whole
+// catalogue by mistake stops somewhere sensible.  Chapters are only started
+// while they fit in MaxPages and the pages so far haven't gone over MaxBytes;
+// the ones already started are let finish, so MaxBytes can be overshot a bit.
+type RunLimit struct {
+	MaxBytes int64 // 0 for no limit
+	MaxPages int   // 0 for no limit
+
+	mu      sync.Mutex
+	bytes   int64
+	pages   int
+	reached bool
+}
+
+func NewRunLimit(maxBytes int64, maxPages int) *RunLimit {
+	if maxBytes <= 0 && maxPages <= 0 {
+		return nil
+	}
+	return &RunLimit{MaxBytes: maxBytes, MaxPages: maxPages}
+}
+
+// Reserve tells whether a chapter of that many pages may be downloaded, and
+// counts them if so.
+func (l *RunLimit) Reserve(pages int) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.reached ||
+		l.MaxBytes > 0 && l.bytes >= l.MaxBytes ||
+		l.MaxPages > 0 && l.pages+pages > l.MaxPages {
+		if !l.reached {
+			log.Println("run limit reached, not starting any more chapters")
+		}
+		l.reached = true
+		return false
+	}
+	l.pages += pages
+	return true
+}
+
+// Add counts n more downloaded bytes.
+func (l *RunLimit) Add(n int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bytes += n
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Sanitizer makes names safe to use as a single path component, on Windows as
+// well as everywhere else.
+type Sanitizer struct {
+	// Style is how unsafe characters are dealt with: "replace" (the
+	// default) turns them into underscores, "unicode" into their full-width
+	// lookalikes and "strip" drops them.
+	Style string
+}
+
+var fullWidth = map[rune]rune{
+	'/':  '／',
+	'\\': '＼',
+	':':  '：',
+	'*':  '＊',
+	'?':  '？',
+	'"':  '＂',
+	'<':  '＜',
+	'>':  '＞',
+	'|':  '｜',
+}
+
+// Names that Windows won't let a file have, whatever the extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+func (s Sanitizer) Validate() error {
+	switch s.Style {
+	case "", "replace", "unicode", "strip":
+		return nil
+	}
+	return fmt.Errorf("unknown sanitization style %q", s.Style)
+}
+
+func (s Sanitizer) Sanitize(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if _, unsafe := fullWidth[r]; !unsafe && !unicode.IsControl(r) {
+			return r
+		}
+		switch s.Style {
+		case "unicode":
+			if fw, ok := fullWidth[r]; ok {
+				return fw
+			}
+			return -1
+		case "strip":
+			return -1
+		}
+		return '_'
+	}, name)
+
+	// Windows drops trailing dots and spaces, which makes for some very
+	// confusing renames
+	name = strings.TrimRight(name, ". ")
+	name = strings.TrimSpace(name)
+
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if reservedNames[strings.ToUpper(base)] {
+		name = "_" + name
+	}
+	if name == "" {
+		name = "_"
+	}
+	return name
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// watchTargets returns what to keep up to date: the URLs given on the
+// command line or, failing that, the tracked series.
+func watchTargets(args []string) []TrackedSeries {
+	if len(args) > 0 {
+		var targets []TrackedSeries
+		for _, a := range args {
+			targets = append(targets, TrackedSeries{URL: a})
+		}
+		return targets
+	}
+
+	tracked, err := loadTracked(trackedPath())
+	if err != nil {
+		fatalln("cannot load tracked series:", err)
+	}
+	return tracked
+}
+
+// seriesSchedule returns when s should be checked: its own schedule if it
+// has one, otherwise the default one.
+func seriesSchedule(s TrackedSeries, fallback Schedule) Schedule {
+	if s.Schedule == "" {
+		return fallback
+	}
+	schedule, err := ParseCronSchedule(s.Schedule)
+	if err != nil {
+		log.Printf("%s: %s", s.URL, err)
+		return fallback
+	}
+	return schedule
+}
+
+// watch calls run with whatever is due, forever.  Everything is due right
+// away; after that, each series comes up again according to its schedule.
+func watch(args []string, fallback Schedule, run func(urls []string)) {
+	next := map[string]time.Time{}
+	for {
+		now := time.Now()
+
+		// re-read every time, the list may have changed while waiting
+		targets := watchTargets(args)
+		var due []string
+		wake := time.Time{}
+		seen := map[string]bool{}
+		for _, s := range targets {
+			seen[s.URL] = true
+			at, ok := next[s.URL]
+			if !ok || !at.After(now) {
+				due = append(due, s.URL)
+				at = seriesSchedule(s, fallback).Next(now)
+				next[s.URL] = at
+			}
+			if !at.IsZero() && (wake.IsZero() || at.Before(wake)) {
+				wake = at
+			}
+		}
+		// forget about series that are no longer tracked
+		for u := range next {
+			if !seen[u] {
+				delete(next, u)
+			}
+		}
+
+		if len(due) > 0 {
+			sort.Strings(due)
+			run(due)
+		}
+
+		if wake.IsZero() {
+			// nothing's scheduled, but the tracked series may change
+			wake = time.Now().Add(time.Hour)
+		}
+		time.Sleep(time.Until(wake))
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current output")
+
+// A scraperFixture is a page saved from a site, along with the URL it was
+// saved from.  Its golden file sits next to it, with .golden.json in place of
+// .html.
+type scraperFixture struct {
+	scraper Scraper
+	file    string
+	url     string
+	// what to run on it: chapters, pages or image
+	kind string
+}
+
+var scraperFixtures = []scraperFixture{
+	{MangaReaderScraper{}, "mangareader/chapters.html", "https://www.mangareader.net/berserk", "chapters"},
+	{MangaReaderScraper{}, "mangareader/page.html", "https://www.mangareader.net/berserk/1/2", "pages"},
+	{MangaReaderScraper{}, "mangareader/image.html", "https://www.mangareader.net/berserk/1/3", "image"},
+	{MangaEdenScraper{}, "mangaeden/chapters.html", "https://www.mangaeden.com/en/en-manga/vagabond/", "chapters"},
+	{MangaEdenScraper{}, "mangaeden/page.html", "https://www.mangaeden.com/en/en-manga/vagabond/327/1/", "pages"},
+	{MangaEdenScraper{}, "mangaeden/image.html", "https://www.mangaeden.com/en/en-manga/vagabond/327/3/", "image"},
+	{MangaStreamerScraper{}, "mangastream/chapters.html", "https://readms.net/manga/one_piece", "chapters"},
+	{MangaStreamerScraper{}, "mangastream/page.html", "https://readms.net/r/one_piece/881/4921/2", "pages"},
+	{MangaStreamerScraper{}, "mangastream/image.html", "https://readms.net/r/one_piece/881/4921/3", "image"},
+	{MadaraScraper{}, "madara/chapters.html", "https://madara.example/manga/solo-leveling/", "chapters"},
+	{MadaraScraper{}, "madara/page.html", "https://madara.example/manga/solo-leveling/chapter-1/", "pages"},
+	{FoolSlideScraper{}, "foolslide/chapters.html", "https://reader.example/series/kaguya/", "chapters"},
+	{FoolSlideScraper{}, "foolslide/page.html", "https://reader.example/read/kaguya/en/0/1/page/1", "pages"},
+	{MangaThemesiaScraper{}, "mangathemesia/chapters.html", "https://themesia.example/manga/tower-of-god/", "chapters"},
+	{MangaThemesiaScraper{}, "mangathemesia/page.html", "https://themesia.example/tower-of-god-chapter-1/", "pages"},
+}
+
+// goldenResource is how Resources are written in golden files.
+type goldenResource struct {
+	URL  string   `json:"url"`
+	Info Metadata `json:"info"`
+}
+
+func goldenResources(resources []Resource) []goldenResource {
+	golden := []goldenResource{}
+	for _, r := range resources {
+		golden = append(golden, goldenResource{r.url.String(), r.info})
+	}
+	return golden
+}
+
+func loadFixture(t *testing.T, f scraperFixture) *goquery.Document {
+	t.Helper()
+
+	file, err := os.Open(filepath.Join("testdata", "scrapers", f.file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	doc, err := goquery.NewDocumentFromReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Url, err = url.Parse(f.url); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func runFixture(t *testing.T, f scraperFixture) interface{} {
+	doc := loadFixture(t, f)
+
+	switch f.kind {
+	case "chapters":
+		return goldenResources(f.scraper.GetChapters(doc))
+	case "pages":
+		pages, images := f.scraper.GetPages(doc)
+		return map[string][]goldenResource{
+			"pages":  goldenResources(pages),
+			"images": goldenResources(images),
+		}
+	case "image":
+		return goldenResources([]Resource{f.scraper.GetImage(doc)})
+	}
+	t.Fatalf("unknown fixture kind %q", f.kind)
+	return nil
+}
+
+func TestScraperGolden(t *testing.T) {
+	for _, f := range scraperFixtures {
+		t.Run(f.file, func(t *testing.T) {
+			got, err := json.MarshalIndent(runFixture(t, f), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := filepath.Join("testdata", "scrapers", strings.TrimSuffix(f.file, ".html")+".golden.json")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%s (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs from %s (run with -update to accept it)\ngot:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+// TestEngineDetection checks the engines' fixtures are told apart, and that
+// the chapter pages lead back to their series.
+func TestEngineDetection(t *testing.T) {
+	for _, f := range scraperFixtures {
+		var want *engine
+		for i := range engines {
+			if engines[i].scraper == f.scraper {
+				want = &engines[i]
+			}
+		}
+		if want == nil {
+			continue
+		}
+		t.Run(f.file, func(t *testing.T) {
+			doc := loadFixture(t, f)
+			detected := false
+			for i := range engines {
+				if engines[i].detect(doc) {
+					if engines[i].name != want.name {
+						t.Fatalf("detected %s, want %s", engines[i].name, want.name)
+					}
+					detected = true
+					break
+				}
+			}
+			if !detected {
+				t.Fatalf("no engine detected %s", want.name)
+			}
+			series, ok := want.series(doc)
+			if ok != (f.kind == "pages") {
+				t.Fatalf("series(): ok = %v on a %s page", ok, f.kind)
+			}
+			if ok && series.Path == doc.Url.Path {
+				t.Errorf("series(): %s is the chapter itself", series)
+			}
+		})
+	}
+}
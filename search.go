@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// A SearchResult is a series found on one of the sites.
+type SearchResult struct {
+	Site  string
+	Title string
+	URL   string
+}
+
+// A siteSearch knows where a site's search results are and how to read them.
+type siteSearch struct {
+	url    func(title string) string
+	result string // selects the links to the series
+}
+
+var siteSearches = map[string]siteSearch{
+	"mangareader": {
+		func(title string) string { return "https://www.mangareader.net/search/?w=" + url.QueryEscape(title) },
+		".mangaresultitem h3 a[href]",
+	},
+	"mangaeden": {
+		func(title string) string {
+			return "https://www.mangaeden.com/en/en-directory/?title=" + url.QueryEscape(title)
+		},
+		"#mangaList td:first-child a[href]",
+	},
+	// it has no search, only a list of everything
+	"mangastream": {
+		func(string) string { return "https://readms.net/manga" },
+		".table-striped td:first-child a[href]",
+	},
+}
+
+// apiSearches are the sites that are searched through an API, not a page.
+var apiSearches = map[string]func(f Fetcher, title string) ([]SearchResult, error){
+	"mangadex": searchMangaDex,
+}
+
+func searchMangaDex(f Fetcher, title string) ([]SearchResult, error) {
+	query := url.Values{"title": {title}, "limit": {"20"}}
+	for _, rating := range []string{"safe", "suggestive", "erotica", "pornographic"} {
+		query.Add("contentRating[]", rating)
+	}
+	req, _ := http.NewRequest("GET", mangadexAPI+"/manga?"+query.Encode(), nil)
+	var found struct {
+		Data []mangadexManga `json:"data"`
+	}
+	if err := fetchJSON(f, req, &found); err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	for _, m := range found.Data {
+		if titles := m.titles(); len(titles) > 0 {
+			results = append(results, SearchResult{"mangadex", titles[0], "https://mangadex.org/title/" + m.ID})
+		}
+	}
+	return results, nil
+}
+
+// searchSites looks for title on every site.  Sites that can't be searched
+// right now are left out.
+func searchSites(fetcher Fetcher, title string) []SearchResult {
+	var sites []string
+	for site := range siteSearches {
+		sites = append(sites, site)
+	}
+	for site := range apiSearches {
+		sites = append(sites, site)
+	}
+	sort.Strings(sites)
+
+	var results []SearchResult
+	for _, site := range sites {
+		if search, ok := apiSearches[site]; ok {
+			found, err := search(fetcher, title)
+			if err != nil {
+				log.Printf("%s: cannot search for %q: %s", site, title, err)
+			}
+			results = append(results, found...)
+			continue
+		}
+		s := siteSearches[site]
+		u, _ := url.Parse(s.url(title))
+		doc, err := fetcher.GetHTML(u)
+		if err != nil {
+			log.Printf("%s: cannot search for %q: %s", site, title, err)
+			continue
+		}
+		doc.Find(s.result).Each(func(i int, a *goquery.Selection) {
+			name := strings.TrimSpace(a.Text())
+			// the list mangastream gives has to be searched here
+			if site == "mangastream" && !strings.Contains(seriesKey(name), seriesKey(title)) {
+				return
+			}
+			if link, err := doc.Url.Parse(a.AttrOr("href", "")); err == nil {
+				results = append(results, SearchResult{site, name, link.String()})
+			}
+		})
+	}
+	return results
+}
+
+// A Resolver finds the series a title stands for on one of the sites, asking
+// the user when there's more than one candidate and it's allowed to.
+type Resolver struct {
+	Fetcher     Fetcher
+	Interactive bool
+
+	in *bufio.Reader
+}
+
+// Resolve returns where to download the series from, or false if it isn't
+// anywhere (or the user said to skip it).  Any of titles may match; they're
+// usually the same series in different languages.
+func (r *Resolver) Resolve(titles ...string) (SearchResult, bool) {
+	var candidates []SearchResult
+	seen := map[string]bool{}
+	for _, title := range titles {
+		if title == "" {
+			continue
+		}
+		for _, c := range searchSites(r.Fetcher, title) {
+			if !seen[c.URL] {
+				seen[c.URL] = true
+				candidates = append(candidates, c)
+			}
+		}
+	}
+
+	// a single exact match is good enough
+	var exact []SearchResult
+	for _, c := range candidates {
+		for _, title := range titles {
+			if title != "" && seriesKey(c.Title) == seriesKey(title) {
+				exact = append(exact, c)
+				break
+			}
+		}
+	}
+	switch {
+	case len(exact) == 1:
+		return exact[0], true
+	case len(exact) > 1:
+		candidates = exact
+	case len(candidates) == 0:
+		log.Printf("%q isn't on any supported site", titles[0])
+		return SearchResult{}, false
+	}
+	if !r.Interactive {
+		log.Printf("%q is ambiguous, skipping it (%d candidates)", titles[0], len(candidates))
+		return SearchResult{}, false
+	}
+	return r.ask(titles[0], candidates)
+}
+
+// stdinIsTerminal tells whether there's someone there to ask.
+func stdinIsTerminal() bool {
+	stat, err := os.Stdin.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+func (r *Resolver) ask(title string, candidates []SearchResult) (SearchResult, bool) {
+	if r.in == nil {
+		r.in = bufio.NewReader(os.Stdin)
+	}
+	fmt.Printf("Which one is %q?\n", title)
+	for i, c := range candidates {
+		fmt.Printf("  %d) %s (%s) %s\n", i+1, c.Title, c.Site, c.URL)
+	}
+	for {
+		fmt.Print("Number, or nothing to skip it: ")
+		line, err := r.in.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return SearchResult{}, false
+		}
+		if n, convErr := strconv.Atoi(line); convErr == nil && n >= 1 && n <= len(candidates) {
+			return candidates[n-1], true
+		}
+		if err != nil {
+			return SearchResult{}, false
+		}
+	}
+}
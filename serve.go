@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+func init() {
+	commands["serve"] = serveCommand
+}
+
+// serveCommand makes the library available over HTTP.
+func serveCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "listen on `ADDRESS`")
+	opds := fs.Bool("opds", false, "serve an OPDS catalog under /opds")
+	reader := fs.Bool("reader", true, "serve a web reader")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango serve [flags] [LIBRARY]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	root := "."
+	if fs.NArg() > 0 {
+		root = fs.Arg(0)
+	}
+
+	mux := http.NewServeMux()
+	if *opds {
+		mux.Handle("/opds", OPDSServer{root})
+		mux.Handle("/opds/", OPDSServer{root})
+	}
+	if *reader {
+		mux.Handle("/", ReaderServer{root})
+	}
+	if !*opds && !*reader {
+		fmt.Fprintln(os.Stderr, "nothing to serve; try -opds or -reader")
+		os.Exit(2)
+	}
+
+	log.Println("serving", root, "on", *addr)
+	fatal(http.ListenAndServe(*addr, mux))
+}
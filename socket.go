@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// socketWriteTimeout is how long a program listening on the progress socket
+// can keep mango waiting before it's cut off.
+const socketWriteTimeout = 5 * time.Second
+
+// ProgressSocket publishes the events of an EventStream on a Unix socket, as
+// JSON Lines, to every program connected to it; status bars, desktop widgets
+// and the like.  Windows has Unix sockets too, these days.
+type ProgressSocket struct {
+	path     string
+	listener net.Listener
+	stream   *EventStream
+
+	mu     sync.Mutex
+	hangUp []func()
+	wg     sync.WaitGroup
+}
+
+func ListenProgressSocket(path string, stream *EventStream) (*ProgressSocket, error) {
+	// left over from a mango that didn't get to clean up
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &ProgressSocket{path: path, listener: listener, stream: stream}
+	go s.accept()
+	return s, nil
+}
+
+func (s *ProgressSocket) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			// closed
+			return
+		}
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *ProgressSocket) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	events, unsubscribe := s.stream.Subscribe(64)
+	s.mu.Lock()
+	s.hangUp = append(s.hangUp, unsubscribe)
+	s.mu.Unlock()
+
+	enc := json.NewEncoder(conn)
+	for e := range events {
+		conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+		if err := enc.Encode(e); err != nil {
+			log.Println("progress socket:", err)
+			// the stream may be waiting on this one
+			go func() {
+				for range events {
+				}
+			}()
+			unsubscribe()
+			return
+		}
+	}
+}
+
+// Close stops taking connections and hangs up on everyone, once they've been
+// sent whatever events are left.
+func (s *ProgressSocket) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for _, unsubscribe := range s.hangUp {
+		unsubscribe()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	os.Remove(s.path)
+	return err
+}
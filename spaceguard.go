@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpaceGuard keeps the output filesystem, and the staging one, from filling up.  As a Saver, it
+// keeps track of how much the pages being downloaded are going to take up;
+// as a Rule, it holds back new chapters while the free space, minus that and
+// what a chapter usually takes, is below MinFree.
+type SpaceGuard struct {
+	Saver
+	// the output directory, and the staging one if there's one
+	Dirs    []string
+	MinFree uint64
+	// how long to wait before checking again
+	Interval time.Duration
+
+	mu       sync.Mutex
+	pending  uint64 // bytes announced by pages still being written
+	saved    uint64 // bytes of pages written so far
+	chapters uint64 // chapters let through so far
+	paused   bool
+}
+
+func (g *SpaceGuard) Save(info Metadata, size int64) (io.WriteCloser, error) {
+	w, err := g.Saver.Save(info, size)
+	if err != nil || size <= 0 {
+		return w, err
+	}
+
+	g.mu.Lock()
+	g.pending += uint64(size)
+	g.mu.Unlock()
+	return spaceGuardWriter{w, g, uint64(size)}, nil
+}
+
+type spaceGuardWriter struct {
+	io.WriteCloser
+	g    *SpaceGuard
+	size uint64
+}
+
+func (w spaceGuardWriter) Close() error {
+	w.g.mu.Lock()
+	w.g.pending -= w.size
+	w.g.saved += w.size
+	w.g.mu.Unlock()
+	return w.WriteCloser.Close()
+}
+
+// estimate returns how much space the downloads in progress, and one more
+// chapter, are expected to take up.
+func (g *SpaceGuard) estimate() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var perChapter uint64
+	if g.chapters > 0 {
+		perChapter = g.saved / g.chapters
+	}
+	return g.pending + perChapter
+}
+
+// leastFree returns the free space of whichever of Dirs has the least.
+func (g *SpaceGuard) leastFree() (free uint64, dir string, err error) {
+	for i, d := range g.Dirs {
+		f, err := freeSpace(d)
+		if err != nil {
+			return 0, d, err
+		}
+		if i == 0 || f < free {
+			free, dir = f, d
+		}
+	}
+	return free, dir, nil
+}
+
+// Block never blocks a chapter, it only waits for there to be room for it.
+func (g *SpaceGuard) Block(r Resource) bool {
+	for {
+		free, dir, err := g.leastFree()
+		if err != nil {
+			// nothing to go on, so don't get in the way
+			return false
+		}
+
+		needed := g.estimate()
+		if free >= needed && free-needed >= g.MinFree {
+			g.mu.Lock()
+			if g.paused {
+				log.Printf("%s free in %s, resuming downloads", formatBytes(free), dir)
+				g.paused = false
+			}
+			g.chapters++
+			g.mu.Unlock()
+			return false
+		}
+
+		g.mu.Lock()
+		if !g.paused {
+			log.Printf("WARNING: only %s free in %s (about %s more needed), pausing downloads until there's at least %s",
+				formatBytes(free), dir, formatBytes(needed), formatBytes(g.MinFree))
+			g.paused = true
+		}
+		g.mu.Unlock()
+		time.Sleep(g.Interval)
+	}
+}
+
+// byteSize is a flag for sizes like 500M or 2G (powers of 1024).
+type byteSize uint64
+
+func (b byteSize) String() string {
+	return formatBytes(uint64(b))
+}
+
+func (b *byteSize) Set(s string) error {
+	s = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	s = strings.TrimSuffix(s, "I")
+
+	multiplier := uint64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]); i >= 0 {
+			multiplier = 1 << (10 * (i + 1))
+			s = s[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = byteSize(n * float64(multiplier))
+	return nil
+}
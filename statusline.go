@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// StatusLine is for when there's no one watching a terminal, as under cron or
+// CI, where progress bars only make a mess of the logs: every so often it logs
+// a single line on how the run is going instead,
+//
+//	12/350 chapters, 4.2 MiB/s, 3 errors
+type StatusLine struct {
+	transfers
+
+	mu              sync.Mutex
+	done, failed    int
+	queued, pending int
+	stop, stopped   chan empty
+}
+
+// NewStatusLine logs the status every interval, until Stop.
+func NewStatusLine(interval time.Duration) *StatusLine {
+	s := &StatusLine{stop: make(chan empty), stopped: make(chan empty)}
+	go s.run(interval)
+	return s
+}
+
+func (s *StatusLine) run(interval time.Duration) {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastBytes int64
+	last := time.Now()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			_, bytes := s.transfers.counts()
+			speed := float64(bytes-lastBytes) / now.Sub(last).Seconds()
+			lastBytes, last = bytes, now
+			// between the runs of -watch, say
+			if line := s.line(speed); line != "" {
+				log.Println(line)
+			}
+		}
+	}
+}
+
+// line is the status, or nothing if there's nothing going on.
+func (s *StatusLine) line(speed float64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queued == 0 && speed == 0 {
+		return ""
+	}
+	line := fmt.Sprintf("%d/%d chapters, %s/s", s.done, s.done+s.queued, formatBytes(uint64(speed)))
+	if s.pending > 0 {
+		line += fmt.Sprintf(", %d pages pending", s.pending)
+	}
+	if s.failed > 0 {
+		line += fmt.Sprintf(", %d errors", s.failed)
+	}
+	return line
+}
+
+func (s *StatusLine) Stop() {
+	close(s.stop)
+	<-s.stopped
+}
+
+func (s *StatusLine) ShowQueue(chapters, pages int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queued, s.pending = chapters, pages
+}
+
+func (s *StatusLine) OnPageEnd(info Metadata) {}
+
+func (s *StatusLine) OnChapterEnd(info Metadata) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done++
+}
+
+func (s *StatusLine) OnFailure(info Metadata, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed++
+}
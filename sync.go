@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+func init() {
+	commands["sync"] = syncCommand
+}
+
+// Where to get reading lists from, by service.
+var syncers = map[string]func(f Fetcher, user string, statuses []string) ([]listEntry, error){
+	"anilist": anilistEntries,
+}
+
+// A listEntry is a series on someone's reading list, under all the titles
+// the service knows it by.
+type listEntry struct {
+	Titles []string
+}
+
+// syncCommand tracks the series on a user's reading list, wherever they can
+// be found.
+func syncCommand(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	interactive := fs.Bool("interactive", stdinIsTerminal(), "ask which series is meant when a title is ambiguous")
+	planning := fs.Bool("planning", true, "also track the series planned to be read")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango sync [flags] anilist USER")
+		fmt.Fprintln(os.Stderr, "       mango sync [flags] mangadex follows|LIST_ID")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 || syncers[fs.Arg(0)] == nil {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	statuses := []string{"CURRENT"}
+	if *planning {
+		statuses = append(statuses, "PLANNING")
+	}
+	fetcher := listFetcher()
+	entries, err := syncers[fs.Arg(0)](fetcher, fs.Arg(1), statuses)
+	if err != nil {
+		fatalln("cannot get reading list:", err)
+	}
+	trackResolved(fetcher, entries, *interactive)
+}
+
+// listFetcher is the Fetcher for reading lists and searches, with the limits
+// the sites' scrapers ask for.
+func listFetcher() Fetcher {
+	f := NewFetcher(4, 2)
+	for _, s := range scrapers {
+		if l, ok := s.(LimitedScraper); ok {
+			for _, d := range l.Limits() {
+				if err := f.LimitDomain(d); err != nil {
+					fatal(err)
+				}
+			}
+		}
+	}
+	return f
+}
+
+// trackResolved finds each entry on one of the sites and adds it to the
+// tracked series.
+func trackResolved(f Fetcher, entries []listEntry, interactive bool) {
+	path := trackedPath()
+	tracked, err := loadTracked(path)
+	if err != nil {
+		fatalln("cannot load tracked series:", err)
+	}
+
+	resolved := resolveEntries(f, entries, interactive)
+	n := 0
+	for _, s := range resolved {
+		var added bool
+		if tracked, added = addTracked(tracked, s); added {
+			n++
+		}
+	}
+	if err := saveTracked(path, tracked); err != nil {
+		fatalln("cannot save tracked series:", err)
+	}
+	fmt.Printf("tracking %d new series (%d in total), %d skipped\n", n, len(tracked), len(entries)-len(resolved))
+	if n > 0 {
+		fmt.Println("run mango with no URLs to download them")
+	}
+}
+
+// resolveEntries finds the entries on the sites; the ones that can't be
+// found are left out.
+func resolveEntries(f Fetcher, entries []listEntry, interactive bool) []TrackedSeries {
+	resolver := &Resolver{Fetcher: f, Interactive: interactive}
+	var resolved []TrackedSeries
+	for _, e := range entries {
+		if found, ok := resolver.Resolve(e.Titles...); ok {
+			resolved = append(resolved, TrackedSeries{URL: found.URL, Title: found.Title})
+		}
+	}
+	return resolved
+}
+
+// anilistEntries gets a user's manga list from AniList's GraphQL API, which
+// doesn't need an account for public lists.
+func anilistEntries(f Fetcher, user string, statuses []string) ([]listEntry, error) {
+	const query = `query ($user: String, $status: [MediaListStatus]) {
+		MediaListCollection(userName: $user, type: MANGA, status_in: $status) {
+			lists { entries { media { title { romaji english } } } }
+		}
+	}`
+	body, _ := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": map[string]interface{}{"user": user, "status": statuses},
+	})
+	req, _ := http.NewRequest("POST", "https://graphql.anilist.co", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp struct {
+		Data struct {
+			MediaListCollection struct {
+				Lists []struct {
+					Entries []struct {
+						Media struct {
+							Title struct {
+								Romaji  string `json:"romaji"`
+								English string `json:"english"`
+							} `json:"title"`
+						} `json:"media"`
+					} `json:"entries"`
+				} `json:"lists"`
+			} `json:"MediaListCollection"`
+		} `json:"data"`
+	}
+	if err := fetchJSON(f, req, &resp); err != nil {
+		return nil, err
+	}
+
+	var entries []listEntry
+	for _, l := range resp.Data.MediaListCollection.Lists {
+		for _, e := range l.Entries {
+			t := e.Media.Title
+			titles := []string{}
+			for _, title := range []string{t.English, t.Romaji} {
+				if title != "" {
+					titles = append(titles, title)
+				}
+			}
+			if len(titles) > 0 {
+				entries = append(entries, listEntry{titles})
+			}
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// BandwidthProfile limits the download speed during part of the day.
+type BandwidthProfile struct {
+	// From and To are times of day ("01:00"); the window may go past
+	// midnight.  Without them, the profile applies all day.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Limit is the most to download per second (e.g. "500K"); empty or
+	// "0" means there's no limit.
+	Limit string `json:"limit,omitempty"`
+}
+
+type bandwidthWindow struct {
+	from, to time.Duration // since midnight
+	allDay   bool
+	limit    float64 // bytes per second, 0 for none
+}
+
+func (w bandwidthWindow) contains(t time.Time) bool {
+	if w.allDay {
+		return true
+	}
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if w.from <= w.to {
+		return w.from <= sinceMidnight && sinceMidnight < w.to
+	}
+	// e.g. 22:00-06:00
+	return sinceMidnight >= w.from || sinceMidnight < w.to
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Throttle limits how fast all downloads together go, according to the
+// first profile whose window contains the current time.  A nil *Throttle
+// doesn't limit anything.
+type Throttle struct {
+	windows []bandwidthWindow
+
+	mu        sync.Mutex
+	allowance float64
+	last      time.Time
+}
+
+func NewThrottle(profiles []BandwidthProfile) (*Throttle, error) {
+	t := &Throttle{}
+	for _, p := range profiles {
+		var w bandwidthWindow
+		if p.From == "" && p.To == "" {
+			w.allDay = true
+		} else {
+			var err error
+			if w.from, err = parseTimeOfDay(p.From); err != nil {
+				return nil, err
+			}
+			if w.to, err = parseTimeOfDay(p.To); err != nil {
+				return nil, err
+			}
+		}
+		if p.Limit != "" {
+			var limit byteSize
+			if err := limit.Set(p.Limit); err != nil {
+				return nil, err
+			}
+			w.limit = float64(limit)
+		}
+		t.windows = append(t.windows, w)
+	}
+	return t, nil
+}
+
+// limit returns the speed limit right now.
+func (t *Throttle) limit(now time.Time) float64 {
+	for _, w := range t.windows {
+		if w.contains(now) {
+			return w.limit
+		}
+	}
+	return 0
+}
+
+// wait blocks until n more bytes may be read.  Up to a second's worth can be
+// read in a burst; past that, reads go into debt and wait for it to be paid
+// off, so that no read is ever too big to be let through.
+func (t *Throttle) wait(n int) {
+	now := time.Now()
+	limit := t.limit(now)
+	if limit == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	t.allowance += now.Sub(t.last).Seconds() * limit
+	t.last = now
+	if t.allowance > limit {
+		t.allowance = limit
+	}
+	t.allowance -= float64(n)
+	debt := -t.allowance
+	t.mu.Unlock()
+
+	if debt > 0 {
+		time.Sleep(time.Duration(debt / limit * float64(time.Second)))
+	}
+}
+
+// Wrap throttles reads from body.
+func (t *Throttle) Wrap(body io.ReadCloser) io.ReadCloser {
+	if t == nil || len(t.windows) == 0 {
+		return body
+	}
+	return throttledReader{body, t}
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	t *Throttle
+}
+
+// Small reads, so that many downloads share the bandwidth evenly.
+const throttleChunk = 16 << 10
+
+func (r throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.t.wait(n)
+	}
+	return n, err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// TLSConfig is for sites that aren't on the public web's terms, as behind
+// corporate proxies or on self-hosted mirrors.
+type TLSConfig struct {
+	// CAFile has more certificate authorities to trust, in PEM, besides
+	// the system's.
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile and KeyFile are a client certificate, and its key, in PEM,
+	// for servers that ask for one; the key can be in CertFile too.
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+}
+
+// tlsTransport makes an HTTP transport that goes by c.  If insecure, it
+// doesn't check servers' certificates at all.
+func tlsTransport(c TLSConfig, insecure bool) (*http.Transport, error) {
+	config := &tls.Config{}
+
+	if c.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates in it", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if c.CertFile != "" {
+		keyFile := c.KeyFile
+		if keyFile == "" {
+			keyFile = c.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if insecure {
+		log.Println("warning: not checking servers' certificates, anyone in between can see and change what's downloaded")
+		config.InsecureSkipVerify = true
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport, nil
+}
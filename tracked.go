@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// TrackedSeries is a series that mango keeps up to date; running mango with
+// no URLs downloads whatever is new in all of them.
+type TrackedSeries struct {
+	URL   string `json:"url"`
+	Title string `json:"title,omitempty"`
+	// Schedule, if set, is when to check the series when mango keeps
+	// running, as a cron expression; see CronSchedule.
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// trackedPath returns the location of the tracked series list, next to the
+// configuration file.
+func trackedPath() string {
+	path := configPath()
+	if path == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(path), "tracked.json")
+}
+
+func loadTracked(path string) ([]TrackedSeries, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var tracked []TrackedSeries
+	err = json.Unmarshal(data, &tracked)
+	return tracked, err
+}
+
+func saveTracked(path string, tracked []TrackedSeries) error {
+	data, err := json.MarshalIndent(tracked, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	os.MkdirAll(filepath.Dir(path), os.ModeDir|0770)
+	tmpname := path + ".part"
+	if err := os.WriteFile(tmpname, data, 0660); err != nil {
+		return err
+	}
+	return os.Rename(tmpname, path)
+}
+
+// addTracked appends s to the list unless its URL is already there.
+func addTracked(tracked []TrackedSeries, s TrackedSeries) ([]TrackedSeries, bool) {
+	for _, t := range tracked {
+		if t.URL == s.URL {
+			return tracked, false
+		}
+	}
+	return append(tracked, s), true
+}
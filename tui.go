@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// How much of each list the TUI shows at most.
+const (
+	tuiQueue  = 8
+	tuiErrors = 5
+)
+
+// TUI takes over the terminal while mango runs, for interactive use: it shows
+// what's being downloaded, series by series, how fast, what's waiting and
+// what went wrong, and takes keys to pause and skip chapters.
+//
+//	p          pause new chapters, or carry on
+//	j/k, ↓/↑   pick one of the chapters waiting
+//	s          skip it
+//
+// It's also the ProgressSink for the pages, to tell the transfer speed.
+type TUI struct {
+	transfers
+
+	mu sync.Mutex
+	// chapters queued and pages pending
+	queued, pending int
+
+	dashboard *Dashboard
+	gate      *ChapterGate
+	bar       []string
+	selected  int
+	restore   func()
+
+	keys    chan string
+	stop    chan empty
+	stopped chan empty
+	once    sync.Once
+}
+
+// NewTUI draws its bars with chars, the way the ProgressBar does.
+func NewTUI(chars []string) *TUI {
+	return &TUI{
+		bar:     chars,
+		keys:    make(chan string),
+		stop:    make(chan empty),
+		stopped: make(chan empty),
+	}
+}
+
+func (t *TUI) ShowQueue(chapters, pages int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queued, t.pending = chapters, pages
+}
+
+// Start takes over the terminal, showing d and working g.
+func (t *TUI) Start(d *Dashboard, g *ChapterGate) {
+	t.dashboard, t.gate = d, g
+	t.restore = rawTerminal()
+	fmt.Print("\033[?1049h\033[?25l") // alternate screen, cursor off
+	go t.readKeys()
+	go t.run()
+}
+
+// Stop gives the terminal back the way it was.  It can be called more than
+// once.
+func (t *TUI) Stop() {
+	t.once.Do(func() {
+		close(t.stop)
+		<-t.stopped
+		fmt.Print("\033[?25h\033[?1049l")
+		t.restore()
+	})
+}
+
+func (t *TUI) run() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var lastBytes int64
+	last := time.Now()
+	var speed float64
+	t.draw(speed)
+	for {
+		select {
+		case <-t.stop:
+			return
+		case key := <-t.keys:
+			t.handleKey(key)
+		case now := <-ticker.C:
+			_, bytes := t.transfers.counts()
+			speed = float64(bytes-lastBytes) / now.Sub(last).Seconds()
+			lastBytes, last = bytes, now
+		}
+		t.draw(speed)
+	}
+}
+
+func (t *TUI) readKeys() {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		keys := string(buf[:n])
+		for keys != "" {
+			key := keys[:1]
+			// the arrows send escape sequences
+			if strings.HasPrefix(keys, "\033[") && len(keys) >= 3 {
+				key = keys[:3]
+			}
+			keys = keys[len(key):]
+			select {
+			case t.keys <- key:
+			case <-t.stop:
+				return
+			}
+		}
+	}
+}
+
+func (t *TUI) handleKey(key string) {
+	waiting := t.gate.Waiting()
+	switch key {
+	case "p":
+		if t.gate.Paused() {
+			t.gate.Resume()
+		} else {
+			t.gate.Pause()
+		}
+	case "j", "\033[B":
+		t.selected++
+	case "k", "\033[A":
+		t.selected--
+	case "s":
+		if t.selected < len(waiting) {
+			t.gate.Skip(waiting[t.selected])
+		}
+	}
+	if t.selected >= len(waiting) {
+		t.selected = len(waiting) - 1
+	}
+	if t.selected < 0 {
+		t.selected = 0
+	}
+}
+
+func (t *TUI) draw(speed float64) {
+	rows, cols := terminalSize()
+	if rows < 5 {
+		rows = 5
+	}
+	status := t.dashboard.snapshot()
+	waiting := t.gate.Waiting()
+	pages, _ := t.transfers.counts()
+	t.mu.Lock()
+	queued, pending := t.queued, t.pending
+	t.mu.Unlock()
+
+	var lines []string
+	header := fmt.Sprintf("mango — %d chapters queued, %d pages pending — %d pages downloading, %s/s",
+		queued, pending, pages, formatBytes(uint64(speed)))
+	if t.gate.Paused() {
+		header += " — paused"
+	}
+	lines = append(lines, "\033[7m"+pad(header, cols)+"\033[0m", "")
+
+	// the chapters under the series they're of, with how far along the
+	// whole series is
+	var series string
+	for i, c := range status.Active {
+		if c.Series != series {
+			series = c.Series
+			done, total := 0, 0
+			for _, o := range status.Active[i:] {
+				if o.Series == series {
+					done, total = done+o.Done, total+o.Pages
+				}
+			}
+			lines = append(lines, fmt.Sprintf("%s %s %d/%d", t.progress(done, total), series, done, total))
+		}
+		lines = append(lines, fmt.Sprintf("  %s %s %d/%d", t.progress(c.Done, c.Pages), c.Chapter, c.Done, c.Pages))
+	}
+	if len(status.Active) == 0 {
+		lines = append(lines, "Nothing downloading.")
+	}
+
+	if len(waiting) > 0 {
+		lines = append(lines, "", "Waiting:")
+		first := 0
+		if t.selected >= tuiQueue {
+			first = t.selected - tuiQueue + 1
+		}
+		for i := first; i < len(waiting) && i < first+tuiQueue; i++ {
+			mark := "  "
+			if i == t.selected {
+				mark = "> "
+			}
+			lines = append(lines, mark+waiting[i].Series+" "+waiting[i].Chapter)
+		}
+		if more := len(waiting) - first - tuiQueue; more > 0 {
+			lines = append(lines, fmt.Sprintf("  and %d more", more))
+		}
+	}
+
+	if len(status.Errors) > 0 {
+		lines = append(lines, "", "Errors:")
+		errs := status.Errors
+		if len(errs) > tuiErrors {
+			errs = errs[len(errs)-tuiErrors:]
+		}
+		for _, e := range errs {
+			lines = append(lines, "\033[31m"+clip(e, cols)+"\033[0m")
+		}
+	}
+
+	footer := "p pause/resume   j/k pick a waiting chapter   s skip it"
+	// the log gets whatever room is left, newest at the bottom
+	room := rows - len(lines) - 3
+	if room > 0 {
+		lines = append(lines, "")
+		logLines := status.Log
+		if len(logLines) > room {
+			logLines = logLines[len(logLines)-room:]
+		}
+		lines = append(lines, logLines...)
+	}
+	if len(lines) > rows-2 {
+		lines = lines[:rows-2]
+	}
+	for len(lines) < rows-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, "\033[7m"+pad(footer, cols)+"\033[0m")
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		if !strings.HasPrefix(line, "\033[") {
+			line = clip(line, cols)
+		}
+		b.WriteString(line + "\033[K")
+	}
+	os.Stdout.WriteString(b.String())
+}
+
+// progress is a bar of done out of total.
+func (t *TUI) progress(done, total int) string {
+	const width = 20
+	filled := 0
+	if total > 0 {
+		filled = min(width*done/total, width)
+	}
+	return "[" + strings.Repeat(t.bar[len(t.bar)-1], filled) + strings.Repeat(t.bar[0], width-filled) + "]"
+}
+
+// clip cuts s down to at most n characters, right where they end.
+func clip(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// pad makes s exactly n characters.
+func pad(s string, n int) string {
+	s = clip(s, n)
+	return s + strings.Repeat(" ", n-utf8.RuneCountInString(s))
+}
+
+// rawTerminal has the terminal hand over keys as they're pressed, without
+// echoing them, and returns how to put it back.  If it can't, keys have to be
+// followed by Enter.
+func rawTerminal() (restore func()) {
+	stty := func(args ...string) (string, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+	state, err := stty("-g")
+	if err != nil {
+		return func() {}
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return func() {}
+	}
+	return func() { stty(state) }
+}
+
+// terminalSize is how many rows and columns the terminal has, or 24 by 80 if
+// it can't tell.
+func terminalSize() (rows, cols int) {
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err != nil {
+		return 24, 80
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return 24, 80
+	}
+	rows, err1 := strconv.Atoi(fields[0])
+	cols, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil || rows <= 0 || cols <= 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	commands["unpack"] = unpackCommand
+}
+
+// unpackCommand turns the archives in a library back into directories of
+// pages, the way PageSaver lays them out; the metadata files stay in them.
+func unpackCommand(args []string) {
+	fs := flag.NewFlagSet("unpack", flag.ExitOnError)
+	manifestFormat, manifestPath := manifestFlags(fs)
+	keep := fs.Bool("keep", false, "keep the archives after unpacking them")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango unpack [flags] [LIBRARY|ARCHIVE...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	roots := fs.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	var archives []string
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.EqualFold(filepath.Ext(path), ".cbz") {
+				archives = append(archives, path)
+			}
+			return nil
+		})
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	manifest, err := openManifest(*manifestFormat, *manifestPath, manifestUpdate)
+	if err != nil {
+		fatalln("cannot open manifest:", err)
+	}
+	entries := map[string]ManifestEntry{}
+	if manifest != nil {
+		defer manifest.Close()
+		all, err := manifest.Entries("")
+		if err != nil {
+			fatalln("cannot read manifest:", err)
+		}
+		for _, e := range all {
+			entries[filepath.Clean(e.Path)] = e
+		}
+	}
+
+	unpacked := 0
+	for _, archive := range archives {
+		dir := strings.TrimSuffix(archive, filepath.Ext(archive))
+		if isDir(dir) {
+			log.Printf("%s: %s already exists, skipping it", archive, dir)
+			continue
+		}
+		if err := unpackArchive(archive, dir); err != nil {
+			fatalf("%s: %s", archive, err)
+		}
+		fmt.Printf("%s -> %s\n", archive, dir)
+		unpacked++
+
+		if e, ok := entries[filepath.Clean(archive)]; ok {
+			e.Path, e.Hash, e.CID = dir, "", ""
+			if err := manifest.Add(e); err != nil {
+				log.Println("cannot update manifest:", err)
+			} else if err := manifest.Remove(archive); err != nil {
+				log.Println("cannot update manifest:", err)
+			}
+		}
+		if !*keep {
+			os.Remove(archive)
+		}
+	}
+	fmt.Printf("unpacked %d chapters\n", unpacked)
+}
+
+// unpackArchive extracts archive into dir, which only appears once it's all
+// there.
+func unpackArchive(archive, dir string) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmpdir, err := os.MkdirTemp(filepath.Dir(dir), ".mango-*.tmp")
+	if err != nil {
+		return err
+	}
+	for _, f := range r.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		// everything goes in the one directory, whatever the archive says,
+		// except for any kept HTML
+		name := filepath.Join(tmpdir, filepath.Base(filepath.FromSlash(f.Name)))
+		if isHTMLEntry(f.Name) {
+			name = filepath.Join(tmpdir, htmlDir, filepath.Base(f.Name))
+			os.MkdirAll(filepath.Dir(name), os.ModeDir|0770)
+		}
+		if err := extractFile(f, name); err != nil {
+			os.RemoveAll(tmpdir)
+			return err
+		}
+	}
+	os.Chmod(tmpdir, 0770)
+	if err := moveDir(tmpdir, dir); err != nil {
+		os.RemoveAll(tmpdir)
+		return err
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, name string) error {
+	in, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if !f.Modified.IsZero() {
+		defer os.Chtimes(name, f.Modified, f.Modified)
+	}
+	return out.Close()
+}
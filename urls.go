@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Query parameters that only say where a link was found.
+var trackingParams = []string{"utm_", "fbclid", "gclid", "ref"}
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range trackingParams {
+		if name == p || strings.HasSuffix(p, "_") && strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeURL cleans up a URL given on the command line: mobile hosts are
+// swapped for the desktop ones, whose pages the scrapers understand, and
+// trailing slashes, fragments and tracking parameters are dropped.
+func normalizeURL(u *url.URL) *url.URL {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	for _, prefix := range []string{"m.", "mobile."} {
+		if strings.HasPrefix(n.Host, prefix) && strings.Count(n.Host, ".") > 1 {
+			n.Host = strings.TrimPrefix(n.Host, prefix)
+		}
+	}
+	if n.Path != "/" {
+		n.Path = strings.TrimRight(n.Path, "/")
+		n.RawPath = strings.TrimRight(n.RawPath, "/")
+	}
+	n.Fragment, n.RawFragment = "", ""
+
+	query := n.Query()
+	for name := range query {
+		if isTrackingParam(name) {
+			query.Del(name)
+		}
+	}
+	n.RawQuery = query.Encode()
+	return &n
+}
+
+// urlKey is what two URLs of the same thing have in common; www. or not
+// makes no difference.
+func urlKey(u *url.URL) string {
+	k := *u
+	k.Scheme = ""
+	k.Host = strings.TrimPrefix(k.Host, "www.")
+	return k.String()
+}
+
+// dedupeURLs normalizes urls and drops the ones that were already given, in
+// another form or not.
+func dedupeURLs(urls []*url.URL) []*url.URL {
+	seen := map[string]bool{}
+	var unique []*url.URL
+	for _, u := range urls {
+		u = normalizeURL(u)
+		if k := urlKey(u); !seen[k] {
+			seen[k] = true
+			unique = append(unique, u)
+		}
+	}
+	return unique
+}
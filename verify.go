@@ -0,0 +1,242 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	commands["verify"] = verifyCommand
+}
+
+// verifyCommand checks every archive in a library: that it unzips, that its
+// page count agrees with ComicInfo.xml and the manifest, that every page
+// decodes and that the pages are in order however they're sorted.  Those out
+// of order can be fixed.
+func verifyCommand(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	manifestFormat, manifestPath := manifestFlags(fs)
+	fix := fs.Bool("fix", false, "rebuild archives whose pages are out of order, with their names padded to the same width")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango verify [flags] [LIBRARY]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	library := "."
+	if fs.NArg() > 0 {
+		library = fs.Arg(0)
+	}
+
+	var entries map[string]ManifestEntry
+	mode := manifestRead
+	if *fix {
+		mode = manifestUpdate
+	}
+	manifest, err := openManifest(*manifestFormat, *manifestPath, mode)
+	if err != nil {
+		fatalln("cannot open manifest:", err)
+	}
+	if manifest != nil {
+		all, err := manifest.Entries("")
+		if err != nil {
+			fatalln("cannot read manifest:", err)
+		}
+		entries = manifestPaths(all, manifestRoot(*manifestFormat, *manifestPath))
+	}
+
+	bad, archives, found := 0, 0, 0
+	err = filepath.Walk(library, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(path), ".cbz") {
+			return nil
+		}
+
+		entry, inManifest := entries[absPath(".", path)]
+		archives++
+		problems := verifyArchive(path)
+		if inManifest {
+			found++
+			problems = append(problems, verifyAgainstManifest(path, entry)...)
+		}
+		// the rest had better be fine before it's rebuilt
+		if order := archivePageOrder(path); len(order) > 0 && *fix && len(problems) == 0 {
+			if err := fixPageOrder(path); err != nil {
+				problems = append(problems, append(order, "cannot fix: "+err.Error())...)
+			} else {
+				fmt.Printf("%s: fixed the order of the pages\n", path)
+				if inManifest {
+					if entry.Hash, err = hashFile(path); err == nil {
+						entry.CID = ""
+						err = manifest.Add(entry)
+					}
+					if err != nil {
+						log.Println("cannot update manifest:", err)
+					}
+				}
+			}
+		} else {
+			problems = append(problems, order...)
+		}
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", path, p)
+		}
+		if len(problems) > 0 {
+			bad++
+		}
+		return nil
+	})
+	if manifest != nil {
+		manifest.Close()
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if manifest != nil && archives > 0 && found == 0 {
+		log.Println("none of the archives are in the manifest, so it could not be checked against; try -manifest")
+	}
+
+	if bad > 0 {
+		fmt.Printf("%d damaged or incomplete archives\n", bad)
+		os.Exit(1)
+	}
+}
+
+// isPageEntry tells whether an archive entry is a page; everything but the
+// metadata files is assumed to be one.
+func isPageEntry(name string) bool {
+	switch path.Base(name) {
+	case "ComicInfo.xml", "CoMet.xml":
+		return false
+	}
+	return !strings.HasSuffix(name, "/") && !isHTMLEntry(name)
+}
+
+// archivePageOrder tells what's wrong with the order of the pages in the
+// archive at path.
+func archivePageOrder(path string) []string {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil // verifyArchive says so
+	}
+	defer archive.Close()
+	var names []string
+	for _, f := range archive.File {
+		if isPageEntry(f.Name) {
+			names = append(names, f.Name)
+		}
+	}
+	return pageOrderProblems(names)
+}
+
+// fixPageOrder rebuilds the archive at path, which puts the pages in order
+// and pads their names.
+func fixPageOrder(path string) error {
+	info, err := archiveMetadata(path)
+	if err != nil {
+		return err
+	}
+	return reprocessArchive(path, Pipeline{}, false, info)
+}
+
+func verifyArchive(path string) (problems []string) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	defer archive.Close()
+
+	pages := 0
+	pageCount := -1
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", f.Name, err))
+			continue
+		}
+
+		switch {
+		case f.Name == "ComicInfo.xml":
+			var comicInfo struct {
+				PageCount int
+			}
+			if err := xml.NewDecoder(r).Decode(&comicInfo); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", f.Name, err))
+			} else if comicInfo.PageCount > 0 {
+				pageCount = comicInfo.PageCount
+			}
+
+		case isPageEntry(f.Name) && strings.HasSuffix(strings.ToLower(f.Name), ".jxl"):
+			pages++
+			if !isJPEGXL(r) {
+				problems = append(problems, fmt.Sprintf("%s: not a JPEG XL image", f.Name))
+			}
+
+		case isPageEntry(f.Name):
+			pages++
+			if _, _, err := image.Decode(r); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", f.Name, err))
+			}
+		}
+
+		// Reading it all is what checks the CRC
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", f.Name, err))
+		}
+		r.Close()
+	}
+
+	if pageCount >= 0 && pages != pageCount {
+		problems = append(problems, fmt.Sprintf("has %d pages, ComicInfo.xml says %d", pages, pageCount))
+	}
+	return
+}
+
+func verifyAgainstManifest(path string, entry ManifestEntry) (problems []string) {
+	if entry.Hash != "" {
+		hash, err := hashFile(path)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		if hash != entry.Hash {
+			problems = append(problems, "changed since it was downloaded")
+		}
+	}
+
+	// what should be in the archive; older manifests only know how many
+	// pages the chapter had and which failed
+	want := entry.Saved
+	if want == 0 {
+		want = entry.Pages - len(entry.Missing)
+	}
+	if want > 0 {
+		archive, err := zip.OpenReader(path)
+		if err != nil {
+			// already reported
+			return
+		}
+		defer archive.Close()
+
+		pages := 0
+		for _, f := range archive.File {
+			if isPageEntry(f.Name) {
+				pages++
+			}
+		}
+		if pages != want {
+			problems = append(problems, fmt.Sprintf("has %d pages, the manifest says %d", pages, want))
+		}
+	}
+	return
+}
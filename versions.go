@@ -0,0 +1,50 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Some chapters come in more than one version: colored, an official
+// translation, a fan one...  Sites rarely say so other than in the chapter's
+// name, e.g. "Romance Dawn (Colored)".
+var chapterVersions = []struct {
+	version string
+	re      *regexp.Regexp
+}{
+	{"colored", regexp.MustCompile(`(?i)\b(?:colou?red|full colou?r|digital colou?red)\b`)},
+	{"official", regexp.MustCompile(`(?i)\b(?:official|licensed)\b`)},
+	{"fan", regexp.MustCompile(`(?i)\b(?:fan[ -]?(?:tl|translat\w*|scan))\b`)},
+	{"raw", regexp.MustCompile(`(?i)\braws?\b`)},
+}
+
+// chapterVersion works out which version of a chapter info is, if it's
+// anything but the usual one; scrapers that know better set "version"
+// themselves.
+func chapterVersion(info Metadata) string {
+	if version, ok := info["version"].(string); ok {
+		return version
+	}
+	name, _ := info["chapterName"].(string)
+	for _, v := range chapterVersions {
+		if v.re.MatchString(name) {
+			return v.version
+		}
+	}
+	return ""
+}
+
+// versionRank is how far down preferred the chapter's version is; versions
+// that aren't there come last.
+func versionRank(preferred []string, info Metadata) int {
+	version, _ := info["version"].(string)
+	if version == "" {
+		version = "none"
+	}
+	for i, v := range preferred {
+		if strings.EqualFold(v, version) {
+			return i
+		}
+	}
+	return len(preferred)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WARCWriter is an http.RoundTripper that keeps a WARC 1.1 record of every
+// request and response going through it, the way web archives do; pywb,
+// ReplayWeb.page and the like can play the crawl back.  A name ending in .gz
+// gets each record compressed on its own, as usual.
+type WARCWriter struct {
+	Transport http.RoundTripper
+
+	mu   sync.Mutex
+	file *os.File
+	gzip bool
+}
+
+func OpenWARCWriter(path string, transport http.RoundTripper) (*WARCWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return nil, err
+	}
+	w := &WARCWriter{Transport: transport, file: file, gzip: strings.HasSuffix(path, ".gz")}
+
+	info := "software: mango\r\nformat: WARC File Format 1.1\r\n"
+	if err := w.writeRecord("warcinfo", "", "application/warc-fields", warcRecordID(), nil, []byte(info)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *WARCWriter) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := w.Transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	// The body has to be read to be recorded; whoever made the request
+	// gets a copy.
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	reqDump, err := httputil.DumpRequestOut(req, false)
+	if err != nil {
+		return nil, err
+	}
+	// the body is recorded the way it was received in the end, not chunked
+	// or compressed
+	head := *resp
+	head.TransferEncoding = nil
+	head.ContentLength = int64(len(body))
+	head.Body = http.NoBody
+	respDump, err := httputil.DumpResponse(&head, false)
+	if err != nil {
+		return nil, err
+	}
+
+	uri := req.URL.String()
+	respID := warcRecordID()
+	extra := []string{"WARC-Payload-Digest: " + warcDigest(body)}
+	if err := w.writeRecord("response", uri, "application/http; msgtype=response", respID, extra,
+		append(respDump, body...)); err != nil {
+		return nil, err
+	}
+	if err := w.writeRecord("request", uri, "application/http; msgtype=request", warcRecordID(),
+		[]string{"WARC-Concurrent-To: " + respID}, reqDump); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (w *WARCWriter) writeRecord(typ, uri, contentType, id string, extra []string, block []byte) error {
+	var rec bytes.Buffer
+	fmt.Fprintf(&rec, "WARC/1.1\r\n")
+	fmt.Fprintf(&rec, "WARC-Type: %s\r\n", typ)
+	fmt.Fprintf(&rec, "WARC-Record-ID: %s\r\n", id)
+	fmt.Fprintf(&rec, "WARC-Date: %s\r\n", time.Now().UTC().Format(time.RFC3339))
+	if uri != "" {
+		fmt.Fprintf(&rec, "WARC-Target-URI: %s\r\n", uri)
+	}
+	for _, h := range extra {
+		fmt.Fprintf(&rec, "%s\r\n", h)
+	}
+	fmt.Fprintf(&rec, "WARC-Block-Digest: %s\r\n", warcDigest(block))
+	fmt.Fprintf(&rec, "Content-Type: %s\r\n", contentType)
+	fmt.Fprintf(&rec, "Content-Length: %d\r\n\r\n", len(block))
+	rec.Write(block)
+	rec.WriteString("\r\n\r\n")
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.gzip {
+		_, err := w.file.Write(rec.Bytes())
+		return err
+	}
+	gz := gzip.NewWriter(w.file)
+	if _, err := gz.Write(rec.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (w *WARCWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+func warcRecordID() string {
+	return "<urn:uuid:" + newUUID() + ">"
+}
+
+// newUUID makes a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func warcDigest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}